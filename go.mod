@@ -5,6 +5,7 @@ go 1.25.1
 require (
 	fyne.io/fyne/v2 v2.7.0
 	github.com/mattn/go-sqlite3 v1.14.32
+	golang.org/x/text v0.22.0
 )
 
 require (
@@ -38,6 +39,5 @@ require (
 	golang.org/x/image v0.24.0 // indirect
 	golang.org/x/net v0.35.0 // indirect
 	golang.org/x/sys v0.30.0 // indirect
-	golang.org/x/text v0.22.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// REST API served by "spreadsheet serve" for one sheet (--sheet, by default the
+// first). All bodies are JSON.
+//
+//	GET    /api/schema             fields of the schema in use
+//	GET    /api/rows               ?view=NAME &filter=Field:op:value (repeatable)
+//	                               &sort=Field,-Other &limit=N &offset=N
+//	                               &q=SEARCH (words, "phrases", pre*, Field:term;
+//	                               best matches first unless sorted, see search.go)
+//	GET    /api/rows/{id}
+//	POST   /api/rows               create from an object of field values
+//	PUT    /api/rows/{id}          replace all fields (missing ones get defaults)
+//	PATCH  /api/rows/{id}          change the given fields only
+//	DELETE /api/rows/{id}
+//	GET    /api/views              also GET/PUT/DELETE /api/views/{id} and POST /api/views
+//
+// Errors are returned as {"error": "...", "fields": {"Name": "..."}}.
+
+// apiMaxBody limits request bodies
+const apiMaxBody = 4 << 20
+
+// apiError is an error with an HTTP status and optional per-field messages
+type apiError struct {
+	Status  int               `json:"-"`
+	Message string            `json:"error"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+func (e *apiError) Error() string { return e.Message }
+
+func apiErrorf(status int, format string, a ...interface{}) *apiError {
+	return &apiError{Status: status, Message: fmt.Sprintf(format, a...)}
+}
+
+// apiServer serves the REST API over one database
+type apiServer struct {
+	db     *sql.DB
+	sheet  int
+	schema []FieldDef
+	byName map[string]FieldDef
+}
+
+// newAPIServer returns the API handler for the rows and views of one sheet; it can be
+// served with http.ListenAndServe or httptest
+func newAPIServer(db *sql.DB, sheet int, schema []FieldDef) http.Handler {
+	s := &apiServer{db: db, sheet: sheet, schema: schema, byName: map[string]FieldDef{}}
+	for _, f := range schema {
+		s.byName[f.Name] = f
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/schema", s.handle(s.getSchema))
+	mux.HandleFunc("GET /api/rows", s.handle(s.listRows))
+	mux.HandleFunc("POST /api/rows", s.handle(s.createRow))
+	mux.HandleFunc("GET /api/rows/{id}", s.handle(s.getRow))
+	mux.HandleFunc("PUT /api/rows/{id}", s.handle(s.replaceRow))
+	mux.HandleFunc("PATCH /api/rows/{id}", s.handle(s.patchRow))
+	mux.HandleFunc("DELETE /api/rows/{id}", s.handle(s.deleteRow))
+	mux.HandleFunc("GET /api/views", s.handle(s.listViews))
+	mux.HandleFunc("POST /api/views", s.handle(s.createView))
+	mux.HandleFunc("GET /api/views/{id}", s.handle(s.getView))
+	mux.HandleFunc("PUT /api/views/{id}", s.handle(s.replaceView))
+	mux.HandleFunc("DELETE /api/views/{id}", s.handle(s.deleteView))
+	mux.HandleFunc("/", s.handle(func(r *http.Request) (int, interface{}, error) {
+		return 0, nil, apiErrorf(http.StatusNotFound, "no such endpoint %s %s", r.Method, r.URL.Path)
+	}))
+	return mux
+}
+
+// handle adapts a handler returning (status, body, error) and writes JSON responses
+func (s *apiServer) handle(h func(r *http.Request) (int, interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, body, err := h(r)
+		if err != nil {
+			var ae *apiError
+			if !errors.As(err, &ae) {
+				log.Printf("warning: %s %s: %v", r.Method, r.URL.Path, err)
+				ae = apiErrorf(http.StatusInternalServerError, "%v", err)
+			}
+			status, body = ae.Status, ae
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if body != nil {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(body); err != nil {
+				log.Printf("warning: writing response: %v", err)
+			}
+		}
+	}
+}
+
+// logRequests logs one line per request
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		h.ServeHTTP(w, r)
+		log.Printf("%s %s (%s)", r.Method, r.URL.RequestURI(), time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// pathID reads the {id} path parameter
+func pathID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		return 0, apiErrorf(http.StatusBadRequest, "invalid id %q", r.PathValue("id"))
+	}
+	return id, nil
+}
+
+// decodeBody reads a JSON request body into v
+func decodeBody(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, apiMaxBody))
+	if err := dec.Decode(v); err != nil {
+		return apiErrorf(http.StatusBadRequest, "invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+// rowJSON is the API representation of a row: its fields plus "ID"
+func (s *apiServer) rowJSON(id int, data map[string]interface{}) map[string]interface{} {
+	return attachIDToDataMap(id, mergeWithSchema(s.schema, data))
+}
+
+func (s *apiServer) getSchema(r *http.Request) (int, interface{}, error) {
+	return http.StatusOK, s.schema, nil
+}
+
+// listQuery turns the query parameters of GET /api/rows into a view
+func (s *apiServer) listQuery(r *http.Request) (View, error) {
+	q := r.URL.Query()
+	v := View{Name: "All"}
+	if name := q.Get("view"); name != "" {
+		views, err := getAllViews(s.db, s.sheet)
+		if err != nil {
+			return v, err
+		}
+		found := false
+		for _, sv := range views {
+			if sv.Name == name {
+				v, found = sv, true
+				break
+			}
+		}
+		if !found {
+			return v, apiErrorf(http.StatusNotFound, "no view named %q", name)
+		}
+	}
+	for _, f := range q["filter"] {
+		parts := strings.SplitN(f, ":", 3)
+		if len(parts) < 2 {
+			return v, apiErrorf(http.StatusBadRequest, "filter %q is not Field:op:value", f)
+		}
+		flt := ViewFilter{Field: parts[0], Op: parts[1]}
+		if len(parts) == 3 {
+			flt.Value = parts[2]
+		}
+		v.Filters = append(v.Filters, flt)
+	}
+	v.Search = q.Get("q")
+	if sortParam := q.Get("sort"); sortParam != "" {
+		// explicit sort keys replace the view's
+		v.Sort = nil
+		for _, k := range strings.Split(sortParam, ",") {
+			k = strings.TrimSpace(k)
+			if k == "" {
+				continue
+			}
+			desc := strings.HasPrefix(k, "-")
+			v.Sort = append(v.Sort, SortKey{Field: strings.TrimPrefix(k, "-"), Desc: desc})
+		}
+	}
+	if _, _, _, err := buildViewQuery(s.schema, v); err != nil {
+		return v, apiErrorf(http.StatusBadRequest, "%v", err)
+	}
+	return v, nil
+}
+
+func (s *apiServer) listRows(r *http.Request) (int, interface{}, error) {
+	v, err := s.listQuery(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	limit, offset := 0, 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			return 0, nil, apiErrorf(http.StatusBadRequest, "invalid limit %q", l)
+		}
+	}
+	if o := r.URL.Query().Get("offset"); o != "" {
+		if offset, err = strconv.Atoi(o); err != nil || offset < 0 {
+			return 0, nil, apiErrorf(http.StatusBadRequest, "invalid offset %q", o)
+		}
+	}
+
+	rows, total, err := getViewRowsPage(s.db, s.sheet, s.schema, v, limit, offset)
+	if err != nil {
+		return 0, nil, err
+	}
+	show := map[string]bool{}
+	for _, c := range v.Columns {
+		show[c] = true
+	}
+	out := []map[string]interface{}{}
+	for _, row := range rows {
+		obj := s.rowJSON(row.ID, row.Data)
+		if len(show) > 0 {
+			for k := range obj {
+				if k != "ID" && !show[k] {
+					delete(obj, k)
+				}
+			}
+		}
+		out = append(out, obj)
+	}
+	return http.StatusOK, map[string]interface{}{
+		"rows":   out,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	}, nil
+}
+
+// loadRow returns the data of row id or a 404 error if it is not a row of the sheet
+func (s *apiServer) loadRow(id int) (map[string]interface{}, error) {
+	data, err := loadRowData(s.db, id)
+	if err != nil {
+		return nil, err
+	}
+	sheet, err := recordSheet(s.db, "entries", id)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil || sheet != s.sheet {
+		return nil, apiErrorf(http.StatusNotFound, "row %d not found", id)
+	}
+	return data, nil
+}
+
+func (s *apiServer) getRow(r *http.Request) (int, interface{}, error) {
+	id, err := pathID(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := s.loadRow(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := fillLookups(s.db, s.sheet, s.schema, []Row{{ID: id, Data: data}}); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, s.rowJSON(id, data), nil
+}
+
+// checkValues converts the values of a request body to the schema types and checks
+// the validation rules; exceptID is the row being written (0 for new rows).
+// "ID" and formula fields are ignored, unknown fields are rejected.
+func (s *apiServer) checkValues(body map[string]interface{}, full bool, exceptID int) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	probs := map[string]string{}
+	for k, v := range body {
+		f, ok := s.byName[k]
+		if !ok {
+			probs[k] = "unknown field"
+			continue
+		}
+		if isComputedField(f) || (f.Type == "int" && strings.EqualFold(f.Name, "ID")) {
+			continue
+		}
+		nv, err := normalizeFieldValue(f, v)
+		if err != nil {
+			probs[k] = err.Error()
+			continue
+		}
+		out[k] = nv
+	}
+
+	for _, f := range s.schema {
+		if isComputedField(f) || (f.Type == "int" && strings.EqualFold(f.Name, "ID")) {
+			continue
+		}
+		v, present := out[f.Name]
+		if !present && !full {
+			continue
+		}
+		if !present {
+			v = defaultFieldValue(f)
+		}
+		if _, bad := probs[f.Name]; bad {
+			continue
+		}
+		if err := validateFieldValue(f, v); err != nil {
+			probs[f.Name] = err.Error()
+			continue
+		}
+		if f.Type == "relation" {
+			target, err := relationTarget(s.db, s.sheet, f)
+			if err != nil {
+				probs[f.Name] = err.Error()
+				continue
+			}
+			missing, err := missingRelationID(s.db, target, f, v)
+			if err != nil {
+				return nil, err
+			}
+			if missing != 0 {
+				probs[f.Name] = fmt.Sprintf("row %d does not exist", missing)
+				continue
+			}
+		}
+		if f.Unique {
+			taken, err := valueTaken(s.db, s.sheet, f, v, exceptID)
+			if err != nil {
+				return nil, err
+			}
+			if taken {
+				probs[f.Name] = "value is not unique"
+			}
+		}
+	}
+	if len(probs) > 0 {
+		return nil, &apiError{Status: http.StatusUnprocessableEntity, Message: "invalid field values", Fields: probs}
+	}
+	return out, nil
+}
+
+func (s *apiServer) createRow(r *http.Request) (int, interface{}, error) {
+	var body map[string]interface{}
+	if err := decodeBody(r, &body); err != nil {
+		return 0, nil, err
+	}
+	values, err := s.checkValues(body, true, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	data := mergeWithSchema(s.schema, values)
+	computeFormulas(s.schema, data)
+	id, err := undoableInsertRow(s.db, s.sheet, data)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, s.rowJSON(int(id), data), nil
+}
+
+func (s *apiServer) replaceRow(r *http.Request) (int, interface{}, error) {
+	id, err := pathID(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := s.loadRow(id); err != nil {
+		return 0, nil, err
+	}
+	var body map[string]interface{}
+	if err := decodeBody(r, &body); err != nil {
+		return 0, nil, err
+	}
+	values, err := s.checkValues(body, true, id)
+	if err != nil {
+		return 0, nil, err
+	}
+	data := mergeWithSchema(s.schema, values)
+	computeFormulas(s.schema, data)
+	if err := undoableReplaceRow(s.db, id, data); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, s.rowJSON(id, data), nil
+}
+
+func (s *apiServer) patchRow(r *http.Request) (int, interface{}, error) {
+	id, err := pathID(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := s.loadRow(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	var body map[string]interface{}
+	if err := decodeBody(r, &body); err != nil {
+		return 0, nil, err
+	}
+	values, err := s.checkValues(body, false, id)
+	if err != nil {
+		return 0, nil, err
+	}
+	for k, v := range values {
+		data[k] = v
+	}
+	computeFormulas(s.schema, data)
+	if err := undoableReplaceRow(s.db, id, data); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, s.rowJSON(id, data), nil
+}
+
+func (s *apiServer) deleteRow(r *http.Request) (int, interface{}, error) {
+	id, err := pathID(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := s.loadRow(id); err != nil {
+		return 0, nil, err
+	}
+	if err := undoableDeleteRow(s.db, id); err != nil {
+		var blocked *deleteBlockedError
+		if errors.As(err, &blocked) {
+			return 0, nil, apiErrorf(http.StatusConflict, "%v", err)
+		}
+		return 0, nil, err
+	}
+	return http.StatusNoContent, nil, nil
+}
+
+func (s *apiServer) listViews(r *http.Request) (int, interface{}, error) {
+	views, err := getAllViews(s.db, s.sheet)
+	if err != nil {
+		return 0, nil, err
+	}
+	if views == nil {
+		views = []View{}
+	}
+	return http.StatusOK, views, nil
+}
+
+// findView returns the view with the given id or a 404 error
+func (s *apiServer) findView(id int) (View, error) {
+	views, err := getAllViews(s.db, s.sheet)
+	if err != nil {
+		return View{}, err
+	}
+	for _, v := range views {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+	return View{}, apiErrorf(http.StatusNotFound, "view %d not found", id)
+}
+
+func (s *apiServer) getView(r *http.Request) (int, interface{}, error) {
+	id, err := pathID(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	v, err := s.findView(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, v, nil
+}
+
+// checkView validates a view from a request body
+func (s *apiServer) checkView(v View) error {
+	if strings.TrimSpace(v.Name) == "" {
+		return &apiError{Status: http.StatusUnprocessableEntity, Message: "invalid view", Fields: map[string]string{"Name": "required"}}
+	}
+	for _, c := range v.Columns {
+		if _, ok := s.byName[c]; !ok {
+			return &apiError{Status: http.StatusUnprocessableEntity, Message: "invalid view", Fields: map[string]string{"Columns": fmt.Sprintf("unknown field %q", c)}}
+		}
+	}
+	for _, g := range v.Form {
+		for _, c := range g.Fields {
+			if _, ok := s.byName[c]; !ok {
+				return &apiError{Status: http.StatusUnprocessableEntity, Message: "invalid view", Fields: map[string]string{"Form": fmt.Sprintf("unknown field %q", c)}}
+			}
+		}
+	}
+	if _, _, _, err := buildViewQuery(s.schema, v); err != nil {
+		return apiErrorf(http.StatusUnprocessableEntity, "%v", err)
+	}
+	return nil
+}
+
+func (s *apiServer) createView(r *http.Request) (int, interface{}, error) {
+	var v View
+	if err := decodeBody(r, &v); err != nil {
+		return 0, nil, err
+	}
+	v.ID = 0
+	if err := s.checkView(v); err != nil {
+		return 0, nil, err
+	}
+	id, err := undoableSaveView(s.db, s.sheet, v)
+	if err != nil {
+		return 0, nil, err
+	}
+	v.ID = int(id)
+	return http.StatusCreated, v, nil
+}
+
+func (s *apiServer) replaceView(r *http.Request) (int, interface{}, error) {
+	id, err := pathID(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := s.findView(id); err != nil {
+		return 0, nil, err
+	}
+	var v View
+	if err := decodeBody(r, &v); err != nil {
+		return 0, nil, err
+	}
+	v.ID = id
+	if err := s.checkView(v); err != nil {
+		return 0, nil, err
+	}
+	if _, err := undoableSaveView(s.db, s.sheet, v); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, v, nil
+}
+
+func (s *apiServer) deleteView(r *http.Request) (int, interface{}, error) {
+	id, err := pathID(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := s.findView(id); err != nil {
+		return 0, nil, err
+	}
+	if err := undoableDeleteView(s.db, id); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusNoContent, nil, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestDB opens a fresh database file in a temporary directory
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := openDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestSheet adds a sheet with schema to db
+func newTestSheet(t *testing.T, db *sql.DB, name string, schema []FieldDef) Sheet {
+	t.Helper()
+	s, err := createSheet(db, name, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+var apiTestSchema = []FieldDef{
+	{Name: "ID", Type: "int"},
+	{Name: "Name", Type: "string", Unique: true},
+	{Name: "Age", Type: "int"},
+	{Name: "Done", Type: "bool"},
+	{Name: "Parent", Type: "relation"},
+}
+
+// apiCall sends a request to h and returns the status and the decoded JSON body
+func apiCall(t *testing.T, h http.Handler, method, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var out map[string]interface{}
+	if rec.Body.Len() > 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%s %s: invalid JSON response %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code, out
+}
+
+// newTestAPI returns the API of a new sheet holding rows created through POST,
+// and the ids of those rows
+func newTestAPI(t *testing.T, rows ...string) (*sql.DB, Sheet, http.Handler, []int) {
+	t.Helper()
+	db := openTestDB(t)
+	s := newTestSheet(t, db, "People", apiTestSchema)
+	h := newAPIServer(db, s.ID, apiTestSchema)
+	var ids []int
+	for _, body := range rows {
+		status, row := apiCall(t, h, "POST", "/api/rows", body)
+		if status != http.StatusCreated {
+			t.Fatalf("POST %s: status %d, want %d (%v)", body, status, http.StatusCreated, row)
+		}
+		ids = append(ids, int(row["ID"].(float64)))
+	}
+	return db, s, h, ids
+}
+
+func TestAPIListRows(t *testing.T) {
+	_, _, h, _ := newTestAPI(t,
+		`{"Name": "Ann", "Age": 31}`,
+		`{"Name": "Bob", "Age": 25}`,
+		`{"Name": "Cid", "Age": 47}`,
+		`{"Name": "Dee", "Age": 19}`,
+		`{"Name": "Eve", "Age": 38}`,
+	)
+	tests := []struct {
+		query string
+		names []string
+		total int
+	}{
+		{"", []string{"Ann", "Bob", "Cid", "Dee", "Eve"}, 5},
+		{"?sort=-Age", []string{"Cid", "Eve", "Ann", "Bob", "Dee"}, 5},
+		{"?filter=Age:gt:30&sort=Name", []string{"Ann", "Cid", "Eve"}, 3},
+		{"?filter=Age:gt:30&filter=Name:contains:e&sort=Name", []string{"Eve"}, 1},
+		{"?sort=Age&limit=2", []string{"Dee", "Bob"}, 5},
+		{"?sort=Age&limit=2&offset=2", []string{"Ann", "Eve"}, 5},
+		{"?sort=Age&offset=4", []string{"Cid"}, 5},
+		{"?filter=Age:lt:10", nil, 0},
+	}
+	for _, tt := range tests {
+		status, body := apiCall(t, h, "GET", "/api/rows"+tt.query, "")
+		if status != http.StatusOK {
+			t.Errorf("GET %s: status %d (%v)", tt.query, status, body)
+			continue
+		}
+		var names []string
+		for _, r := range body["rows"].([]interface{}) {
+			names = append(names, r.(map[string]interface{})["Name"].(string))
+		}
+		if fmt.Sprint(names) != fmt.Sprint(tt.names) {
+			t.Errorf("GET %s: rows %v, want %v", tt.query, names, tt.names)
+		}
+		if total := int(body["total"].(float64)); total != tt.total {
+			t.Errorf("GET %s: total %d, want %d", tt.query, total, tt.total)
+		}
+	}
+
+	for _, query := range []string{"?filter=Age", "?filter=Nope:eq:1", "?sort=Nope", "?limit=-1", "?offset=x"} {
+		if status, _ := apiCall(t, h, "GET", "/api/rows"+query, ""); status != http.StatusBadRequest {
+			t.Errorf("GET %s: status %d, want %d", query, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAPIRowLifecycle(t *testing.T) {
+	_, _, h, ids := newTestAPI(t, `{"Name": "Ann", "Age": 31, "Done": true}`)
+	path := fmt.Sprintf("/api/rows/%d", ids[0])
+
+	status, row := apiCall(t, h, "GET", path, "")
+	if status != http.StatusOK || row["Name"] != "Ann" || row["Age"] != 31.0 || row["Done"] != true {
+		t.Fatalf("GET: status %d, row %v", status, row)
+	}
+
+	// PATCH changes the given field only
+	status, row = apiCall(t, h, "PATCH", path, `{"Age": 32}`)
+	if status != http.StatusOK || row["Name"] != "Ann" || row["Age"] != 32.0 || row["Done"] != true {
+		t.Fatalf("PATCH: status %d, row %v", status, row)
+	}
+
+	// PUT resets the missing fields to their defaults
+	status, row = apiCall(t, h, "PUT", path, `{"Name": "Anna"}`)
+	if status != http.StatusOK || row["Name"] != "Anna" || row["Age"] != 0.0 || row["Done"] != false {
+		t.Fatalf("PUT: status %d, row %v", status, row)
+	}
+	if _, row = apiCall(t, h, "GET", path, ""); row["Name"] != "Anna" || row["Age"] != 0.0 {
+		t.Fatalf("GET after PUT: row %v", row)
+	}
+
+	if status, body := apiCall(t, h, "DELETE", path, ""); status != http.StatusNoContent || body != nil {
+		t.Fatalf("DELETE: status %d, body %v", status, body)
+	}
+	if status, _ := apiCall(t, h, "GET", path, ""); status != http.StatusNotFound {
+		t.Fatalf("GET after DELETE: status %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestAPINotFound(t *testing.T) {
+	db, _, h, _ := newTestAPI(t, `{"Name": "Ann"}`)
+	other := newTestSheet(t, db, "Other", apiTestSchema)
+	otherID, err := insertRow(db, other.ID, map[string]interface{}{"Name": "Zed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{int(otherID), 9999} {
+		path := fmt.Sprintf("/api/rows/%d", id)
+		for _, method := range []string{"GET", "PUT", "PATCH", "DELETE"} {
+			if status, _ := apiCall(t, h, method, path, `{"Age": 1}`); status != http.StatusNotFound {
+				t.Errorf("%s %s: status %d, want %d", method, path, status, http.StatusNotFound)
+			}
+		}
+	}
+	if data, err := loadRowData(db, int(otherID)); err != nil || data["Name"] != "Zed" {
+		t.Errorf("row of the other sheet changed: %v, %v", data, err)
+	}
+}
+
+func TestAPIMalformedBody(t *testing.T) {
+	_, _, h, ids := newTestAPI(t, `{"Name": "Ann"}`)
+	path := fmt.Sprintf("/api/rows/%d", ids[0])
+	tests := []struct{ method, path, body string }{
+		{"POST", "/api/rows", `{"Name": `},
+		{"POST", "/api/rows", `["Ann"]`},
+		{"PUT", path, `not json`},
+		{"PATCH", path, ``},
+		{"GET", "/api/rows/abc", ``},
+	}
+	for _, tt := range tests {
+		status, body := apiCall(t, h, tt.method, tt.path, tt.body)
+		if status != http.StatusBadRequest || body["error"] == "" {
+			t.Errorf("%s %s %q: status %d, body %v; want %d with an error", tt.method, tt.path, tt.body, status, body, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAPIInvalidFields(t *testing.T) {
+	_, _, h, ids := newTestAPI(t, `{"Name": "Ann"}`, `{"Name": "Bob"}`)
+	ann := fmt.Sprintf("/api/rows/%d", ids[0])
+	tests := []struct {
+		method, path, body string
+		field, msg         string // the expected entry of "fields"
+	}{
+		{"POST", "/api/rows", `{"Name": "Cid", "Nope": 1}`, "Nope", "unknown field"},
+		{"POST", "/api/rows", `{"Name": "Cid", "Age": "old"}`, "Age", ""},
+		{"POST", "/api/rows", `{"Name": "Cid", "Age": 1.5}`, "Age", "not an integer"},
+		{"POST", "/api/rows", `{"Name": "Cid", "Done": "yes please"}`, "Done", ""},
+		{"POST", "/api/rows", `{"Name": "Bob"}`, "Name", "value is not unique"},
+		{"PATCH", ann, `{"Name": "Bob"}`, "Name", "value is not unique"},
+		{"PUT", ann, `{"Name": "Bob"}`, "Name", "value is not unique"},
+		{"POST", "/api/rows", `{"Name": "Cid", "Parent": 9999}`, "Parent", "row 9999 does not exist"},
+	}
+	for _, tt := range tests {
+		status, body := apiCall(t, h, tt.method, tt.path, tt.body)
+		if status != http.StatusUnprocessableEntity {
+			t.Errorf("%s %s: status %d, want %d (%v)", tt.method, tt.body, status, http.StatusUnprocessableEntity, body)
+			continue
+		}
+		fields, _ := body["fields"].(map[string]interface{})
+		msg, ok := fields[tt.field].(string)
+		if !ok || (tt.msg != "" && msg != tt.msg) {
+			t.Errorf("%s %s: fields %v, want %s: %q", tt.method, tt.body, fields, tt.field, tt.msg)
+		}
+	}
+
+	// the row keeps its value when a change is rejected, and may keep it on PATCH
+	if status, row := apiCall(t, h, "PATCH", ann, `{"Name": "Ann", "Parent": `+fmt.Sprint(ids[1])+`}`); status != http.StatusOK || row["Parent"] != float64(ids[1]) {
+		t.Errorf("PATCH with its own unique value: status %d, row %v", status, row)
+	}
+	if status, body := apiCall(t, h, "GET", "/api/rows", ""); status != http.StatusOK || body["total"] != 2.0 {
+		t.Errorf("rows after rejected writes: status %d, body %v", status, body)
+	}
+}
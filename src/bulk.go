@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Bulk actions work on the rows checked in the grid. Each one runs in a single
+// transaction recorded as one undo step.
+
+// undoableDeleteRows deletes rows (and the rows cascading from them, see deleteRows)
+// as one undo step
+func undoableDeleteRows(db *sql.DB, ids []int) error {
+	return withUndo(db, fmt.Sprintf("Delete %d rows", len(ids)), "", func(tx *sql.Tx, rec *undoRecorder) error {
+		return deleteRows(tx, rec, ids)
+	})
+}
+
+// bulkSetField sets field to value in every row of ids
+func bulkSetField(db *sql.DB, schema []FieldDef, ids []int, field string, value interface{}) error {
+	changes := make([]cellChange, len(ids))
+	for i, id := range ids {
+		changes[i] = cellChange{ID: id, Field: field, Value: value}
+	}
+	return undoableSetCells(db, schema, fmt.Sprintf("Set %s in %d rows", field, len(ids)), "", changes)
+}
+
+// bulkListItem adds item to the []string field of every row of ids that doesn't
+// hold it yet, or with remove takes it out of every row (ignoring case)
+func bulkListItem(db *sql.DB, schema []FieldDef, ids []int, field, item string, remove bool) error {
+	item = strings.TrimSpace(item)
+	if item == "" {
+		return fmt.Errorf("the item is empty")
+	}
+	label := fmt.Sprintf("Add %q to %s", item, field)
+	if remove {
+		label = fmt.Sprintf("Remove %q from %s", item, field)
+	}
+	return withUndo(db, label, "", func(tx *sql.Tx, rec *undoRecorder) error {
+		for _, id := range ids {
+			data, err := loadRowData(tx, id)
+			if err != nil {
+				return err
+			}
+			if data == nil {
+				return fmt.Errorf("row %d no longer exists", id)
+			}
+			list := toStringList(data[field])
+			found := false
+			var out []string
+			for _, it := range list {
+				if strings.EqualFold(it, item) {
+					found = true
+					if remove {
+						continue
+					}
+				}
+				out = append(out, it)
+			}
+			if found != remove {
+				continue // nothing to add or remove
+			}
+			if !remove {
+				out = append(out, item)
+			}
+			if out == nil {
+				out = []string{}
+			}
+			if err := rec.touch("entries", id); err != nil {
+				return err
+			}
+			data[field] = out
+			values := computeFormulas(schema, data)
+			values[field] = out
+			if err := updateFields(tx, id, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// bulkDuplicateRows inserts a copy of every row of ids into sheet and returns the new ids
+func bulkDuplicateRows(db *sql.DB, sheet int, ids []int) (created []int, err error) {
+	err = withUndo(db, fmt.Sprintf("Duplicate %d rows", len(ids)), "", func(tx *sql.Tx, rec *undoRecorder) error {
+		created = nil
+		for _, id := range ids {
+			data, err := loadRowData(tx, id)
+			if err != nil {
+				return err
+			}
+			if data == nil {
+				return fmt.Errorf("row %d no longer exists", id)
+			}
+			newID, err := insertRow(tx, sheet, data)
+			if err != nil {
+				return err
+			}
+			rec.created("entries", int(newID))
+			created = append(created, int(newID))
+		}
+		return nil
+	})
+	return created, err
+}
@@ -0,0 +1,698 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// cliUsage is printed by "help" and for unknown commands
+const cliUsage = `usage: spreadsheet [--db FILE] [--config FILE] [--schema stored|file|merge] [--sheet NAME]
+                   <command> [flags] [args]
+
+Without a command the GUI starts. Flags go before the arguments.
+--db and --config default to $SPREADSHEET_DB and $SPREADSHEET_CONFIG,
+then to data.db and config.json in the working directory.
+--schema picks the schema stored in the database, the config's (default for
+commands; the GUI asks) or a merge of both when they differ. config.json
+describes the first sheet; commands work on the first sheet unless --sheet
+names another one.
+
+commands:
+  list [--view NAME] [--format table|json]     list the rows (of a saved view)
+  get [--format table|json] ID                 show one row
+  set ID FIELD VALUE                           change a field; list items are separated by ";"
+  add-row [FIELD=VALUE ...]                    add a row and print its id
+  delete-row ID [ID ...]                       delete rows
+  views [--format table|json]                  list the saved views
+  sheets [--format table|json]                 list the sheets
+  import [--mode append|replace|upsert] [--key FIELD] [--list-sep SEP] [--dry-run] FILE
+                                               import a JSON export (named sheets go into the sheet
+                                               of that name) or a .csv/.tsv file
+  export [--format json|csv|tsv] [--view NAME] [FILE]
+                                               export to FILE (format from its extension) or stdout;
+                                               json holds every sheet
+  migrate [--apply]                            preview or apply the schema migration
+  serve [--addr HOST:PORT]                     serve the REST API (see api.go)
+`
+
+// errUsage marks errors caused by wrong arguments (exit status 2)
+var errUsage = errors.New("usage")
+
+// cliContext is what every command works on
+type cliContext struct {
+	db     *sql.DB
+	sheet  Sheet
+	schema []FieldDef
+	out    io.Writer
+	errOut io.Writer
+}
+
+var cliCommands = map[string]func(c *cliContext, args []string) error{
+	"list":       cliList,
+	"get":        cliGet,
+	"set":        cliSet,
+	"add-row":    cliAddRow,
+	"delete-row": cliDeleteRow,
+	"views":      cliViews,
+	"import":     cliImport,
+	"export":     cliExport,
+	"migrate":    cliMigrate,
+	"serve":      cliServe,
+	"sheets":     cliSheets,
+}
+
+// runCLI runs a headless subcommand and returns the process exit status
+func runCLI(args []string, opts appOptions, stdout, stderr io.Writer) int {
+	name := args[0]
+	if name == "help" || name == "-h" || name == "--help" {
+		fmt.Fprint(stdout, cliUsage)
+		return 0
+	}
+	cmd, ok := cliCommands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", name, cliUsage)
+		return 2
+	}
+
+	schema, err := loadConfig(opts.ConfigPath)
+	if err != nil {
+		fmt.Fprintln(stderr, "failed to load config:", err)
+		return 1
+	}
+	db := initializeDB(opts.DBPath)
+	defer db.Close()
+	sheet, err := getSheet(db, 0)
+	if opts.Sheet != "" {
+		sheet, err = sheetByName(db, opts.Sheet)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+	// without --schema config.json is used and the database is checked against it
+	if schema, err = resolveSchema(db, sheet.ID, schema, opts.Schema); err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	c := &cliContext{db: db, sheet: sheet, schema: schema, out: stdout, errOut: stderr}
+	if name != "migrate" {
+		if err := c.checkSchema(); err != nil {
+			fmt.Fprintln(stderr, "error:", err)
+			return 1
+		}
+	}
+
+	if err := cmd(c, args[1:]); err != nil {
+		if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+			if !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintln(stderr, err)
+			}
+			return 2
+		}
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+// checkSchema refuses to work on a database whose rows need a schema migration;
+// a version change that doesn't touch the rows is recorded right away.
+func (c *cliContext) checkSchema() error {
+	m, err := planSchemaMigration(c.db, c.sheet.ID, c.schema)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		if m.NeedsData() {
+			return fmt.Errorf("config.json differs from the schema stored in the database; review it with \"spreadsheet migrate\"")
+		}
+		if _, err := applySchemaMigration(c.db, m); err != nil {
+			return err
+		}
+	}
+	return recomputeFormulas(c.db, c.sheet.ID, c.schema)
+}
+
+// row returns the data of row id, which must be a row of the sheet
+func (c *cliContext) row(id int) (map[string]interface{}, error) {
+	data, err := loadRowData(c.db, id)
+	if err != nil {
+		return nil, err
+	}
+	sheet, err := recordSheet(c.db, "entries", id)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil || sheet != c.sheet.ID {
+		return nil, fmt.Errorf("row %d not found in sheet %s", id, c.sheet.Name)
+	}
+	return data, nil
+}
+
+// newFlags returns a flag set that reports errors instead of exiting
+func (c *cliContext) newFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.errOut)
+	return fs
+}
+
+// usageError wraps a message as an errUsage error
+func usageError(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{errUsage}, a...)...)
+}
+
+// field looks up a schema field by name or label (case-insensitive)
+func (c *cliContext) field(name string) (FieldDef, error) {
+	for _, f := range c.schema {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	for _, f := range c.schema {
+		if strings.EqualFold(f.Name, name) || strings.EqualFold(f.Label, name) {
+			return f, nil
+		}
+	}
+	return FieldDef{}, fmt.Errorf("unknown field %q", name)
+}
+
+// view returns the saved view with the given name; "" is the implicit "All" view
+func (c *cliContext) view(name string) (View, error) {
+	if name == "" {
+		return View{Name: "All"}, nil
+	}
+	views, err := getAllViews(c.db, c.sheet.ID)
+	if err != nil {
+		return View{}, err
+	}
+	for _, v := range views {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+	return View{}, fmt.Errorf("no view named %q", name)
+}
+
+// columns returns the fields shown by view v, in schema order
+func (c *cliContext) columns(v View) []FieldDef {
+	if len(v.Columns) == 0 {
+		return c.schema
+	}
+	show := map[string]bool{}
+	for _, n := range v.Columns {
+		show[n] = true
+	}
+	var out []FieldDef
+	for _, f := range c.schema {
+		if show[f.Name] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// parseID reads a row id argument
+func parseID(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil || id <= 0 {
+		return 0, usageError("%q is not a row id", s)
+	}
+	return id, nil
+}
+
+// parseFieldArg converts a command line value for a writable field
+func (c *cliContext) parseFieldArg(name, value string) (FieldDef, interface{}, error) {
+	f, err := c.field(name)
+	if err != nil {
+		return f, nil, err
+	}
+	if isComputedField(f) || (f.Type == "int" && strings.EqualFold(f.Name, "ID")) {
+		return f, nil, fmt.Errorf("field %s is read-only", f.Name)
+	}
+	v, err := parseFieldValue(f, value, ";")
+	if err != nil {
+		return f, nil, fmt.Errorf("%s: %v", f.Name, err)
+	}
+	if err := validateFieldValue(f, v); err != nil {
+		fmt.Fprintf(c.errOut, "warning: %s: %v\n", f.Name, err)
+	}
+	if f.Type == "relation" {
+		target, err := relationTarget(c.db, c.sheet.ID, f)
+		if err != nil {
+			return f, nil, err
+		}
+		missing, err := missingRelationID(c.db, target, f, v)
+		if err != nil {
+			return f, nil, err
+		}
+		if missing != 0 {
+			return f, nil, fmt.Errorf("%s: row %d does not exist", f.Name, missing)
+		}
+	}
+	return f, v, nil
+}
+
+// cellText renders a field of a row for table output
+func cellText(f FieldDef, r Row) string {
+	if f.Type == "int" && strings.EqualFold(f.Name, "ID") {
+		return strconv.Itoa(r.ID)
+	}
+	s := formatFieldValue(f, r.Data[f.Name], "; ")
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+// printTable writes tab-aligned columns
+func printTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	return tw.Flush()
+}
+
+// printJSON writes v as indented JSON
+func printJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// checkFormat validates a --format value
+func checkFormat(format string, allowed ...string) error {
+	for _, a := range allowed {
+		if format == a {
+			return nil
+		}
+	}
+	return usageError("unknown format %q (use %s)", format, strings.Join(allowed, " or "))
+}
+
+func cliList(c *cliContext, args []string) error {
+	fs := c.newFlags("list")
+	viewName := fs.String("view", "", "saved view to list")
+	format := fs.String("format", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format, "table", "json"); err != nil {
+		return err
+	}
+	v, err := c.view(*viewName)
+	if err != nil {
+		return err
+	}
+	rows, err := getViewRows(c.db, c.sheet.ID, c.schema, v)
+	if err != nil {
+		return err
+	}
+	cols := c.columns(v)
+
+	if *format == "json" {
+		out := []map[string]interface{}{}
+		for _, r := range rows {
+			data := mergeWithSchema(c.schema, r.Data)
+			obj := map[string]interface{}{}
+			for _, f := range cols {
+				obj[f.Name] = data[f.Name]
+			}
+			out = append(out, attachIDToDataMap(r.ID, obj))
+		}
+		return printJSON(c.out, out)
+	}
+
+	header := make([]string, len(cols))
+	for i, f := range cols {
+		header[i] = f.Name
+	}
+	var table [][]string
+	for _, r := range rows {
+		r.Data = mergeWithSchema(c.schema, r.Data)
+		line := make([]string, len(cols))
+		for i, f := range cols {
+			line[i] = cellText(f, r)
+		}
+		table = append(table, line)
+	}
+	return printTable(c.out, header, table)
+}
+
+func cliGet(c *cliContext, args []string) error {
+	fs := c.newFlags("get")
+	format := fs.String("format", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format, "table", "json"); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("get needs a row id")
+	}
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	data, err := c.row(id)
+	if err != nil {
+		return err
+	}
+	r := Row{ID: id, Data: mergeWithSchema(c.schema, data)}
+	if err := fillLookups(c.db, c.sheet.ID, c.schema, []Row{r}); err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		return printJSON(c.out, attachIDToDataMap(r.ID, r.Data))
+	}
+	var table [][]string
+	for _, f := range c.schema {
+		table = append(table, []string{f.Name, cellText(f, r)})
+	}
+	return printTable(c.out, []string{"FIELD", "VALUE"}, table)
+}
+
+func cliSet(c *cliContext, args []string) error {
+	fs := c.newFlags("set")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 3 {
+		return usageError("set needs ID FIELD VALUE")
+	}
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	f, v, err := c.parseFieldArg(fs.Arg(1), fs.Arg(2))
+	if err != nil {
+		return err
+	}
+	if _, err := c.row(id); err != nil {
+		return err
+	}
+	return undoableUpdateField(c.db, c.schema, id, f.Name, v)
+}
+
+func cliAddRow(c *cliContext, args []string) error {
+	fs := c.newFlags("add-row")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	data := getEmptyRowFromSchema(c.schema)
+	for _, a := range fs.Args() {
+		name, value, ok := strings.Cut(a, "=")
+		if !ok {
+			return usageError("%q is not FIELD=VALUE", a)
+		}
+		f, v, err := c.parseFieldArg(name, value)
+		if err != nil {
+			return err
+		}
+		data[f.Name] = v
+	}
+	computeFormulas(c.schema, data)
+	id, err := undoableInsertRow(c.db, c.sheet.ID, data)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, id)
+	return nil
+}
+
+func cliDeleteRow(c *cliContext, args []string) error {
+	fs := c.newFlags("delete-row")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError("delete-row needs at least one row id")
+	}
+	var ids []int
+	for _, a := range fs.Args() {
+		id, err := parseID(a)
+		if err != nil {
+			return err
+		}
+		if _, err := c.row(id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	// all rows go in one transaction and one undo step
+	return withUndo(c.db, "Delete rows", "", func(tx *sql.Tx, rec *undoRecorder) error {
+		return deleteRows(tx, rec, ids)
+	})
+}
+
+func cliViews(c *cliContext, args []string) error {
+	fs := c.newFlags("views")
+	format := fs.String("format", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format, "table", "json"); err != nil {
+		return err
+	}
+	views, err := getAllViews(c.db, c.sheet.ID)
+	if err != nil {
+		return err
+	}
+	if *format == "json" {
+		if views == nil {
+			views = []View{}
+		}
+		return printJSON(c.out, views)
+	}
+	var table [][]string
+	for _, v := range views {
+		var filters, sorts []string
+		for _, f := range v.Filters {
+			filters = append(filters, strings.TrimSpace(f.Field+" "+f.Op+" "+f.Value))
+		}
+		for _, s := range v.Sort {
+			if s.Desc {
+				sorts = append(sorts, s.Field+" desc")
+			} else {
+				sorts = append(sorts, s.Field)
+			}
+		}
+		table = append(table, []string{strconv.Itoa(v.ID), v.Name, strings.Join(v.Columns, ", "),
+			strings.Join(filters, "; "), strings.Join(sorts, ", ")})
+	}
+	return printTable(c.out, []string{"ID", "NAME", "COLUMNS", "FILTERS", "SORT"}, table)
+}
+
+func cliSheets(c *cliContext, args []string) error {
+	fs := c.newFlags("sheets")
+	format := fs.String("format", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format, "table", "json"); err != nil {
+		return err
+	}
+	sheets, err := getSheets(c.db)
+	if err != nil {
+		return err
+	}
+	var table [][]string
+	var out []map[string]interface{}
+	for _, sh := range sheets {
+		var rows int
+		if err := c.db.QueryRow("SELECT COUNT(*) FROM entries WHERE sheet_id = ?", sh.ID).Scan(&rows); err != nil {
+			return err
+		}
+		_, schema, err := storedSchema(c.db, sh.ID)
+		if err != nil {
+			return err
+		}
+		table = append(table, []string{strconv.Itoa(sh.ID), sh.Name, strconv.Itoa(rows), strconv.Itoa(len(schema))})
+		out = append(out, map[string]interface{}{"ID": sh.ID, "Name": sh.Name, "Rows": rows, "Fields": len(schema)})
+	}
+	if *format == "json" {
+		return printJSON(c.out, out)
+	}
+	return printTable(c.out, []string{"ID", "NAME", "ROWS", "FIELDS"}, table)
+}
+
+func cliImport(c *cliContext, args []string) error {
+	fs := c.newFlags("import")
+	mode := fs.String("mode", importAppend, "import mode: append, replace or upsert")
+	key := fs.String("key", "", "key field for upsert")
+	listSep := fs.String("list-sep", ";", "separator of list items in CSV/TSV cells")
+	dryRun := fs.Bool("dry-run", false, "only print what would change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("import needs a file")
+	}
+	path := fs.Arg(0)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if *mode == importUpsert {
+		if *key == "" {
+			return usageError("upsert needs --key")
+		}
+		f, err := c.field(*key)
+		if err != nil {
+			return err
+		}
+		*key = f.Name
+	}
+
+	ins := []*jsonImport{{}}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv", ".tsv":
+		header, records, err := readDelimited(strings.NewReader(string(raw)), delimiterForExt(ext))
+		if err != nil {
+			return err
+		}
+		mapping := guessCSVMapping(c.schema, header)
+		var rowErrs []csvRowError
+		ins[0].Entries, rowErrs = convertCSVRecords(c.schema, mapping, records, *listSep)
+		for _, e := range rowErrs {
+			fmt.Fprintln(c.errOut, "skipped:", e.String())
+		}
+	default:
+		if ins, err = parseJSONImport(raw); err != nil {
+			return err
+		}
+	}
+
+	plans, err := planWorkbookImport(c.db, c.sheet.ID, c.schema, ins, *mode, *key)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(c.out, importSummary(plans))
+	if *dryRun {
+		return nil
+	}
+	if err := applyImport(c.db, plans...); err != nil {
+		return fmt.Errorf("import rolled back: %w", err)
+	}
+	return nil
+}
+
+func cliExport(c *cliContext, args []string) error {
+	fs := c.newFlags("export")
+	format := fs.String("format", "", "json, csv or tsv (default: from the file extension, else json)")
+	viewName := fs.String("view", "", "export only the rows and columns of a saved view (csv/tsv)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return usageError("export takes at most one file")
+	}
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = "json"
+		if ext := strings.ToLower(filepath.Ext(path)); ext == ".csv" || ext == ".tsv" {
+			*format = ext[1:]
+		}
+	}
+	if err := checkFormat(*format, "json", "csv", "tsv"); err != nil {
+		return err
+	}
+	if *format == "json" && *viewName != "" {
+		return usageError("--view only applies to csv and tsv exports")
+	}
+
+	out := c.out
+	if path != "" && path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if *format == "json" {
+		data, err := exportJSON(c.db, c.sheet.ID, c.schema)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	}
+
+	v, err := c.view(*viewName)
+	if err != nil {
+		return err
+	}
+	rows, err := getViewRows(c.db, c.sheet.ID, c.schema, v)
+	if err != nil {
+		return err
+	}
+	var cols []string
+	for _, f := range c.columns(v) {
+		cols = append(cols, f.Name)
+	}
+	comma := ','
+	if *format == "tsv" {
+		comma = '\t'
+	}
+	return writeDelimited(out, c.schema, cols, rows, comma, "; ")
+}
+
+func cliMigrate(c *cliContext, args []string) error {
+	fs := c.newFlags("migrate")
+	apply := fs.Bool("apply", false, "apply the suggested changes")
+	listSep := fs.String("list-sep", ";", "separator for string <-> []string conversions")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	m, err := planSchemaMigration(c.db, c.sheet.ID, c.schema)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		fmt.Fprintln(c.out, "schema is up to date")
+		return nil
+	}
+	m.ListSep = *listSep
+	if !*apply {
+		rep, err := previewSchemaMigration(c.db, m)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(c.out, m.Summary(rep))
+		fmt.Fprintln(c.out, "run with --apply to migrate")
+		return nil
+	}
+	rep, err := applySchemaMigration(c.db, m)
+	if err != nil {
+		return fmt.Errorf("schema migration rolled back: %w", err)
+	}
+	fmt.Fprint(c.out, m.Summary(rep))
+	return nil
+}
+
+func cliServe(c *cliContext, args []string) error {
+	fs := c.newFlags("serve")
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	log.Printf("serving the API on http://%s/api/", *addr)
+	return http.ListenAndServe(*addr, logRequests(newAPIServer(c.db, c.sheet.ID, c.schema)))
+}
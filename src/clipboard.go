@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// The selected range of the grid can be copied to the clipboard as TSV (tab
+// separated values, what office spreadsheets exchange) and TSV can be pasted into
+// it: a single value fills the whole range, a block is pasted from the top left
+// cell. Fill down (Ctrl+D) copies the top row of the range into the rows below,
+// fill right (Ctrl+R) the left column into the columns to its right; with a single
+// row or column selected the cells above or to the left are the source. Every
+// paste or fill is one undo step.
+
+// list items and relation ids in copied cells are separated by clipboardListSep;
+// pasted ones by clipboardListSep without the space
+const clipboardListSep = "; "
+
+// formatTSV renders records as TSV; values with tabs, line breaks or quotes are quoted
+func formatTSV(records [][]string) string {
+	var b strings.Builder
+	for i, rec := range records {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		for j, v := range rec {
+			if j > 0 {
+				b.WriteString("\t")
+			}
+			if strings.ContainsAny(v, "\t\r\n\"") {
+				v = `"` + strings.ReplaceAll(v, `"`, `""`) + `"`
+			}
+			b.WriteString(v)
+		}
+	}
+	return b.String()
+}
+
+// parseTSV splits TSV text into records. Quoted values may hold tabs, line breaks
+// and doubled quotes; empty lines are records with one empty value.
+func parseTSV(s string) [][]string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.TrimSuffix(s, "\n")
+	var out [][]string
+	var rec []string
+	var cell strings.Builder
+	quoted, atStart := false, true
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		ch := rs[i]
+		switch {
+		case quoted && ch == '"' && i+1 < len(rs) && rs[i+1] == '"':
+			cell.WriteRune('"')
+			i++
+		case quoted && ch == '"':
+			quoted = false
+		case quoted:
+			cell.WriteRune(ch)
+		case ch == '"' && atStart:
+			quoted = true
+		case ch == '\t':
+			rec = append(rec, cell.String())
+			cell.Reset()
+			atStart = true
+			continue
+		case ch == '\n':
+			out = append(out, append(rec, cell.String()))
+			rec = nil
+			cell.Reset()
+			atStart = true
+			continue
+		default:
+			cell.WriteRune(ch)
+		}
+		atStart = false
+	}
+	return append(out, append(rec, cell.String()))
+}
+
+// isEditableField reports whether the values of f can be set by the user
+func isEditableField(f FieldDef) bool {
+	return !isComputedField(f) && !(f.Type == "int" && strings.EqualFold(f.Name, "ID"))
+}
+
+// selection returns the top left and bottom right cells of the selected range
+func (g *dataGrid) selection() (widget.TableCellID, widget.TableCellID) {
+	from := widget.TableCellID{Row: min(g.anchor.Row, g.cursor.Row), Col: min(g.anchor.Col, g.cursor.Col)}
+	to := widget.TableCellID{Row: max(g.anchor.Row, g.cursor.Row), Col: max(g.anchor.Col, g.cursor.Col)}
+	return from, to
+}
+
+// inRange reports whether id is in a selected range of more than one cell
+func (g *dataGrid) inRange(id widget.TableCellID) bool {
+	from, to := g.selection()
+	if from == to {
+		return false
+	}
+	return id.Row >= from.Row && id.Row <= to.Row && id.Col >= from.Col && id.Col <= to.Col
+}
+
+// markSelection shades the selected cells on screen
+func (g *dataGrid) markSelection() {
+	for id, c := range g.cellAt {
+		if c.id == id {
+			c.showSelected(g.inRange(id))
+		}
+	}
+}
+
+// showSelected shades or unshades the cell
+func (c *gridCell) showSelected(on bool) {
+	if on == c.selBox.Visible() {
+		return
+	}
+	if on {
+		c.selBox.Show()
+	} else {
+		c.selBox.Hide()
+	}
+}
+
+// cellText is the text of a loaded cell as it is copied
+func (g *dataGrid) cellText(row, col int) string {
+	r := g.rows[row]
+	f := g.effective[col]
+	if f.Type == "int" && strings.EqualFold(f.Name, "ID") {
+		return intText(r.ID)
+	}
+	return formatFieldValue(f, r.Data[f.Name], clipboardListSep)
+}
+
+// copySelection puts the selected range on the clipboard as TSV
+func (g *dataGrid) copySelection() {
+	if len(g.rows) == 0 || len(g.effective) == 0 {
+		return
+	}
+	from, to := g.selection()
+	var records [][]string
+	for row := from.Row; row <= to.Row; row++ {
+		var rec []string
+		for col := from.Col; col <= to.Col; col++ {
+			rec = append(rec, g.cellText(row, col))
+		}
+		records = append(records, rec)
+	}
+	fyne.CurrentApp().Clipboard().SetContent(formatTSV(records))
+}
+
+// pasteSelection pastes TSV from the clipboard into the selected range
+func (g *dataGrid) pasteSelection() {
+	text := fyne.CurrentApp().Clipboard().Content()
+	if text == "" || len(g.rows) == 0 || len(g.effective) == 0 {
+		return
+	}
+	records := parseTSV(text)
+	from, to := g.selection()
+	if len(records) == 1 && len(records[0]) == 1 {
+		// one value fills the range
+		fill := records[0][0]
+		records = nil
+		for row := from.Row; row <= to.Row; row++ {
+			rec := make([]string, to.Col-from.Col+1)
+			for i := range rec {
+				rec[i] = fill
+			}
+			records = append(records, rec)
+		}
+	}
+
+	var changes []cellChange
+	last := from
+	for i, rec := range records {
+		row := from.Row + i
+		if row >= len(g.rows) {
+			break
+		}
+		for j, s := range rec {
+			col := from.Col + j
+			if col >= len(g.effective) {
+				break
+			}
+			last = widget.TableCellID{Row: max(last.Row, row), Col: max(last.Col, col)}
+			f := g.effective[col]
+			if !isEditableField(f) {
+				continue
+			}
+			v, err := parseFieldValue(f, s, strings.TrimSpace(clipboardListSep))
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("nothing pasted: row %d, %s: %q %v", g.rows[row].ID, f.Label, s, err), g.win)
+				return
+			}
+			changes = append(changes, cellChange{ID: g.rows[row].ID, Field: f.Name, Value: v})
+		}
+	}
+	g.applyCells("Paste", changes)
+	g.anchor = from
+	g.extendTo(last)
+}
+
+// fillSelection copies the first row (down) or the first column (right) of the
+// selected range into the rest of it
+func (g *dataGrid) fillSelection(down bool) {
+	if len(g.rows) == 0 || len(g.effective) == 0 {
+		return
+	}
+	from, to := g.selection()
+	switch {
+	case down && from.Row == to.Row:
+		if from.Row == 0 {
+			return
+		}
+		from.Row-- // a single row is filled from the row above
+	case !down && from.Col == to.Col:
+		if from.Col == 0 {
+			return
+		}
+		from.Col-- // a single column is filled from the column to the left
+	}
+
+	var changes []cellChange
+	for row := from.Row; row <= to.Row; row++ {
+		for col := from.Col; col <= to.Col; col++ {
+			src := widget.TableCellID{Row: from.Row, Col: col}
+			if !down {
+				src = widget.TableCellID{Row: row, Col: from.Col}
+			}
+			f := g.effective[col]
+			if src.Row == row && src.Col == col || !isEditableField(f) {
+				continue
+			}
+			sf := g.effective[src.Col]
+			v := g.rows[src.Row].Data[sf.Name]
+			if sf.Name != f.Name {
+				// another column: convert through the text of the value
+				var err error
+				if v, err = parseFieldValue(f, g.cellText(src.Row, src.Col), strings.TrimSpace(clipboardListSep)); err != nil {
+					dialog.ShowError(fmt.Errorf("nothing filled: row %d, %s: %v", g.rows[row].ID, f.Label, err), g.win)
+					return
+				}
+			}
+			changes = append(changes, cellChange{ID: g.rows[row].ID, Field: f.Name, Value: v})
+		}
+	}
+	label := "Fill right"
+	if down {
+		label = "Fill down"
+	}
+	g.applyCells(label, changes)
+}
+
+// applyCells writes changes as one undo step and reloads the rows
+func (g *dataGrid) applyCells(label string, changes []cellChange) {
+	if len(changes) == 0 {
+		return
+	}
+	if err := undoableSetCells(g.db, g.schema, label, "", changes); err != nil {
+		dialog.ShowError(fmt.Errorf("%s rolled back: %w", strings.ToLower(label), err), g.win)
+		return
+	}
+	anchor, cursor := g.anchor, g.cursor
+	g.populateTableGrid(g.view)
+	g.anchor = anchor
+	g.extendTo(cursor)
+}
@@ -9,9 +9,53 @@ import (
 
 // FieldDef describes one column/field from the config file
 type FieldDef struct {
-	Name  string // example: "ID", "Name", "List1"
-	Type  string // example: "int", "string", "[]string"
-	Label string // display label (currently same as Name)
+	Name    string   // example: "ID", "Name", "List1"
+	Type    string   // example: "int", "float", "bool", "string", "[]string", "link", "date", "datetime", "enum", "formula", "relation", "lookup", "rollup"
+	Label   string   // display label (currently same as Name)
+	Options []string `json:",omitempty"` // allowed values of an "enum" field
+	Formula string   `json:",omitempty"` // expression of a "formula" field, see formula.go
+
+	// a "relation" field refers to rows of a sheet, see relations.go
+	Target   string `json:",omitempty"` // name of the referenced sheet, "" for the own sheet
+	Display  string `json:",omitempty"` // field of the referenced rows shown in cells ("" shows the id)
+	Multiple bool   `json:",omitempty"` // holds a list of ids instead of one
+	OnDelete string `json:",omitempty"` // "block" (default), "setnull" or "cascade"
+
+	// "lookup" and "rollup" fields read the rows of a relation field, see lookups.go
+	Relation    string `json:",omitempty"` // name of the relation field of the same sheet
+	LookupField string `json:",omitempty"` // field of the referenced rows
+	Aggregate   string `json:",omitempty"` // rollup: count, sum, min, max, avg or join
+
+	// validation rules, all optional; see validateFieldValue
+	Required  bool     `json:",omitempty"` // value may not be empty
+	Min       *float64 `json:",omitempty"` // lower bound of int/float values
+	Max       *float64 `json:",omitempty"` // upper bound of int/float values
+	Pattern   string   `json:",omitempty"` // regular expression text values (and list items) must match
+	MaxLength int      `json:",omitempty"` // maximum number of characters of text values (and list items)
+	Unique    bool     `json:",omitempty"` // no two rows may hold the same non-empty value
+	MinItems  *int     `json:",omitempty"` // minimum number of items of a []string or multiple relation field
+	MaxItems  *int     `json:",omitempty"` // maximum number of items of a []string or multiple relation field
+
+	pattern     *regexp.Regexp // compiled Pattern
+	expr        formulaNode    // parsed Formula
+	formulaRefs []string       // fields used by Formula
+}
+
+// compileSchema normalizes types and labels and compiles the rules and formulas of
+// fields read from JSON (config.json, the schema table or an export file)
+func compileSchema(fields []FieldDef) error {
+	for i := range fields {
+		if fields[i].Label == "" {
+			fields[i].Label = fields[i].Name
+		}
+		if !knownFieldTypes[fields[i].Type] {
+			fields[i].Type = "string"
+		}
+	}
+	if err := compileFieldRules(fields); err != nil {
+		return err
+	}
+	return compileFormulas(fields)
 }
 
 func loadConfig(path string) ([]FieldDef, error) {
@@ -25,17 +69,8 @@ func loadConfig(path string) ([]FieldDef, error) {
 		if err := json.Unmarshal(b, &fields); err != nil {
 			return nil, err
 		}
-		// normalize types and labels
-		for i := range fields {
-			if fields[i].Label == "" {
-				fields[i].Label = fields[i].Name
-			}
-			switch fields[i].Type {
-			case "int", "string", "[]string", "link":
-				// ok - accept link explicitly
-			default:
-				fields[i].Type = "string"
-			}
+		if err := compileSchema(fields); err != nil {
+			return nil, err
 		}
 		return fields, nil
 	}
@@ -80,7 +115,9 @@ func loadConfig(path string) ([]FieldDef, error) {
 		name := m[1]
 		typ := m[2]
 		switch typ {
-		case "int", "string", "[]string":
+		case "int", "string", "[]string", "bool":
+		case "float64", "float32":
+			typ = "float"
 		default:
 			if strings.HasPrefix(typ, "[]") {
 				// keep slice type as-is
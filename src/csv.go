@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// csvRowError describes a CSV record that could not be converted to the schema
+type csvRowError struct {
+	Line  int // 1-based line in the file, header is line 1
+	Field string
+	Value string
+	Err   string
+}
+
+func (e csvRowError) String() string {
+	if e.Field == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d, %s=%q: %s", e.Line, e.Field, e.Value, e.Err)
+}
+
+// delimiterForExt returns the field separator matching a file extension (tab for .tsv)
+func delimiterForExt(ext string) rune {
+	if strings.EqualFold(ext, ".tsv") {
+		return '\t'
+	}
+	return ','
+}
+
+// readDelimited parses CSV/TSV data and returns the header and the remaining records.
+// Records may have a different number of fields than the header.
+func readDelimited(r io.Reader, comma rune) ([]string, [][]string, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	all, err := cr.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(all) == 0 {
+		return nil, nil, fmt.Errorf("file is empty")
+	}
+	header := all[0]
+	// strip a UTF-8 BOM written by some spreadsheet tools
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	return header, all[1:], nil
+}
+
+// guessCSVMapping maps each header to a schema field whose Name or Label matches it
+// (case-insensitive); unmatched headers map to "".
+func guessCSVMapping(schema []FieldDef, header []string) []string {
+	out := make([]string, len(header))
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+		for _, f := range schema {
+			if strings.EqualFold(h, f.Name) || strings.EqualFold(h, f.Label) {
+				out[i] = f.Name
+				break
+			}
+		}
+	}
+	return out
+}
+
+// convertCSVRecords converts records into row data using mapping (column index -> field name,
+// "" skips the column). Records that fail to convert are left out and reported.
+func convertCSVRecords(schema []FieldDef, mapping []string, records [][]string, listSep string) ([]map[string]interface{}, []csvRowError) {
+	byName := map[string]FieldDef{}
+	for _, f := range schema {
+		byName[f.Name] = f
+	}
+
+	var out []map[string]interface{}
+	var errs []csvRowError
+	for ri, rec := range records {
+		line := ri + 2
+		data := map[string]interface{}{}
+		ok := true
+		for ci, name := range mapping {
+			if name == "" || ci >= len(rec) {
+				continue
+			}
+			f, known := byName[name]
+			if !known || isComputedField(f) {
+				continue
+			}
+			v, err := parseFieldValue(f, rec[ci], listSep)
+			if err != nil {
+				errs = append(errs, csvRowError{Line: line, Field: f.Name, Value: rec[ci], Err: err.Error()})
+				ok = false
+				break
+			}
+			data[f.Name] = v
+		}
+		if ok {
+			out = append(out, mergeWithSchema(schema, data))
+		}
+	}
+	return out, errs
+}
+
+// writeDelimited writes rows as CSV/TSV with one column per field in cols (schema names).
+// The int "ID" column is written from the row id.
+func writeDelimited(w io.Writer, schema []FieldDef, cols []string, rows []Row, comma rune, listSep string) error {
+	byName := map[string]FieldDef{}
+	for _, f := range schema {
+		byName[f.Name] = f
+	}
+	var fields []FieldDef
+	for _, c := range cols {
+		if f, ok := byName[c]; ok {
+			fields = append(fields, f)
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	header := make([]string, len(fields))
+	for i, f := range fields {
+		header[i] = f.Name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		data := mergeWithSchema(schema, r.Data)
+		rec := make([]string, len(fields))
+		for i, f := range fields {
+			if f.Type == "int" && strings.EqualFold(f.Name, "ID") {
+				rec[i] = strconv.Itoa(r.ID)
+				continue
+			}
+			rec[i] = formatFieldValue(f, data[f.Name], listSep)
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
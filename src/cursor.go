@@ -0,0 +1,391 @@
+package main
+
+import (
+	"image/color"
+	"reflect"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/widget"
+)
+
+// The grid has a cell cursor, drawn as an outline. While no editor has the focus,
+// gridKeys takes the keys: arrows, Tab and Enter move the cursor, Ctrl+Home/End jump
+// to the first/last cell, and F2 or typing starts editing the cell. Shift with an
+// arrow key or a click selects the range from the anchor cell to the cursor (see
+// clipboard.go for what can be done with it). In an editor, Enter and Tab keep the
+// edit and move on, Escape puts the old value back; Alt+Enter starts a new line in
+// text cells.
+
+// gridKeys is the invisible widget that has the focus while the user navigates the grid
+type gridKeys struct {
+	widget.BaseWidget
+	g *dataGrid
+}
+
+func newGridKeys(g *dataGrid) *gridKeys {
+	k := &gridKeys{g: g}
+	k.ExtendBaseWidget(k)
+	return k
+}
+
+func (k *gridKeys) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(canvas.NewRectangle(color.Transparent))
+}
+
+func (k *gridKeys) MinSize() fyne.Size { return fyne.NewSize(0, 0) }
+
+func (k *gridKeys) FocusGained() { k.g.editing = false }
+func (k *gridKeys) FocusLost()   {}
+
+// AcceptsTab keeps Tab for moving the cursor instead of the focus
+func (k *gridKeys) AcceptsTab() bool { return true }
+
+func (k *gridKeys) TypedRune(r rune) { k.g.startEdit(string(r)) }
+
+func (k *gridKeys) TypedKey(e *fyne.KeyEvent) {
+	g := k.g
+	back := 1
+	if shiftHeld() {
+		back = -1
+	}
+	move := g.moveCursor
+	if back < 0 {
+		move = g.extendSelection
+	}
+	switch e.Name {
+	case fyne.KeyUp:
+		move(-1, 0)
+	case fyne.KeyDown:
+		move(1, 0)
+	case fyne.KeyLeft:
+		move(0, -1)
+	case fyne.KeyRight:
+		move(0, 1)
+	case fyne.KeyTab:
+		g.moveCursor(0, back)
+	case fyne.KeyReturn, fyne.KeyEnter:
+		g.moveCursor(back, 0)
+	case fyne.KeyHome:
+		g.setCursor(widget.TableCellID{Row: g.cursor.Row, Col: 0})
+	case fyne.KeyEnd:
+		g.setCursor(widget.TableCellID{Row: g.cursor.Row, Col: len(g.effective) - 1})
+	case fyne.KeyF2:
+		g.startEdit("")
+	}
+}
+
+// shiftHeld reports whether a Shift key is down
+func shiftHeld() bool {
+	if d, ok := fyne.CurrentApp().Driver().(desktop.Driver); ok {
+		return d.CurrentKeyModifiers()&fyne.KeyModifierShift != 0
+	}
+	return false
+}
+
+// cellEntry is the entry of an editable cell. The keys that end an edit go to
+// onKey first, which returns false to leave them to the entry; onFocus and onBlur
+// run when the entry gets and loses the focus.
+type cellEntry struct {
+	widget.Entry
+	onFocus func()
+	onBlur  func()
+	onKey   func(e *cellEntry, k *fyne.KeyEvent) bool
+}
+
+func newCellEntry(multiLine bool) *cellEntry {
+	e := &cellEntry{}
+	e.MultiLine = multiLine
+	e.Wrapping = fyne.TextWrap(fyne.TextTruncateClip)
+	e.ExtendBaseWidget(e)
+	return e
+}
+
+// AcceptsTab keeps Tab for moving to the next cell
+func (e *cellEntry) AcceptsTab() bool { return true }
+
+func (e *cellEntry) FocusGained() {
+	e.Entry.FocusGained()
+	if e.onFocus != nil {
+		e.onFocus()
+	}
+}
+
+func (e *cellEntry) FocusLost() {
+	e.Entry.FocusLost()
+	if e.onBlur != nil {
+		e.onBlur()
+	}
+}
+
+func (e *cellEntry) TypedKey(k *fyne.KeyEvent) {
+	switch k.Name {
+	case fyne.KeyEscape, fyne.KeyReturn, fyne.KeyEnter, fyne.KeyTab:
+		if e.onKey != nil && e.onKey(e, k) {
+			return
+		}
+	}
+	e.Entry.TypedKey(k)
+}
+
+func (e *cellEntry) TypedShortcut(s fyne.Shortcut) {
+	if cs, ok := s.(*desktop.CustomShortcut); ok && e.MultiLine && cs.Modifier == fyne.KeyModifierAlt &&
+		(cs.KeyName == fyne.KeyReturn || cs.KeyName == fyne.KeyEnter) {
+		e.Entry.TypedKey(&fyne.KeyEvent{Name: fyne.KeyReturn}) // a new line
+		return
+	}
+	e.Entry.TypedShortcut(s)
+}
+
+// newEditor creates an entry for the cell that ends edits with the grid's keys
+func (c *gridCell) newEditor(multiLine bool) *cellEntry {
+	e := newCellEntry(multiLine)
+	e.onFocus = func() { c.g.beginEdit(c) }
+	e.onBlur = func() { c.g.flush() }
+	e.onKey = func(e *cellEntry, k *fyne.KeyEvent) bool { return c.g.editKey(c, e, k) }
+	return e
+}
+
+// Tapped puts the cursor on a cell that has no editor of its own under the pointer;
+// with Shift it selects the range up to the cell
+func (c *gridCell) Tapped(*fyne.PointEvent) {
+	if shiftHeld() {
+		c.g.extendTo(c.id)
+	} else {
+		c.g.setCursor(c.id)
+	}
+	c.g.focusKeys()
+}
+
+// showCursor draws or removes the cursor outline
+func (c *gridCell) showCursor(on bool) {
+	if on == c.cursorBox.Visible() {
+		return
+	}
+	if on {
+		c.cursorBox.Show()
+	} else {
+		c.cursorBox.Hide()
+	}
+}
+
+// focusKeys gives the keyboard back to the cursor
+func (g *dataGrid) focusKeys() {
+	g.win.Canvas().Focus(g.keys)
+}
+
+// markCursor updates the outline of the cell bound to id, if it is on screen
+func (g *dataGrid) markCursor(id widget.TableCellID) {
+	if c := g.cellAt[id]; c != nil && c.id == id {
+		c.showCursor(id == g.cursor)
+	}
+}
+
+// setCursor moves the cursor to id, kept within the data columns, and scrolls to
+// it; the selection shrinks to the cursor cell
+func (g *dataGrid) setCursor(id widget.TableCellID) {
+	if g.placeCursor(id) {
+		g.anchor = g.cursor
+		g.markSelection()
+	}
+}
+
+// extendTo moves the cursor to id keeping the anchor, so the selection spans both
+func (g *dataGrid) extendTo(id widget.TableCellID) {
+	if g.placeCursor(id) {
+		g.markSelection()
+	}
+}
+
+// placeCursor moves the cursor and its outline; false when the grid has no cells
+func (g *dataGrid) placeCursor(id widget.TableCellID) bool {
+	if len(g.rows) == 0 || len(g.effective) == 0 {
+		return false
+	}
+	id.Row = max(0, min(id.Row, len(g.rows)-1))
+	id.Col = max(0, min(id.Col, len(g.effective)-1))
+	if id != g.cursor {
+		g.editing = false
+	}
+	old := g.cursor
+	g.cursor = id
+	g.table.ScrollTo(id)
+	g.markCursor(old)
+	g.markCursor(id)
+	return true
+}
+
+// moveCursor moves the cursor by rows and columns
+func (g *dataGrid) moveCursor(rows, cols int) {
+	g.setCursor(widget.TableCellID{Row: g.cursor.Row + rows, Col: g.cursor.Col + cols})
+}
+
+// extendSelection moves the cursor by rows and columns keeping the anchor
+func (g *dataGrid) extendSelection(rows, cols int) {
+	g.extendTo(widget.TableCellID{Row: g.cursor.Row + rows, Col: g.cursor.Col + cols})
+}
+
+// startEdit starts editing the cell under the cursor. A non-empty text was typed
+// and replaces the value; bool cells toggle instead and pickers open.
+func (g *dataGrid) startEdit(text string) {
+	id := g.cursor
+	c := g.cellAt[id]
+	if c == nil || c.id != id || id.Row >= len(g.rows) || id.Col >= len(g.effective) {
+		return
+	}
+	f := g.effective[id.Col]
+	edit := func(e *cellEntry) {
+		g.win.Canvas().Focus(e)
+		if text != "" {
+			e.SetText(text)
+			e.CursorRow, e.CursorColumn = 0, len([]rune(text))
+			e.Refresh()
+		}
+	}
+	switch f.Type {
+	case "int":
+		if !strings.EqualFold(f.Name, "ID") {
+			edit(c.intEntry)
+		}
+	case "float":
+		edit(c.floatEntry)
+	case "bool":
+		if text == "" || text == " " {
+			c.check.SetChecked(!c.check.Checked)
+		}
+	case "date":
+		g.win.Canvas().Focus(c.dateEntry)
+	case "datetime":
+		g.win.Canvas().Focus(c.dtDate)
+	case "enum":
+		c.enumSelect.Tapped(&fyne.PointEvent{})
+	case "formula", "lookup", "rollup":
+	case "link":
+		c.linkOverlay.onLeftClick()
+		if text != "" {
+			edit(c.linkEntry)
+		}
+	case "relation":
+		c.relationOverlay.onLeftClick()
+	case "[]string":
+		entries := listEntries(c.list)
+		if len(entries) == 0 {
+			return
+		}
+		if text == "" {
+			g.win.Canvas().Focus(entries[0])
+		} else {
+			edit(entries[len(entries)-1]) // typing adds an item
+		}
+	default:
+		edit(c.textEntry)
+	}
+}
+
+// listEntries returns the item entries of a list editor
+func listEntries(list fyne.CanvasObject) []*cellEntry {
+	scroll, ok := list.(*container.Scroll)
+	if !ok {
+		return nil
+	}
+	box, ok := scroll.Content.(*fyne.Container)
+	if !ok {
+		return nil
+	}
+	var out []*cellEntry
+	for _, o := range box.Objects {
+		if e, ok := o.(*cellEntry); ok {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// beginEdit notes that an editor of c got the focus: the cursor moves there and
+// the value is kept for Escape
+func (g *dataGrid) beginEdit(c *gridCell) {
+	if g.editing && g.cursor == c.id {
+		return // another item entry of the same list cell
+	}
+	if c.id.Row >= len(g.rows) || c.id.Col >= len(g.effective) {
+		return
+	}
+	g.setCursor(c.id)
+	g.editing = true
+	g.editBefore = g.rows[c.id.Row].Data[g.effective[c.id.Col].Name]
+}
+
+// editKey handles the keys ending an edit in entry e of cell c
+func (g *dataGrid) editKey(c *gridCell, e *cellEntry, k *fyne.KeyEvent) bool {
+	if c.id.Col >= len(g.effective) {
+		return false
+	}
+	f := g.effective[c.id.Col]
+	step := 1
+	if shiftHeld() {
+		step = -1
+	}
+	switch k.Name {
+	case fyne.KeyEscape:
+		g.cancelEdit()
+		return true
+	case fyne.KeyReturn, fyne.KeyEnter:
+		if f.Type == "[]string" && step > 0 && strings.TrimSpace(e.Text) != "" {
+			return false // the list editor moves to the next item
+		}
+		if !g.editValid(f, e) {
+			return true
+		}
+		g.endEdit(step, 0)
+		return true
+	case fyne.KeyTab:
+		if !g.editValid(f, e) {
+			return true
+		}
+		g.endEdit(0, step)
+		return true
+	}
+	return false
+}
+
+// editValid reports whether the text of e can be stored in f; an edit can't end
+// with a number that doesn't parse (the cell shows why)
+func (g *dataGrid) editValid(f FieldDef, e *cellEntry) bool {
+	if f.Type != "int" && f.Type != "float" {
+		return true
+	}
+	_, err := parseFieldValue(f, e.Text, "")
+	return err == nil
+}
+
+// endEdit writes the edited value and moves the cursor
+func (g *dataGrid) endEdit(rows, cols int) {
+	id := g.cursor
+	g.flush()
+	g.editing = false
+	g.focusKeys()
+	g.table.RefreshItem(id)
+	g.moveCursor(rows, cols)
+}
+
+// cancelEdit stores the value the cell had when editing started
+func (g *dataGrid) cancelEdit() {
+	id := g.cursor
+	if g.editing && id.Row < len(g.rows) && id.Col < len(g.effective) {
+		r := g.rows[id.Row]
+		f := g.effective[id.Col]
+		if !reflect.DeepEqual(r.Data[f.Name], g.editBefore) {
+			g.setField(id.Row, r.ID, f.Name, g.editBefore)
+		} else {
+			// clears the error of text that was never stored
+			g.revalidate(id.Row, f.Name)
+		}
+	}
+	g.flush()
+	g.editing = false
+	g.focusKeys()
+	g.table.RefreshItem(id)
+}
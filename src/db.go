@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -20,30 +22,81 @@ type View struct {
 	ID      int
 	Name    string
 	Columns []string
+	Filters []ViewFilter
+	Sort    []SortKey
+
+	// Form lays out the row form of the view (see form.go); empty shows every
+	// field in one group
+	Form []FormGroup
+
+	// Search narrows the rows to those containing the text (see search.go); it is
+	// typed in the toolbar and never stored with the view
+	Search string `json:"-"`
+
+	// IDs, when set, limits the rows to those ids (the rows checked in the grid);
+	// never stored either
+	IDs []int `json:"-"`
 }
 
-// initializeDB creates/opens the sqlite database and ensures required tables exist.
-// It will also attempt to migrate older schemas into the JSON `data` column.
-func initializeDB() *sql.DB {
-	// open DB (fatal on error so callers don't have to handle nil)
-	db, err := sql.Open("sqlite3", "./data.db")
+// ViewFilter is a single filter rule of a view, evaluated in SQL over the JSON data column
+type ViewFilter struct {
+	Field string
+	Op    string // one of the filter* constants
+	Value string
+}
+
+// SortKey orders rows by one field; a view applies its keys in order
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// FormGroup is a titled group of fields on the row form of a view
+type FormGroup struct {
+	Title  string
+	Fields []string // field names in the order shown
+}
+
+// filter operators stored in ViewFilter.Op
+const (
+	filterEquals       = "eq"
+	filterContains     = "contains"
+	filterGreater      = "gt"
+	filterLess         = "lt"
+	filterListContains = "list_contains"
+	filterEmpty        = "empty"
+	filterNotEmpty     = "not_empty"
+)
+
+// sqlExecer is implemented by both *sql.DB and *sql.Tx, so the row and view
+// helpers below can run standalone or as part of a transaction.
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// initializeDB opens the database at path like openDB and exits the program on failure
+// (fatal on error so callers don't have to handle nil).
+func initializeDB(path string) *sql.DB {
+	db, err := openDB(path)
 	if err != nil {
-		// try rwc as a fallback
-		db, err = sql.Open("sqlite3", "file:./data.db?mode=rwc")
-		if err != nil {
-			log.Fatalf("unable to open or create database: %v", err)
-		}
+		log.Fatal(err)
 	}
+	return db
+}
 
+// openDB creates/opens the sqlite database at path and ensures required tables exist.
+// It will also attempt to migrate older schemas into the JSON `data` column.
+func openDB(path string) (*sql.DB, error) {
+	// wait for locks instead of failing: the GUI, the CLI and the API server may share the file
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("unable to open or create database %s: %w", path, err)
+	}
 	if err := db.Ping(); err != nil {
-		db2, err2 := sql.Open("sqlite3", "file:./data.db?mode=rwc")
-		if err2 != nil {
-			log.Fatalf("failed to connect to DB: %v / %v", err, err2)
-		}
-		if err := db2.Ping(); err != nil {
-			log.Fatalf("failed to ping newly opened DB: %v", err)
-		}
-		db = db2
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to DB %s: %w", path, err)
 	}
 
 	// Ensure entries table exists
@@ -54,7 +107,8 @@ func initializeDB() *sql.DB {
 	);
 	`
 	if _, err := db.Exec(createEntries); err != nil {
-		log.Fatalf("failed ensuring entries table exists: %v", err)
+		db.Close()
+		return nil, fmt.Errorf("failed ensuring entries table exists: %w", err)
 	}
 
 	// Ensure views table exists (stores name + JSON array of column names)
@@ -66,7 +120,42 @@ func initializeDB() *sql.DB {
 	);
 	`
 	if _, err := db.Exec(createViews); err != nil {
-		log.Fatalf("failed ensuring views table exists: %v", err)
+		db.Close()
+		return nil, fmt.Errorf("failed ensuring views table exists: %w", err)
+	}
+
+	// Ensure undo log exists (one row per undoable step, see undo.go)
+	createUndo := `
+	CREATE TABLE IF NOT EXISTS undo_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		label TEXT,
+		coalesce_key TEXT,
+		ops TEXT,
+		undone INTEGER NOT NULL DEFAULT 0,
+		updated_at INTEGER
+	);
+	`
+	if _, err := db.Exec(createUndo); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed ensuring undo_log table exists: %w", err)
+	}
+
+	// Ensure revisions table exists (one row per changed field, see revisions.go)
+	createRevisions := `
+	CREATE TABLE IF NOT EXISTS revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		row_id INTEGER NOT NULL,
+		changed_at TEXT NOT NULL,
+		field TEXT NOT NULL,
+		old_value TEXT NOT NULL,
+		new_value TEXT NOT NULL,
+		user TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS revisions_row ON revisions (row_id, field, id);
+	`
+	if _, err := db.Exec(createRevisions); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed ensuring revisions table exists: %w", err)
 	}
 
 	// Migration block: if entries exists but doesn't have data column migrate older layout
@@ -120,7 +209,8 @@ func initializeDB() *sql.DB {
 
 			_, err = db.Exec(`CREATE TABLE IF NOT EXISTS entries_new (id INTEGER PRIMARY KEY AUTOINCREMENT, data TEXT)`)
 			if err != nil {
-				log.Fatalf("migration: failed to create entries_new: %v", err)
+				db.Close()
+				return nil, fmt.Errorf("migration: failed to create entries_new: %w", err)
 			}
 
 			colsList, _ := orow.Columns()
@@ -193,10 +283,12 @@ func initializeDB() *sql.DB {
 			}
 
 			if _, err := db.Exec("DROP TABLE entries"); err != nil {
-				log.Fatalf("migration: failed to drop old entries table: %v", err)
+				db.Close()
+				return nil, fmt.Errorf("migration: failed to drop old entries table: %w", err)
 			}
 			if _, err := db.Exec("ALTER TABLE entries_new RENAME TO entries"); err != nil {
-				log.Fatalf("migration: failed to rename entries_new: %v", err)
+				db.Close()
+				return nil, fmt.Errorf("migration: failed to rename entries_new: %w", err)
 			}
 
 			var maxID sql.NullInt64
@@ -206,25 +298,89 @@ func initializeDB() *sql.DB {
 		}
 	}
 
-	return db
+	// Sheets, and the per-sheet schema tables (see sheets.go)
+	if err := ensureSheetTables(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed ensuring sheet tables exist: %w", err)
+	}
+
+	// Full-text index of the entries, when the driver has FTS5 (see fts.go)
+	if err := ensureFTS(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed ensuring full-text index: %w", err)
+	}
+
+	return db, nil
 }
 
-// insertRow inserts a row json blob and returns inserted id
-func insertRow(db *sql.DB, data map[string]interface{}) (int64, error) {
+// insertRow inserts a row json blob into a sheet and returns inserted id
+func insertRow(db sqlExecer, sheet int, data map[string]interface{}) (int64, error) {
 	js, err := json.Marshal(data)
 	if err != nil {
 		return 0, err
 	}
-	res, err := db.Exec("INSERT INTO entries (data) VALUES (?)", string(js))
+	res, err := db.Exec("INSERT INTO entries (data, sheet_id) VALUES (?, ?)", string(js), sheet)
 	if err != nil {
 		return 0, err
 	}
-	return res.LastInsertId()
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return id, recordRowChange(db, int(id), nil, data, false)
 }
 
-// getAllRows returns all rows with JSON data parsed into map[string]interface{}
-func getAllRows(db *sql.DB) ([]Row, error) {
-	rows, err := db.Query("SELECT id, data FROM entries ORDER BY id")
+// getAllRows returns all rows of a sheet with JSON data parsed into map[string]interface{}
+func getAllRows(db sqlExecer, sheet int) ([]Row, error) {
+	return queryRows(db, "SELECT id, data FROM entries WHERE sheet_id = ? ORDER BY id", sheet)
+}
+
+// getViewRows returns the rows of a sheet matching the view's filters, ordered by its
+// sort keys. Filtering and sorting run in SQLite over the JSON data column.
+func getViewRows(db sqlExecer, sheet int, schema []FieldDef, v View) ([]Row, error) {
+	rows, _, err := getViewRowsPage(db, sheet, schema, v, 0, 0)
+	return rows, err
+}
+
+// getViewRowsPage is getViewRows limited to limit rows starting at offset (limit <= 0: all).
+// It also returns the number of rows matching the view.
+func getViewRowsPage(db sqlExecer, sheet int, schema []FieldDef, v View, limit, offset int) ([]Row, int, error) {
+	where, order, args, err := buildViewQuery(schema, v)
+	if err != nil {
+		return nil, 0, err
+	}
+	q := "SELECT id, data FROM entries WHERE sheet_id = ?"
+	if where != "" {
+		q += " AND " + where
+	}
+	q += " ORDER BY " + order
+	args = append([]interface{}{sheet}, args...)
+
+	var total int
+	paged := limit > 0 || offset > 0
+	if paged {
+		if err := db.QueryRow("SELECT COUNT(*) FROM ("+q+")", args...).Scan(&total); err != nil {
+			return nil, 0, err
+		}
+		if limit <= 0 {
+			limit = -1 // SQLite: no limit
+		}
+		q += " LIMIT ? OFFSET ?"
+		args = append(args, limit, offset)
+	}
+	rows, err := queryRows(db, q, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !paged {
+		total = len(rows)
+	}
+	return rows, total, fillLookups(db, sheet, schema, rows)
+}
+
+// queryRows runs a query returning (id, data) pairs and parses the JSON blobs
+func queryRows(db sqlExecer, query string, args ...interface{}) ([]Row, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -251,55 +407,200 @@ func getAllRows(db *sql.DB) ([]Row, error) {
 }
 
 // updateField loads JSON blob, updates the given field and writes it back
-func updateField(db *sql.DB, id int, field string, value interface{}) error {
+func updateField(db sqlExecer, id int, field string, value interface{}) error {
+	return updateFields(db, id, map[string]interface{}{field: value})
+}
+
+// updateFields is updateField for several fields of one row at once
+func updateFields(db sqlExecer, id int, values map[string]interface{}) error {
 	// load existing
 	var dataStr string
 	if err := db.QueryRow("SELECT data FROM entries WHERE id = ?", id).Scan(&dataStr); err != nil {
 		return err
 	}
 	var m map[string]interface{}
-	if err := json.Unmarshal([]byte(dataStr), &m); err != nil {
+	if err := json.Unmarshal([]byte(dataStr), &m); err != nil || m == nil {
 		m = map[string]interface{}{}
 	}
-	m[field] = value
+	before := map[string]interface{}{}
+	for field, value := range values {
+		if old, ok := m[field]; ok {
+			before[field] = old
+		}
+		m[field] = value
+	}
 	js, err := json.Marshal(m)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec("UPDATE entries SET data = ? WHERE id = ?", string(js), id)
-	return err
+	if _, err = db.Exec("UPDATE entries SET data = ? WHERE id = ?", string(js), id); err != nil {
+		return err
+	}
+	return recordRowChange(db, id, before, values, true)
+}
+
+// cellChange is a new value for one field of a row
+type cellChange struct {
+	ID    int
+	Field string
+	Value interface{}
+}
+
+// updateCells writes changes with one read and one write per changed row, the
+// formula fields of which are recomputed. Run it in a transaction to batch many
+// cells into one commit (see undoableSetCells).
+func updateCells(db sqlExecer, schema []FieldDef, changes []cellChange) error {
+	byRow := map[int]map[string]interface{}{}
+	var order []int
+	for _, c := range changes {
+		if byRow[c.ID] == nil {
+			byRow[c.ID] = map[string]interface{}{}
+			order = append(order, c.ID)
+		}
+		byRow[c.ID][c.Field] = c.Value
+	}
+	for _, id := range order {
+		m, err := loadRowData(db, id)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			return fmt.Errorf("row %d no longer exists", id)
+		}
+		before := map[string]interface{}{}
+		for k, v := range m {
+			before[k] = v
+		}
+		for k, v := range byRow[id] {
+			m[k] = v
+		}
+		// formula fields depending on the changed fields are written with them
+		values := computeFormulas(schema, m)
+		for k, v := range byRow[id] {
+			values[k] = v
+		}
+		old := map[string]interface{}{}
+		for k := range values {
+			if v, ok := before[k]; ok {
+				old[k] = v
+			}
+		}
+		js, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		if _, err = db.Exec("UPDATE entries SET data = ? WHERE id = ?", string(js), id); err != nil {
+			return err
+		}
+		if err := recordRowChange(db, id, old, values, true); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // deleteRow deletes a row by id
-func deleteRow(db *sql.DB, id int) error {
-	_, err := db.Exec("DELETE FROM entries WHERE id = ?", id)
-	return err
+func deleteRow(db sqlExecer, id int) error {
+	var dataStr string
+	if err := db.QueryRow("SELECT data FROM entries WHERE id = ?", id).Scan(&dataStr); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM entries WHERE id = ?", id); err != nil {
+		return err
+	}
+	return recordRowDeleted(db, id, dataStr)
 }
 
 // replaceRow replaces the entire data map for a row
-func replaceRow(db *sql.DB, id int, data map[string]interface{}) error {
+func replaceRow(db sqlExecer, id int, data map[string]interface{}) error {
+	before, err := loadRowData(db, id)
+	if err != nil {
+		return err
+	}
 	js, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec("UPDATE entries SET data = ? WHERE id = ?", string(js), id)
-	return err
+	if _, err = db.Exec("UPDATE entries SET data = ? WHERE id = ?", string(js), id); err != nil {
+		return err
+	}
+	return recordRowChange(db, id, before, data, false)
+}
+
+// loadRowData returns the parsed data of a row, or nil if the row does not exist
+func loadRowData(db sqlExecer, id int) (map[string]interface{}, error) {
+	var dataStr string
+	err := db.QueryRow("SELECT data FROM entries WHERE id = ?", id).Scan(&dataStr)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(dataStr), &m); err != nil || m == nil {
+		m = map[string]interface{}{}
+	}
+	return m, nil
 }
 
 // getEmptyRowFromSchema returns a map with default values based on field types
 func getEmptyRowFromSchema(schema []FieldDef) map[string]interface{} {
 	m := map[string]interface{}{}
 	for _, f := range schema {
-		switch f.Type {
-		case "int":
-			m[f.Name] = 0
-		case "string":
-			m[f.Name] = ""
-		case "[]string":
-			m[f.Name] = []string{}
-		default:
-			m[f.Name] = ""
+		if f.Type == "lookup" || f.Type == "rollup" {
+			continue // never stored
 		}
+		m[f.Name] = defaultFieldValue(f)
+	}
+	computeFormulas(schema, m)
+	return m
+}
+
+// intText formats an int field value loaded from JSON for an entry.
+func intText(v interface{}) string {
+	switch t := v.(type) {
+	case float64:
+		return strconv.Itoa(int(t))
+	case int:
+		return strconv.Itoa(t)
+	case string:
+		return t
+	}
+	return ""
+}
+
+// toStringList converts a []string field value loaded from JSON.
+func toStringList(v interface{}) []string {
+	var list []string
+	switch t := v.(type) {
+	case nil:
+	case []interface{}:
+		for _, it := range t {
+			list = append(list, fmt.Sprintf("%v", it))
+		}
+	case []string:
+		list = append(list, t...)
+	default:
+		if tstr := fmt.Sprintf("%v", v); tstr != "" {
+			list = append(list, tstr)
+		}
+	}
+	return list
+}
+
+// mergeWithSchema returns a copy of data overlaid on the defaults from schema.
+// This ensures missing keys (e.g. when importing old JSON) are present for UI/export.
+func mergeWithSchema(schema []FieldDef, data map[string]interface{}) map[string]interface{} {
+	m := getEmptyRowFromSchema(schema)
+	if data == nil {
+		return m
+	}
+	for k, v := range data {
+		m[k] = v
 	}
 	return m
 }
@@ -322,9 +623,42 @@ func dumpRow(r Row) string {
 
 // --- Views management --- //
 
-// getAllViews returns all stored views (does not include implicit "All" view)
-func getAllViews(db *sql.DB) ([]View, error) {
-	rows, err := db.Query("SELECT id, name, data FROM views ORDER BY id")
+// storedView is the JSON layout of views.data.
+// Older databases store only the JSON array of column names.
+type storedView struct {
+	Columns []string
+	Filters []ViewFilter `json:",omitempty"`
+	Sort    []SortKey    `json:",omitempty"`
+	Form    []FormGroup  `json:",omitempty"`
+}
+
+// decodeViewData parses views.data in either the legacy or the current layout
+func decodeViewData(dataStr string) storedView {
+	var sv storedView
+	if strings.HasPrefix(strings.TrimSpace(dataStr), "[") {
+		if err := json.Unmarshal([]byte(dataStr), &sv.Columns); err != nil {
+			sv.Columns = []string{}
+		}
+		return sv
+	}
+	if err := json.Unmarshal([]byte(dataStr), &sv); err != nil {
+		return storedView{Columns: []string{}}
+	}
+	return sv
+}
+
+// encodeViewData serializes the stored part of a view for views.data
+func encodeViewData(v View) (string, error) {
+	js, err := json.Marshal(storedView{Columns: v.Columns, Filters: v.Filters, Sort: v.Sort, Form: v.Form})
+	if err != nil {
+		return "", err
+	}
+	return string(js), nil
+}
+
+// getAllViews returns the stored views of a sheet (does not include implicit "All" view)
+func getAllViews(db sqlExecer, sheet int) ([]View, error) {
+	rows, err := db.Query("SELECT id, name, data FROM views WHERE sheet_id = ? ORDER BY id", sheet)
 	if err != nil {
 		return nil, err
 	}
@@ -338,11 +672,8 @@ func getAllViews(db *sql.DB) ([]View, error) {
 		if err := rows.Scan(&id, &name, &dataStr); err != nil {
 			return nil, err
 		}
-		var cols []string
-		if err := json.Unmarshal([]byte(dataStr), &cols); err != nil {
-			cols = []string{}
-		}
-		out = append(out, View{ID: id, Name: name, Columns: cols})
+		sv := decodeViewData(dataStr)
+		out = append(out, View{ID: id, Name: name, Columns: sv.Columns, Filters: sv.Filters, Sort: sv.Sort, Form: sv.Form})
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -350,13 +681,13 @@ func getAllViews(db *sql.DB) ([]View, error) {
 	return out, nil
 }
 
-// insertView creates a new view entry and returns its id
-func insertView(db *sql.DB, name string, cols []string) (int64, error) {
-	js, err := json.Marshal(cols)
+// insertView creates a new view entry in a sheet and returns its id
+func insertView(db sqlExecer, sheet int, v View) (int64, error) {
+	js, err := encodeViewData(v)
 	if err != nil {
 		return 0, err
 	}
-	res, err := db.Exec("INSERT INTO views (name, data) VALUES (?, ?)", name, string(js))
+	res, err := db.Exec("INSERT INTO views (name, data, sheet_id) VALUES (?, ?, ?)", v.Name, js, sheet)
 	if err != nil {
 		return 0, err
 	}
@@ -364,23 +695,191 @@ func insertView(db *sql.DB, name string, cols []string) (int64, error) {
 }
 
 // updateView updates an existing view
-func updateView(db *sql.DB, id int, name string, cols []string) error {
-	js, err := json.Marshal(cols)
+func updateView(db sqlExecer, v View) error {
+	js, err := encodeViewData(v)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec("UPDATE views SET name = ?, data = ? WHERE id = ?", name, string(js), id)
+	_, err = db.Exec("UPDATE views SET name = ?, data = ? WHERE id = ?", v.Name, js, v.ID)
 	return err
 }
 
 // deleteView deletes a single view by id
-func deleteView(db *sql.DB, id int) error {
+func deleteView(db sqlExecer, id int) error {
 	_, err := db.Exec("DELETE FROM views WHERE id = ?", id)
 	return err
 }
 
-// deleteAllViews removes all stored views (used when importing)
-func deleteAllViews(db *sql.DB) error {
-	_, err := db.Exec("DELETE FROM views")
+// deleteAllViews removes all stored views of a sheet (used when importing)
+func deleteAllViews(db sqlExecer, sheet int) error {
+	_, err := db.Exec("DELETE FROM views WHERE sheet_id = ?", sheet)
 	return err
 }
+
+// --- View filters and sorting --- //
+
+// jsonPath returns the SQLite JSON path of a top-level key in entries.data
+func jsonPath(field string) string {
+	return `$."` + field + `"`
+}
+
+// fieldExpr returns the SQL expression selecting a field and its bound args.
+// The int "ID" column is the row id itself rather than a JSON key.
+func fieldExpr(f FieldDef) (string, []interface{}) {
+	if f.Type == "int" && strings.EqualFold(f.Name, "ID") {
+		return "id", nil
+	}
+	return "json_extract(data, ?)", []interface{}{jsonPath(f.Name)}
+}
+
+// comparisonOperand returns the SQL operand comparing field expression expr and the
+// bound value for an equals/greater/less filter, converted to the field's type.
+func comparisonOperand(f FieldDef, expr, value string) (string, interface{}, error) {
+	switch f.Type {
+	case "int":
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return "", nil, fmt.Errorf("%q is not a number", value)
+		}
+		return "CAST(" + expr + " AS INTEGER)", n, nil
+	case "float":
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("%q is not a number", value)
+		}
+		return "CAST(" + expr + " AS REAL)", n, nil
+	case "bool":
+		// json_extract returns 1/0 for JSON true/false
+		b, err := parseBool(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("%q is not true or false", value)
+		}
+		if b {
+			return expr, 1, nil
+		}
+		return expr, 0, nil
+	case "date", "datetime":
+		v, err := parseFieldValue(f, value, "")
+		if err != nil {
+			return "", nil, fmt.Errorf("%q is not a date", value)
+		}
+		return expr, v, nil
+	case "formula":
+		// formula values may be numbers or text: compare numerically when the value is a number
+		if n, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+			return expr, n, nil
+		}
+	}
+	return expr, value, nil
+}
+
+// buildViewQuery translates a view's filters and sort keys into a WHERE clause
+// (empty when there are no filters), an ORDER BY list and the bound args for both.
+func buildViewQuery(schema []FieldDef, v View) (string, string, []interface{}, error) {
+	byName := map[string]FieldDef{}
+	for _, f := range schema {
+		byName[f.Name] = f
+	}
+
+	var conds []string
+	var args []interface{}
+	for _, flt := range v.Filters {
+		f, ok := byName[flt.Field]
+		if !ok {
+			return "", "", nil, fmt.Errorf("filter on unknown field %q", flt.Field)
+		}
+		if f.Type == "lookup" || f.Type == "rollup" {
+			return "", "", nil, fmt.Errorf("cannot filter on %s: %s values are not stored", f.Name, f.Type)
+		}
+		expr, exprArgs := fieldExpr(f)
+		switch flt.Op {
+		case filterEquals, filterGreater, filterLess:
+			operand, arg, err := comparisonOperand(f, expr, flt.Value)
+			if err != nil {
+				return "", "", nil, fmt.Errorf("filter on %s: %w", f.Name, err)
+			}
+			cmp := "="
+			if flt.Op == filterGreater {
+				cmp = ">"
+			} else if flt.Op == filterLess {
+				cmp = "<"
+			}
+			conds = append(conds, operand+" "+cmp+" ?")
+			args = append(append(args, exprArgs...), arg)
+		case filterContains:
+			conds = append(conds, "instr(lower(CAST("+expr+" AS TEXT)), lower(?)) > 0")
+			args = append(append(args, exprArgs...), flt.Value)
+		case filterListContains:
+			// relation fields hold row ids, a single id is matched like a list of one
+			var arg interface{} = flt.Value
+			if f.Type == "relation" {
+				id, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(flt.Value), "#"))
+				if err != nil {
+					return "", "", nil, fmt.Errorf("filter on %s: %q is not a row id", f.Name, flt.Value)
+				}
+				arg = id
+			}
+			conds = append(conds, "EXISTS (SELECT 1 FROM json_each(entries.data, ?) WHERE json_each.value = ?)")
+			args = append(args, jsonPath(f.Name), arg)
+		case filterEmpty:
+			conds = append(conds, "COALESCE(CAST("+expr+" AS TEXT), '') IN ('', '[]')")
+			args = append(args, exprArgs...)
+		case filterNotEmpty:
+			conds = append(conds, "COALESCE(CAST("+expr+" AS TEXT), '') NOT IN ('', '[]')")
+			args = append(args, exprArgs...)
+		default:
+			return "", "", nil, fmt.Errorf("unknown filter operator %q", flt.Op)
+		}
+	}
+
+	if v.IDs != nil {
+		// one JSON array instead of a variable per id, so any number of ids fits
+		ids, err := json.Marshal(v.IDs)
+		if err != nil {
+			return "", "", nil, err
+		}
+		conds = append(conds, "entries.id IN (SELECT value FROM json_each(?))")
+		args = append(args, string(ids))
+	}
+
+	var order []string
+	if terms := parseSearch(schema, v.Search); len(terms) > 0 {
+		cond, condArgs, rank, rankArgs := searchCondition(schema, v, terms)
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+		// without a sort of its own the view lists the best matches first
+		if rank != "" && len(v.Sort) == 0 {
+			order = append(order, rank)
+			args = append(args, rankArgs...)
+		}
+	}
+
+	for _, k := range v.Sort {
+		f, ok := byName[k.Field]
+		if !ok {
+			return "", "", nil, fmt.Errorf("sort on unknown field %q", k.Field)
+		}
+		if f.Type == "lookup" || f.Type == "rollup" {
+			return "", "", nil, fmt.Errorf("cannot sort on %s: %s values are not stored", f.Name, f.Type)
+		}
+		expr, exprArgs := fieldExpr(f)
+		switch f.Type {
+		case "int":
+			expr = "CAST(" + expr + " AS INTEGER)"
+		case "float":
+			expr = "CAST(" + expr + " AS REAL)"
+		case "bool", "date", "datetime":
+		default:
+			expr += " COLLATE NOCASE"
+		}
+		if k.Desc {
+			expr += " DESC"
+		}
+		order = append(order, expr)
+		args = append(args, exprArgs...)
+	}
+	// keep insertion order as the final tie breaker
+	order = append(order, "id")
+
+	return strings.Join(conds, " AND "), strings.Join(order, ", "), args, nil
+}
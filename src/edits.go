@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"sort"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Cell editors don't write every keystroke. setField updates the loaded row at
+// once and queues the value; the queued values are written together in one
+// transaction (one undo step) when the user stopped typing for editFlushDelay, an
+// editor loses the focus or an edit ends with Enter or Tab. Whatever reads or
+// writes rows behind the grid's back flushes first. When a write fails the values
+// stay queued, their cells are outlined as unsaved and a bar below the grid shows
+// the error with Retry and Discard.
+
+// editFlushDelay is how long typing has to pause before queued edits are written
+const editFlushDelay = 400 * time.Millisecond
+
+// unsavedColor outlines cells whose value could not be written
+var unsavedColor = color.NRGBA{R: 230, G: 130, B: 0, A: 255}
+
+// setField keeps the loaded row in sync with an edit, so a recycled cell shows the
+// new value when it scrolls back into view, and queues the value for writing
+func (g *dataGrid) setField(rowIdx, id int, field string, value interface{}) {
+	if g.pending[id] == nil {
+		g.pending[id] = map[string]interface{}{}
+	}
+	g.pending[id][field] = value
+	if g.flushTimer != nil {
+		g.flushTimer.Stop()
+	}
+	g.flushTimer = time.AfterFunc(editFlushDelay, func() { fyne.Do(func() { g.flush() }) })
+
+	if rowIdx < len(g.rows) && g.rows[rowIdx].ID == id {
+		data := g.rows[rowIdx].Data
+		data[field] = value
+		g.revalidate(rowIdx, field)
+		g.updateFormulas(rowIdx)
+	}
+}
+
+// flush writes the queued edits; false when they could not be written
+func (g *dataGrid) flush() bool {
+	if g.flushTimer != nil {
+		g.flushTimer.Stop()
+		g.flushTimer = nil
+	}
+	changes := g.pendingChanges()
+	if len(changes) == 0 {
+		return true
+	}
+	// edits of a single cell merge with the previous step of that cell, as typing did
+	label, coalesce := fmt.Sprintf("Edit %d cells", len(changes)), ""
+	if len(changes) == 1 {
+		label, coalesce = "Edit "+changes[0].Field, cellCoalesceKey(changes[0].ID, changes[0].Field)
+	}
+	if err := undoableSetCells(g.db, g.schema, label, coalesce, changes); err != nil {
+		log.Printf("warning: failed to save %d cells: %v", len(changes), err)
+		g.showSaveError(err)
+		return false
+	}
+	g.pending = map[int]map[string]interface{}{}
+	g.showSaveError(nil)
+	g.updateLookups()
+	// revalidate checked Unique fields against the values stored before
+	for _, f := range g.schema {
+		if f.Unique && hasChangeOf(changes, f.Name) {
+			g.validateRows()
+			g.table.Refresh()
+			break
+		}
+	}
+	return true
+}
+
+// pendingChanges returns the queued edits ordered by row id and field
+func (g *dataGrid) pendingChanges() []cellChange {
+	var out []cellChange
+	for id, fields := range g.pending {
+		for field, v := range fields {
+			out = append(out, cellChange{ID: id, Field: field, Value: v})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ID != out[j].ID {
+			return out[i].ID < out[j].ID
+		}
+		return out[i].Field < out[j].Field
+	})
+	return out
+}
+
+// hasChangeOf reports whether changes set field in some row
+func hasChangeOf(changes []cellChange, field string) bool {
+	for _, c := range changes {
+		if c.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPending puts the queued values into freshly loaded rows, so edits that
+// could not be written yet stay on screen
+func (g *dataGrid) applyPending() {
+	for _, r := range g.rows {
+		for field, v := range g.pending[r.ID] {
+			r.Data[field] = v
+		}
+	}
+}
+
+// isUnsaved reports whether the value of a cell failed to be written
+func (g *dataGrid) isUnsaved(id int, field string) bool {
+	if g.saveErr == nil {
+		return false
+	}
+	_, ok := g.pending[id][field]
+	return ok
+}
+
+// discardPending drops the queued edits and reloads the stored values
+func (g *dataGrid) discardPending() {
+	g.pending = map[int]map[string]interface{}{}
+	g.showSaveError(nil)
+	g.populateTableGrid(g.view)
+}
+
+// newSaveBar creates the bar that reports failed writes; it is hidden until one fails
+func (g *dataGrid) newSaveBar() fyne.CanvasObject {
+	g.saveLabel = widget.NewLabel("")
+	g.saveLabel.Truncation = fyne.TextTruncateEllipsis
+	retry := widget.NewButton("Retry", func() { g.flush() })
+	discard := widget.NewButton("Discard", g.discardPending)
+	g.saveBar = container.NewBorder(nil, nil, widget.NewIcon(theme.WarningIcon()), container.NewHBox(retry, discard), g.saveLabel)
+	g.saveBar.Hide()
+	return g.saveBar
+}
+
+// showSaveError shows err in the save bar and outlines the unsaved cells; nil
+// hides the bar
+func (g *dataGrid) showSaveError(err error) {
+	changed := (err == nil) != (g.saveErr == nil)
+	g.saveErr = err
+	if err == nil {
+		g.saveBar.Hide()
+	} else {
+		n := len(g.pendingChanges())
+		g.saveLabel.SetText(fmt.Sprintf("Not saved (%d cells): %v", n, err))
+		g.saveBar.Show()
+	}
+	if changed {
+		g.table.Refresh()
+	}
+}
+
+// showUnsaved outlines the cell as holding a value that was not written
+func (c *gridCell) showUnsaved(on bool) {
+	if on == c.unsavedBox.Visible() {
+		return
+	}
+	if on {
+		c.unsavedBox.Show()
+	} else {
+		c.unsavedBox.Hide()
+	}
+}
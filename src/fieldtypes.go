@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// storage layouts of the date types; both sort correctly as text in SQLite
+const (
+	dateLayout     = "2006-01-02"
+	dateTimeLayout = "2006-01-02T15:04:05"
+)
+
+// dateInputLayouts are the layouts accepted when reading dates from imports or editors
+var dateInputLayouts = []string{
+	time.RFC3339,
+	dateTimeLayout,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	dateLayout,
+	"02.01.2006",
+	"01/02/2006",
+}
+
+// knownFieldTypes lists the types accepted in config.json
+var knownFieldTypes = map[string]bool{
+	"int": true, "float": true, "bool": true, "string": true, "[]string": true,
+	"link": true, "date": true, "datetime": true, "enum": true, "formula": true, "relation": true, "lookup": true, "rollup": true,
+}
+
+// defaultFieldValue returns the value of a field in a new empty row
+func defaultFieldValue(f FieldDef) interface{} {
+	switch f.Type {
+	case "int":
+		return 0
+	case "float":
+		return 0.0
+	case "bool":
+		return false
+	case "[]string":
+		return []string{}
+	case "enum":
+		if len(f.Options) > 0 {
+			return f.Options[0]
+		}
+		return ""
+	case "formula", "lookup", "rollup":
+		return nil // filled in by computeFormulas and fillLookups
+	case "relation":
+		return relationValue(f, nil)
+	default:
+		return ""
+	}
+}
+
+// parseTime reads a date/datetime in any of dateInputLayouts
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateInputLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("not a date")
+}
+
+// parseBool accepts the usual spellings of booleans in spreadsheets
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "true", "yes", "y", "x", "on":
+		return true, nil
+	case "", "0", "false", "no", "n", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("not a boolean")
+}
+
+// parseFieldValue converts text (CSV cells, editor input) to the value stored for a field.
+// listSep splits []string cells.
+func parseFieldValue(f FieldDef, s string, listSep string) (interface{}, error) {
+	switch f.Type {
+	case "int":
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("not an integer")
+		}
+		return n, nil
+	case "float":
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return 0.0, nil
+		}
+		n, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+		if err != nil {
+			return nil, fmt.Errorf("not a number")
+		}
+		return n, nil
+	case "bool":
+		return parseBool(s)
+	case "date", "datetime":
+		if strings.TrimSpace(s) == "" {
+			return "", nil
+		}
+		t, err := parseTime(s)
+		if err != nil {
+			return nil, err
+		}
+		if f.Type == "date" {
+			return t.Format(dateLayout), nil
+		}
+		return t.Format(dateTimeLayout), nil
+	case "enum":
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return "", nil
+		}
+		for _, o := range f.Options {
+			if strings.EqualFold(o, s) {
+				return o, nil
+			}
+		}
+		return nil, fmt.Errorf("not one of %s", strings.Join(f.Options, ", "))
+	case "[]string":
+		list := []string{}
+		if listSep == "" {
+			listSep = ";"
+		}
+		for _, it := range strings.Split(s, listSep) {
+			if it = strings.TrimSpace(it); it != "" {
+				list = append(list, it)
+			}
+		}
+		return list, nil
+	case "relation":
+		return parseRelationIDs(f, s, listSep)
+	default:
+		return s, nil
+	}
+}
+
+// normalizeFieldValue converts a value decoded from JSON (import files) to the
+// stored representation of the field type.
+func normalizeFieldValue(f FieldDef, v interface{}) (interface{}, error) {
+	if v == nil {
+		return defaultFieldValue(f), nil
+	}
+	switch f.Type {
+	case "int":
+		switch t := v.(type) {
+		case float64:
+			if t != math.Trunc(t) {
+				return nil, fmt.Errorf("not an integer")
+			}
+			return int(t), nil
+		case int:
+			return t, nil
+		}
+	case "float":
+		switch t := v.(type) {
+		case float64:
+			return t, nil
+		case int:
+			return float64(t), nil
+		}
+	case "bool":
+		switch t := v.(type) {
+		case bool:
+			return t, nil
+		case float64:
+			return t != 0, nil
+		}
+	case "[]string":
+		return toStringList(v), nil
+	case "relation":
+		switch v.(type) {
+		case float64, int, []interface{}:
+			ids := relationIDs(v)
+			if len(ids) > 1 && !f.Multiple {
+				return nil, fmt.Errorf("only one row allowed")
+			}
+			return relationValue(f, ids), nil
+		}
+	}
+	if s, ok := v.(string); ok {
+		return parseFieldValue(f, s, ";")
+	}
+	if f.Type == "string" || f.Type == "link" {
+		return fmt.Sprintf("%v", v), nil
+	}
+	return nil, fmt.Errorf("unexpected %T", v)
+}
+
+// normalizeRow converts every schema field present in data with normalizeFieldValue.
+// The first failing field is returned as an error naming the field.
+func normalizeRow(schema []FieldDef, data map[string]interface{}) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	for k, v := range data {
+		out[k] = v
+	}
+	for _, f := range schema {
+		v, ok := data[f.Name]
+		if !ok {
+			continue
+		}
+		if isComputedField(f) {
+			// imported formula, lookup and rollup values are ignored, they are computed again
+			delete(out, f.Name)
+			continue
+		}
+		nv, err := normalizeFieldValue(f, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		out[f.Name] = nv
+	}
+	return out, nil
+}
+
+// formatFieldValue renders a field value as text (CSV cells, print, read-only labels)
+func formatFieldValue(f FieldDef, v interface{}, listSep string) string {
+	switch f.Type {
+	case "int":
+		return intText(v)
+	case "float":
+		return floatText(v)
+	case "bool":
+		if b, ok := v.(bool); ok {
+			return strconv.FormatBool(b)
+		}
+		return "false"
+	case "[]string":
+		return strings.Join(toStringList(v), listSep)
+	case "relation":
+		var parts []string
+		for _, id := range relationIDs(v) {
+			parts = append(parts, strconv.Itoa(id))
+		}
+		return strings.Join(parts, listSep)
+	case "lookup", "rollup":
+		return lookupText(v, listSep)
+	case "formula":
+		if l, ok := formulaValue(v).([]string); ok {
+			return strings.Join(l, listSep)
+		}
+		return formulaText(formulaValue(v))
+	default:
+		if v == nil {
+			return ""
+		}
+		if s, ok := v.(string); ok {
+			return s
+		}
+		js, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(js)
+	}
+}
+
+// floatText formats a float field value loaded from JSON for an entry
+func floatText(v interface{}) string {
+	switch t := v.(type) {
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(t)
+	case string:
+		return t
+	}
+	return ""
+}
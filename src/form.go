@@ -0,0 +1,472 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// The row form edits one row at a time with a full-size editor per field, for
+// rows that don't fit the grid columns. It opens from the Form button of the
+// actions column or by double-clicking a row. Edits stay in the form until Save
+// writes them as one undo step; Cancel drops them. Previous and Next move through
+// the rows of the current view, asking first what to do with unsaved edits. Which
+// fields the form shows, in which order and titled groups, is set per view
+// (View.Form, edited in the Edit View dialog).
+
+// formSection is a group of the row form with its fields resolved
+type formSection struct {
+	Title  string
+	Fields []FieldDef
+}
+
+// formSections resolves the form layout of a view against schema; unknown field
+// names are skipped and an empty layout shows every field in one group
+func formSections(schema []FieldDef, groups []FormGroup) []formSection {
+	if len(groups) == 0 {
+		return []formSection{{Fields: schema}}
+	}
+	byName := map[string]FieldDef{}
+	for _, f := range schema {
+		byName[f.Name] = f
+	}
+	var out []formSection
+	for _, g := range groups {
+		s := formSection{Title: g.Title}
+		for _, name := range g.Fields {
+			if f, ok := byName[name]; ok {
+				s.Fields = append(s.Fields, f)
+			}
+		}
+		if len(s.Fields) > 0 {
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		// none of the fields is left in the schema
+		return []formSection{{Fields: schema}}
+	}
+	return out
+}
+
+// rowForm is an open row form
+type rowForm struct {
+	g        *dataGrid
+	sections []formSection
+	dlg      *dialog.CustomDialog
+
+	// row shown, its values when it was loaded and the values being edited
+	rowIdx int
+	id     int
+	orig   map[string]interface{}
+	draft  map[string]interface{}
+
+	// number fields whose text doesn't parse, by field name, with the reason
+	invalid map[string]string
+
+	// labels showing the validation problem of each field and the computed values
+	problems map[string]*widget.Label
+	computed map[string]*widget.Label
+
+	body      *container.Scroll
+	pos       *widget.Label
+	prevBtn   *widget.Button
+	nextBtn   *widget.Button
+	cancelBtn *widget.Button
+	saveBtn   *widget.Button
+}
+
+// showRowForm opens the row form on the row at rowIdx of the grid
+func (g *dataGrid) showRowForm(rowIdx int) {
+	if rowIdx < 0 || rowIdx >= len(g.rows) {
+		return
+	}
+	g.flush()
+	f := &rowForm{g: g, sections: formSections(g.schema, g.view.Form)}
+	f.pos = widget.NewLabel("")
+	f.pos.TextStyle = fyne.TextStyle{Bold: true}
+	f.body = container.NewVScroll(container.NewVBox())
+	f.prevBtn = widget.NewButton("◀ Previous", func() { f.move(-1) })
+	f.nextBtn = widget.NewButton("Next ▶", func() { f.move(1) })
+	f.cancelBtn = widget.NewButton("Cancel", func() { f.dlg.Hide() })
+	f.saveBtn = widget.NewButton("Save", func() { f.save() })
+	f.saveBtn.Importance = widget.HighImportance
+
+	f.dlg = dialog.NewCustomWithoutButtons("Edit row", container.NewBorder(f.pos, nil, nil, nil, f.body), g.win)
+	f.dlg.SetButtons([]fyne.CanvasObject{f.prevBtn, f.nextBtn, f.cancelBtn, f.saveBtn})
+	f.load(rowIdx)
+	f.dlg.Resize(fyne.NewSize(640, 640))
+	f.dlg.Show()
+}
+
+// DoubleTapped opens the row form on the row of the cell
+func (c *gridCell) DoubleTapped(*fyne.PointEvent) {
+	c.g.showRowForm(c.id.Row)
+}
+
+// rowIndex returns the index of the loaded row with id, -1 when it isn't loaded
+func (g *dataGrid) rowIndex(id int) int {
+	for i, r := range g.rows {
+		if r.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// load shows the row at rowIdx; -1 keeps the position, for a row that left the view
+func (f *rowForm) load(rowIdx int) {
+	rows := f.g.rows
+	if len(rows) == 0 {
+		f.dlg.Hide()
+		return
+	}
+	if rowIdx < 0 {
+		rowIdx = f.rowIdx
+	}
+	rowIdx = max(0, min(rowIdx, len(rows)-1))
+	r := rows[rowIdx]
+	f.rowIdx, f.id = rowIdx, r.ID
+	f.orig = map[string]interface{}{}
+	f.draft = map[string]interface{}{}
+	for k, v := range r.Data {
+		f.orig[k] = v
+		f.draft[k] = v
+	}
+	f.invalid = map[string]string{}
+	f.problems = map[string]*widget.Label{}
+	f.computed = map[string]*widget.Label{}
+
+	box := container.NewVBox()
+	for _, s := range f.sections {
+		form := widget.NewForm()
+		for _, fd := range s.Fields {
+			problem := widget.NewLabel("")
+			problem.Importance = widget.DangerImportance
+			problem.Wrapping = fyne.TextWrapWord
+			problem.Hide()
+			f.problems[fd.Name] = problem
+			form.AppendItem(widget.NewFormItem(fd.Label, container.NewVBox(f.editor(fd), problem)))
+			f.showProblem(fd)
+		}
+		if s.Title != "" {
+			box.Add(widget.NewCard(s.Title, "", form))
+		} else {
+			box.Add(form)
+		}
+	}
+	f.body.Content = box
+	f.body.Refresh()
+	f.body.ScrollToTop()
+	f.pos.SetText(fmt.Sprintf("Row %d (%d of %d)", f.id, rowIdx+1, len(rows)))
+	f.updateButtons()
+}
+
+// editor creates the editor of field fd
+func (f *rowForm) editor(fd FieldDef) fyne.CanvasObject {
+	g := f.g
+	name := fd.Name
+	v := f.draft[name]
+	switch fd.Type {
+	case "int", "float":
+		if fd.Type == "int" && strings.EqualFold(name, "ID") {
+			return widget.NewLabel(intText(f.id))
+		}
+		e := f.newEntry(false)
+		e.SetText(formatFieldValue(fd, v, ""))
+		e.OnChanged = func(s string) {
+			val, err := parseFieldValue(fd, s, "")
+			if err != nil {
+				f.invalid[name] = err.Error()
+				f.showProblem(fd)
+				f.updateButtons()
+				return
+			}
+			delete(f.invalid, name)
+			f.set(fd, val)
+		}
+		return e
+	case "bool":
+		b, _ := v.(bool)
+		c := widget.NewCheck("", func(on bool) { f.set(fd, on) })
+		c.Checked = b
+		return c
+	case "date":
+		d := widget.NewDateEntry()
+		d.SetDate(fieldTime(v))
+		d.OnChanged = func(t *time.Time) {
+			val := ""
+			if t != nil {
+				val = t.Format(dateLayout)
+			}
+			f.set(fd, val)
+		}
+		return d
+	case "datetime":
+		return f.dateTimeEditor(fd)
+	case "enum":
+		sel := widget.NewSelect(fd.Options, nil)
+		sel.Selected, _ = v.(string)
+		sel.OnChanged = func(s string) { f.set(fd, s) }
+		return sel
+	case "formula", "lookup", "rollup":
+		l := widget.NewLabel(formatFieldValue(fd, v, ", "))
+		l.Wrapping = fyne.TextWrapWord
+		f.computed[name] = l
+		return l
+	case "relation":
+		return f.relationEditor(fd)
+	case "[]string":
+		list := makeListEditorInline(g.win, toStringList(v), func() *cellEntry { return f.newEntry(false) }, func(out []string) {
+			if out == nil {
+				out = []string{}
+			}
+			f.set(fd, out)
+		})
+		// the form scrolls as a whole, so lists show all their items
+		if s, ok := list.(*container.Scroll); ok {
+			list = s.Content
+		}
+		return list
+	case "link":
+		e := f.newEntry(false)
+		e.SetText(formatFieldValue(fd, v, ""))
+		e.OnChanged = func(s string) { f.set(fd, s) }
+		return e
+	default:
+		e := f.newEntry(true)
+		e.Wrapping = fyne.TextWrapWord
+		e.SetMinRowsVisible(4)
+		e.SetText(formatFieldValue(fd, v, ""))
+		e.OnChanged = func(s string) { f.set(fd, s) }
+		return e
+	}
+}
+
+// newEntry creates an entry of the form; Tab moves to the next field, also in
+// multi-line entries
+func (f *rowForm) newEntry(multiLine bool) *cellEntry {
+	e := newCellEntry(multiLine)
+	e.onKey = func(e *cellEntry, k *fyne.KeyEvent) bool {
+		if k.Name != fyne.KeyTab {
+			return false
+		}
+		if shiftHeld() {
+			f.g.win.Canvas().FocusPrevious()
+		} else {
+			f.g.win.Canvas().FocusNext()
+		}
+		return true
+	}
+	return e
+}
+
+// dateTimeEditor is a date picker plus an HH:MM entry, like the grid's
+func (f *rowForm) dateTimeEditor(fd FieldDef) fyne.CanvasObject {
+	date := widget.NewDateEntry()
+	hm := widget.NewEntry()
+	hm.SetPlaceHolder("15:04")
+	t := fieldTime(f.draft[fd.Name])
+	date.SetDate(t)
+	if t != nil {
+		hm.SetText(t.Format("15:04"))
+	}
+	store := func() {
+		if date.Date == nil {
+			f.set(fd, "")
+			return
+		}
+		d := *date.Date
+		if at, err := time.Parse("15:04", strings.TrimSpace(hm.Text)); err == nil {
+			d = time.Date(d.Year(), d.Month(), d.Day(), at.Hour(), at.Minute(), 0, 0, time.Local)
+		}
+		f.set(fd, d.Format(dateTimeLayout))
+	}
+	date.OnChanged = func(*time.Time) { store() }
+	hm.OnChanged = func(s string) {
+		if _, err := time.Parse("15:04", strings.TrimSpace(s)); err == nil || s == "" {
+			store()
+		}
+	}
+	return container.NewBorder(nil, nil, nil, hm, date)
+}
+
+// relationEditor shows the labels of the referenced rows and a button opening the picker
+func (f *rowForm) relationEditor(fd FieldDef) fyne.CanvasObject {
+	g := f.g
+	label := widget.NewLabel("")
+	label.Wrapping = fyne.TextWrapWord
+	labels := map[int]string{}
+	for k, v := range g.relLabels[fd.Name] {
+		labels[k] = v
+	}
+	show := func() {
+		text := relationText(relationIDs(f.draft[fd.Name]), labels)
+		if text == "" {
+			text = "—"
+		}
+		label.SetText(text)
+	}
+	show()
+	choose := widget.NewButton("Choose...", func() {
+		target, ok := g.relTargets[fd.Name]
+		if !ok {
+			dialog.ShowError(fmt.Errorf("field %s: no sheet named %q", fd.Label, fd.Target), g.win)
+			return
+		}
+		showRelationPicker(g.win, g.db, target, fd, relationIDs(f.draft[fd.Name]), func(chosen []int) {
+			got, err := relationLabels(g.db, target, fd, chosen)
+			if err != nil {
+				log.Printf("warning: failed to load labels of %s: %v", fd.Name, err)
+			}
+			for k, v := range got {
+				labels[k] = v
+			}
+			f.set(fd, relationValue(fd, chosen))
+			show()
+		})
+	})
+	return container.NewBorder(nil, nil, nil, choose, label)
+}
+
+// set stores an edited value in the draft and updates what depends on it
+func (f *rowForm) set(fd FieldDef, v interface{}) {
+	f.draft[fd.Name] = v
+	f.showProblem(fd)
+
+	// formula values follow the draft; lookups keep their stored values until saved
+	data := map[string]interface{}{}
+	for k, v := range f.draft {
+		data[k] = v
+	}
+	computeFormulas(f.g.schema, data)
+	for _, ff := range formulaFields(f.g.schema) {
+		if l := f.computed[ff.Name]; l != nil {
+			l.SetText(formatFieldValue(ff, data[ff.Name], ", "))
+		}
+	}
+	f.updateButtons()
+}
+
+// showProblem shows why the value of fd is invalid, if it is
+func (f *rowForm) showProblem(fd FieldDef) {
+	l := f.problems[fd.Name]
+	if l == nil {
+		return
+	}
+	msg := f.invalid[fd.Name]
+	if msg == "" && !isComputedField(fd) {
+		if err := validateFieldValue(fd, f.draft[fd.Name]); err != nil {
+			msg = err.Error()
+		}
+	}
+	l.SetText(msg)
+	if msg == "" {
+		l.Hide()
+	} else {
+		l.Show()
+	}
+}
+
+// changes returns the edited fields of the draft
+func (f *rowForm) changes() []cellChange {
+	var out []cellChange
+	for _, s := range f.sections {
+		for _, fd := range s.Fields {
+			if !isEditableField(fd) {
+				continue
+			}
+			// compared as stored, so a list edited back to its items is unchanged
+			if v := f.draft[fd.Name]; jsonValue(v, true) != jsonValue(f.orig[fd.Name], true) {
+				out = append(out, cellChange{ID: f.id, Field: fd.Name, Value: v})
+			}
+		}
+	}
+	return out
+}
+
+// dirty reports whether the form holds edits that were not saved
+func (f *rowForm) dirty() bool {
+	return len(f.invalid) > 0 || len(f.changes()) > 0
+}
+
+// updateButtons enables the buttons that apply to the shown row and edits
+func (f *rowForm) updateButtons() {
+	enable := func(b *widget.Button, on bool) {
+		if on {
+			b.Enable()
+		} else {
+			b.Disable()
+		}
+	}
+	dirty := f.dirty()
+	enable(f.prevBtn, f.rowIdx > 0)
+	enable(f.nextBtn, f.rowIdx < len(f.g.rows)-1)
+	enable(f.saveBtn, dirty)
+	if dirty {
+		f.cancelBtn.SetText("Cancel")
+	} else {
+		f.cancelBtn.SetText("Close")
+	}
+}
+
+// save writes the edits of the form as one undo step and reloads the grid; false
+// when nothing could be saved
+func (f *rowForm) save() bool {
+	g := f.g
+	for _, s := range f.sections {
+		for _, fd := range s.Fields {
+			if msg, ok := f.invalid[fd.Name]; ok {
+				dialog.ShowError(fmt.Errorf("nothing saved: %s: %s", fd.Label, msg), g.win)
+				return false
+			}
+		}
+	}
+	changes := f.changes()
+	if len(changes) == 0 {
+		return true
+	}
+	if err := undoableSetCells(g.db, g.schema, fmt.Sprintf("Edit row %d", f.id), "", changes); err != nil {
+		dialog.ShowError(fmt.Errorf("nothing saved: %w", err), g.win)
+		return false
+	}
+	g.populateTableGrid(g.view)
+	// sorting or filters may have moved the row, or taken it out of the view
+	f.load(g.rowIndex(f.id))
+	return true
+}
+
+// move shows the previous (delta -1) or next (delta 1) row of the view
+func (f *rowForm) move(delta int) {
+	idx := f.rowIdx + delta
+	if idx < 0 || idx >= len(f.g.rows) {
+		return
+	}
+	target := f.g.rows[idx].ID
+	show := func() {
+		if i := f.g.rowIndex(target); i >= 0 {
+			f.load(i)
+		} else {
+			f.load(idx)
+		}
+	}
+	if !f.dirty() {
+		show()
+		return
+	}
+	d := dialog.NewConfirm("Unsaved changes", fmt.Sprintf("Save the changes to row %d?", f.id), func(save bool) {
+		if save && !f.save() {
+			return
+		}
+		show()
+	}, f.g.win)
+	d.SetConfirmText("Save")
+	d.SetDismissText("Discard")
+	d.Show()
+}
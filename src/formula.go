@@ -0,0 +1,939 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Formula fields hold an expression over the other fields of the same row.
+// Their values are computed whenever a row is written and stored in the row
+// data like any other field, so filters, sorts and exports see them.
+//
+// Expression syntax:
+//
+//	literals    12, 3.5, "text", 'text', true, false
+//	fields      IntField, Name, [Field with spaces]
+//	arithmetic  + - * / %   (+ joins text when either side is text, & always joins text)
+//	comparison  = == != <> < <= > >=
+//	logic       and or not  (also && || !)
+//	functions   if(cond, then, else), len(x), join(list, sep), contains(x, item),
+//	            split(text, sep), upper, lower, trim, concat(...), left(text, n),
+//	            right(text, n), substr(text, start, n), replace(text, old, new),
+//	            round(x[, digits]), floor, ceil, abs, min(...), max(...), sum(list),
+//	            text(x), number(x), empty(x)
+//
+// Values are numbers (float64), text, booleans and lists of text.
+
+// formulaNode is a parsed expression
+type formulaNode interface {
+	eval(row map[string]interface{}) (interface{}, error)
+}
+
+type formulaLiteral struct{ v interface{} }
+
+type formulaField struct{ name string }
+
+type formulaUnary struct {
+	op string
+	x  formulaNode
+}
+
+type formulaBinary struct {
+	op   string
+	l, r formulaNode
+}
+
+type formulaCall struct {
+	name string
+	args []formulaNode
+}
+
+// compileFormulas parses the Formula of every "formula" field and rejects references
+// to unknown fields and circular references between formulas.
+func compileFormulas(schema []FieldDef) error {
+	known := map[string]bool{}
+	for _, f := range schema {
+		known[f.Name] = true
+	}
+	deps := map[string][]string{}
+	for i := range schema {
+		f := &schema[i]
+		f.expr, f.formulaRefs = nil, nil
+		if f.Type != "formula" {
+			continue
+		}
+		expr, refs, err := parseFormula(f.Formula)
+		if err != nil {
+			return fmt.Errorf("field %s: formula: %v", f.Name, err)
+		}
+		for _, r := range refs {
+			if !known[r] {
+				return fmt.Errorf("field %s: formula refers to unknown field %q", f.Name, r)
+			}
+		}
+		f.expr, f.formulaRefs = expr, refs
+		deps[f.Name] = refs
+	}
+	if _, err := formulaOrder(schema, deps); err != nil {
+		return err
+	}
+	return nil
+}
+
+// formulaOrder sorts the formula fields so that every formula comes after the formulas it uses
+func formulaOrder(schema []FieldDef, deps map[string][]string) ([]FieldDef, error) {
+	byName := map[string]FieldDef{}
+	for _, f := range schema {
+		if f.Type == "formula" {
+			byName[f.Name] = f
+		}
+	}
+	var out []FieldDef
+	state := map[string]int{} // 1 visiting, 2 done
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("formula of field %s has a circular reference", name)
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, d := range deps[name] {
+			if _, ok := byName[d]; ok {
+				if err := visit(d); err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = 2
+		out = append(out, byName[name])
+		return nil
+	}
+	for _, f := range schema {
+		if f.Type == "formula" {
+			if err := visit(f.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// formulaFields returns the compiled formula fields of schema in evaluation order
+func formulaFields(schema []FieldDef) []FieldDef {
+	deps := map[string][]string{}
+	for _, f := range schema {
+		if f.Type == "formula" && f.expr != nil {
+			deps[f.Name] = f.formulaRefs
+		}
+	}
+	if len(deps) == 0 {
+		return nil
+	}
+	order, err := formulaOrder(schema, deps)
+	if err != nil {
+		return nil
+	}
+	return order
+}
+
+// evalFormulaField computes the value of formula field f for a row
+func evalFormulaField(f FieldDef, data map[string]interface{}) (interface{}, error) {
+	if f.expr == nil {
+		return nil, fmt.Errorf("formula is not compiled")
+	}
+	v, err := f.expr.eval(data)
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := v.(float64); ok && (math.IsNaN(n) || math.IsInf(n, 0)) {
+		return nil, fmt.Errorf("result is not a number")
+	}
+	return v, nil
+}
+
+// computeFormulas stores the value of every formula field in data and returns those
+// values. A formula that fails to evaluate stores nil.
+func computeFormulas(schema []FieldDef, data map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for _, f := range formulaFields(schema) {
+		v, err := evalFormulaField(f, data)
+		if err != nil {
+			v = nil
+		}
+		data[f.Name] = v
+		out[f.Name] = v
+	}
+	return out
+}
+
+// recomputeFormulas brings the stored formula values of all rows of a sheet up to date, e.g.
+// after a formula was changed in config.json. It runs in one transaction and is not an undo step.
+func recomputeFormulas(db *sql.DB, sheet int, schema []FieldDef) error {
+	if len(formulaFields(schema)) == 0 {
+		return nil
+	}
+	rows, err := getAllRows(db, sheet)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, r := range rows {
+		before, _ := json.Marshal(r.Data)
+		computeFormulas(schema, r.Data)
+		after, err := json.Marshal(r.Data)
+		if err != nil {
+			return err
+		}
+		if string(before) == string(after) {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE entries SET data = ? WHERE id = ?", string(after), r.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ---- parsing ----
+
+type formulaToken struct {
+	kind string // "num", "str", "ident", "op", "eof"
+	text string
+	pos  int
+}
+
+func tokenizeFormula(src string) ([]formulaToken, error) {
+	var toks []formulaToken
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			toks = append(toks, formulaToken{"num", string(rs[i:j]), i})
+			i = j
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(rs) && rs[j] != c; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				}
+				b.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated text at %d", i+1)
+			}
+			toks = append(toks, formulaToken{"str", b.String(), i})
+			i = j + 1
+		case c == '[':
+			j := i + 1
+			for j < len(rs) && rs[j] != ']' {
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("missing ] for field at %d", i+1)
+			}
+			toks = append(toks, formulaToken{"ident", string(rs[i+1 : j]), i})
+			i = j + 1
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			toks = append(toks, formulaToken{"ident", string(rs[i:j]), i})
+			i = j
+		default:
+			op := string(c)
+			if i+1 < len(rs) {
+				switch two := string(rs[i : i+2]); two {
+				case "==", "!=", "<>", "<=", ">=", "&&", "||":
+					op = two
+				}
+			}
+			if len(op) == 1 && !strings.Contains("+-*/%&=!<>(),", op) {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i+1)
+			}
+			toks = append(toks, formulaToken{"op", op, i})
+			i += len([]rune(op))
+		}
+	}
+	return append(toks, formulaToken{kind: "eof", pos: len(rs)}), nil
+}
+
+type formulaParser struct {
+	toks []formulaToken
+	pos  int
+	refs []string
+}
+
+// parseFormula parses an expression and returns it with the field names it refers to
+func parseFormula(src string) (formulaNode, []string, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, nil, fmt.Errorf("empty formula")
+	}
+	toks, err := tokenizeFormula(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &formulaParser{toks: toks}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos+1)
+	}
+	return n, p.refs, nil
+}
+
+func (p *formulaParser) peek() formulaToken { return p.toks[p.pos] }
+
+func (p *formulaParser) next() formulaToken {
+	t := p.toks[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is one of ops (operators or keywords)
+func (p *formulaParser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != "op" && t.kind != "ident" {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.kind == "op" && t.text == op || t.kind == "ident" && strings.EqualFold(t.text, op) && isFormulaKeyword(op) {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func isFormulaKeyword(s string) bool {
+	return s == "and" || s == "or" || s == "not"
+}
+
+func (p *formulaParser) parseOr() (formulaNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("or", "||"); !ok {
+			return l, nil
+		}
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = formulaBinary{"or", l, r}
+	}
+}
+
+func (p *formulaParser) parseAnd() (formulaNode, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("and", "&&"); !ok {
+			return l, nil
+		}
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = formulaBinary{"and", l, r}
+	}
+}
+
+func (p *formulaParser) parseNot() (formulaNode, error) {
+	if _, ok := p.accept("not", "!"); ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return formulaUnary{"not", x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *formulaParser) parseComparison() (formulaNode, error) {
+	l, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<>", "<=", ">=", "=", "<", ">")
+	if !ok {
+		return l, nil
+	}
+	r, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "==":
+		op = "="
+	case "<>":
+		op = "!="
+	}
+	return formulaBinary{op, l, r}, nil
+}
+
+func (p *formulaParser) parseAdditive() (formulaNode, error) {
+	l, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-", "&")
+		if !ok {
+			return l, nil
+		}
+		r, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l = formulaBinary{op, l, r}
+	}
+}
+
+func (p *formulaParser) parseMultiplicative() (formulaNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/", "%")
+		if !ok {
+			return l, nil
+		}
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = formulaBinary{op, l, r}
+	}
+}
+
+func (p *formulaParser) parseUnary() (formulaNode, error) {
+	if _, ok := p.accept("-"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return formulaUnary{"-", x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *formulaParser) parsePrimary() (formulaNode, error) {
+	t := p.next()
+	switch t.kind {
+	case "num":
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", t.text, t.pos+1)
+		}
+		return formulaLiteral{n}, nil
+	case "str":
+		return formulaLiteral{t.text}, nil
+	case "ident":
+		// function call
+		if p.peek().kind == "op" && p.peek().text == "(" {
+			p.next()
+			name := strings.ToLower(t.text)
+			if _, ok := formulaFuncs[name]; !ok && name != "if" {
+				return nil, fmt.Errorf("unknown function %s at %d", t.text, t.pos+1)
+			}
+			var args []formulaNode
+			if _, ok := p.accept(")"); !ok {
+				for {
+					a, err := p.parseOr()
+					if err != nil {
+						return nil, err
+					}
+					args = append(args, a)
+					if _, ok := p.accept(","); ok {
+						continue
+					}
+					if _, ok := p.accept(")"); !ok {
+						return nil, fmt.Errorf("expected , or ) at %d", p.peek().pos+1)
+					}
+					break
+				}
+			}
+			if name == "if" && len(args) != 3 {
+				return nil, fmt.Errorf("if needs 3 arguments")
+			}
+			return formulaCall{name, args}, nil
+		}
+		switch strings.ToLower(t.text) {
+		case "true":
+			return formulaLiteral{true}, nil
+		case "false":
+			return formulaLiteral{false}, nil
+		}
+		p.refs = append(p.refs, t.text)
+		return formulaField{t.text}, nil
+	case "op":
+		if t.text == "(" {
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.accept(")"); !ok {
+				return nil, fmt.Errorf("missing ) at %d", p.peek().pos+1)
+			}
+			return n, nil
+		}
+	case "eof":
+		return nil, fmt.Errorf("unexpected end of formula")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos+1)
+}
+
+// ---- evaluation ----
+
+func (n formulaLiteral) eval(map[string]interface{}) (interface{}, error) { return n.v, nil }
+
+func (n formulaField) eval(row map[string]interface{}) (interface{}, error) {
+	return formulaValue(row[n.name]), nil
+}
+
+// formulaValue converts a stored field value to a formula value
+func formulaValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case int:
+		return float64(t)
+	case int64:
+		return float64(t)
+	case []interface{}, []string:
+		return toStringList(t)
+	}
+	return v
+}
+
+func (n formulaUnary) eval(row map[string]interface{}) (interface{}, error) {
+	x, err := n.x.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "not" {
+		return !formulaTruthy(x), nil
+	}
+	f, err := formulaNumber(x)
+	if err != nil {
+		return nil, err
+	}
+	return -f, nil
+}
+
+func (n formulaBinary) eval(row map[string]interface{}) (interface{}, error) {
+	l, err := n.l.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	// short-circuit logic
+	switch n.op {
+	case "and":
+		if !formulaTruthy(l) {
+			return false, nil
+		}
+		r, err := n.r.eval(row)
+		return formulaTruthy(r), err
+	case "or":
+		if formulaTruthy(l) {
+			return true, nil
+		}
+		r, err := n.r.eval(row)
+		return formulaTruthy(r), err
+	}
+
+	r, err := n.r.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "&":
+		return formulaText(l) + formulaText(r), nil
+	case "+":
+		_, ls := l.(string)
+		_, rs := r.(string)
+		if ls || rs {
+			return formulaText(l) + formulaText(r), nil
+		}
+	case "=", "!=", "<", "<=", ">", ">=":
+		c := formulaCompare(l, r)
+		switch n.op {
+		case "=":
+			return c == 0, nil
+		case "!=":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	}
+
+	a, err := formulaNumber(l)
+	if err != nil {
+		return nil, err
+	}
+	b, err := formulaNumber(r)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return a / b, nil
+	case "%":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(a, b), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+func (n formulaCall) eval(row map[string]interface{}) (interface{}, error) {
+	// if only evaluates the branch it returns
+	if n.name == "if" {
+		c, err := n.args[0].eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if formulaTruthy(c) {
+			return n.args[1].eval(row)
+		}
+		return n.args[2].eval(row)
+	}
+	args := make([]interface{}, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	fn := formulaFuncs[n.name]
+	if len(args) < fn.min || (fn.max >= 0 && len(args) > fn.max) {
+		return nil, fmt.Errorf("%s: wrong number of arguments", n.name)
+	}
+	return fn.call(args)
+}
+
+// formulaFunc is a built-in function; max < 0 means any number of arguments
+type formulaFunc struct {
+	min, max int
+	call     func(args []interface{}) (interface{}, error)
+}
+
+var formulaFuncs map[string]formulaFunc
+
+func init() {
+	textFn := func(f func(string) string) formulaFunc {
+		return formulaFunc{1, 1, func(a []interface{}) (interface{}, error) { return f(formulaText(a[0])), nil }}
+	}
+	numFn := func(f func(float64) float64) formulaFunc {
+		return formulaFunc{1, 1, func(a []interface{}) (interface{}, error) {
+			n, err := formulaNumber(a[0])
+			if err != nil {
+				return nil, err
+			}
+			return f(n), nil
+		}}
+	}
+	// extreme returns the smallest (sign 1) or largest (sign -1) number of the arguments, lists are flattened
+	extreme := func(sign float64) func(a []interface{}) (interface{}, error) {
+		return func(a []interface{}) (interface{}, error) {
+			nums, err := formulaNumbers(a)
+			if err != nil || len(nums) == 0 {
+				return nil, err
+			}
+			best := nums[0]
+			for _, n := range nums[1:] {
+				if (n-best)*sign < 0 {
+					best = n
+				}
+			}
+			return best, nil
+		}
+	}
+
+	formulaFuncs = map[string]formulaFunc{
+		"len": {1, 1, func(a []interface{}) (interface{}, error) {
+			if list, ok := a[0].([]string); ok {
+				return float64(len(list)), nil
+			}
+			return float64(len([]rune(formulaText(a[0])))), nil
+		}},
+		"join": {1, 2, func(a []interface{}) (interface{}, error) {
+			sep := ", "
+			if len(a) > 1 {
+				sep = formulaText(a[1])
+			}
+			return strings.Join(formulaList(a[0]), sep), nil
+		}},
+		"contains": {2, 2, func(a []interface{}) (interface{}, error) {
+			item := formulaText(a[1])
+			if list, ok := a[0].([]string); ok {
+				for _, it := range list {
+					if it == item {
+						return true, nil
+					}
+				}
+				return false, nil
+			}
+			return strings.Contains(formulaText(a[0]), item), nil
+		}},
+		"split": {2, 2, func(a []interface{}) (interface{}, error) {
+			out := []string{}
+			for _, it := range strings.Split(formulaText(a[0]), formulaText(a[1])) {
+				if it = strings.TrimSpace(it); it != "" {
+					out = append(out, it)
+				}
+			}
+			return out, nil
+		}},
+		"upper": textFn(strings.ToUpper),
+		"lower": textFn(strings.ToLower),
+		"trim":  textFn(strings.TrimSpace),
+		"text":  textFn(func(s string) string { return s }),
+		"concat": {0, -1, func(a []interface{}) (interface{}, error) {
+			var b strings.Builder
+			for _, v := range a {
+				b.WriteString(formulaText(v))
+			}
+			return b.String(), nil
+		}},
+		"left": {2, 2, func(a []interface{}) (interface{}, error) {
+			rs := []rune(formulaText(a[0]))
+			n, err := formulaNumber(a[1])
+			if err != nil {
+				return nil, err
+			}
+			return string(rs[:clampIndex(int(n), len(rs))]), nil
+		}},
+		"right": {2, 2, func(a []interface{}) (interface{}, error) {
+			rs := []rune(formulaText(a[0]))
+			n, err := formulaNumber(a[1])
+			if err != nil {
+				return nil, err
+			}
+			return string(rs[len(rs)-clampIndex(int(n), len(rs)):]), nil
+		}},
+		"substr": {2, 3, func(a []interface{}) (interface{}, error) {
+			// start is 1-based like in spreadsheets
+			rs := []rune(formulaText(a[0]))
+			start, err := formulaNumber(a[1])
+			if err != nil {
+				return nil, err
+			}
+			from := clampIndex(int(start)-1, len(rs))
+			to := len(rs)
+			if len(a) > 2 {
+				n, err := formulaNumber(a[2])
+				if err != nil {
+					return nil, err
+				}
+				to = from + clampIndex(int(n), len(rs)-from)
+			}
+			return string(rs[from:to]), nil
+		}},
+		"replace": {3, 3, func(a []interface{}) (interface{}, error) {
+			return strings.ReplaceAll(formulaText(a[0]), formulaText(a[1]), formulaText(a[2])), nil
+		}},
+		"round": {1, 2, func(a []interface{}) (interface{}, error) {
+			n, err := formulaNumber(a[0])
+			if err != nil {
+				return nil, err
+			}
+			digits := 0.0
+			if len(a) > 1 {
+				if digits, err = formulaNumber(a[1]); err != nil {
+					return nil, err
+				}
+			}
+			p := math.Pow(10, digits)
+			return math.Round(n*p) / p, nil
+		}},
+		"floor": numFn(math.Floor),
+		"ceil":  numFn(math.Ceil),
+		"abs":   numFn(math.Abs),
+		"min":   {1, -1, extreme(1)},
+		"max":   {1, -1, extreme(-1)},
+		"sum": {1, -1, func(a []interface{}) (interface{}, error) {
+			nums, err := formulaNumbers(a)
+			if err != nil {
+				return nil, err
+			}
+			total := 0.0
+			for _, n := range nums {
+				total += n
+			}
+			return total, nil
+		}},
+		"number": {1, 1, func(a []interface{}) (interface{}, error) { return formulaNumber(a[0]) }},
+		"empty":  {1, 1, func(a []interface{}) (interface{}, error) { return isEmptyValue(a[0]), nil }},
+	}
+}
+
+// clampIndex limits i to [0, n]
+func clampIndex(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+// formulaNumber converts a value to a number; empty values are 0
+func formulaNumber(v interface{}) (float64, error) {
+	switch t := v.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return t, nil
+	case bool:
+		if t {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		s := strings.TrimSpace(t)
+		if s == "" {
+			return 0, nil
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", t)
+		}
+		return n, nil
+	case []string:
+		return 0, fmt.Errorf("a list is not a number")
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// formulaNumbers converts the arguments to numbers, flattening lists
+func formulaNumbers(args []interface{}) ([]float64, error) {
+	var out []float64
+	for _, a := range args {
+		if list, ok := a.([]string); ok {
+			for _, it := range list {
+				n, err := formulaNumber(it)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, n)
+			}
+			continue
+		}
+		n, err := formulaNumber(a)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// formulaText converts a value to text; lists are joined with ", "
+func formulaText(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	case []string:
+		return strings.Join(t, ", ")
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// formulaList converts a value to a list; text becomes a one-item list
+func formulaList(v interface{}) []string {
+	if list, ok := v.([]string); ok {
+		return list
+	}
+	if isEmptyValue(v) {
+		return []string{}
+	}
+	return []string{formulaText(v)}
+}
+
+func formulaTruthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case float64:
+		return t != 0
+	case string:
+		return t != ""
+	case []string:
+		return len(t) > 0
+	}
+	return true
+}
+
+// formulaCompare compares numbers numerically and everything else as text
+func formulaCompare(l, r interface{}) int {
+	_, lt := l.(string)
+	_, rt := r.(string)
+	if !lt && !rt {
+		a, errA := formulaNumber(l)
+		b, errB := formulaNumber(r)
+		if errA == nil && errB == nil {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(formulaText(l), formulaText(r))
+}
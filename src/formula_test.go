@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// formulaTestRow is the row the formulas of TestFormulaEval are computed for
+var formulaTestRow = map[string]interface{}{
+	"A": 7,
+	"B": 2.5,
+	"S": "10",
+	"T": "abc",
+	"L": []interface{}{"x", "y"},
+}
+
+// formulaTestSchema returns the fields of formulaTestRow, an empty "E" and the
+// formula fields of formulas (F1, F2, ...)
+func formulaTestSchema(formulas ...string) []FieldDef {
+	schema := []FieldDef{
+		{Name: "A", Type: "int"},
+		{Name: "B", Type: "float"},
+		{Name: "S", Type: "string"},
+		{Name: "T", Type: "string"},
+		{Name: "L", Type: "[]string"},
+		{Name: "E", Type: "string"},
+	}
+	for i, src := range formulas {
+		schema = append(schema, FieldDef{Name: fmt.Sprintf("F%d", i+1), Type: "formula", Formula: src})
+	}
+	return schema
+}
+
+func TestFormulaEval(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    interface{}
+		err     string // part of the expected error, "" for none
+	}{
+		// precedence and associativity
+		{"1 + 2 * 3", 7.0, ""},
+		{"(1 + 2) * 3", 9.0, ""},
+		{"10 - 4 - 3", 3.0, ""},
+		{"12 / 3 / 2", 2.0, ""},
+		{"-2 * 3 + 1", -5.0, ""},
+		{"2 * 3 % 4", 2.0, ""},
+		{"A * B - 1", 16.5, ""},
+		{"1 + 2 = 3 and not false", true, ""},
+		{"2 > 1 or 1 > 2 and 3 > 4", true, ""}, // and binds tighter than or
+		{"(1 > 2 or 2 > 1) and 3 < 4", true, ""},
+		{"1 < 2 or 1 / 0 > 0", true, ""}, // short-circuit skips the division
+
+		// text and number coercion
+		{"S + 1", "101", ""}, // + joins when a side is text
+		{"S * 2", 20.0, ""},
+		{"S - B", 7.5, ""},
+		{"A & B", "72.5", ""},
+		{"number(S) > 9", true, ""},
+		{"S > 9", false, ""}, // text compares as text: "10" < "9"
+		{`S = "10"`, true, ""},
+		{"E + 1", 1.0, ""}, // empty values count as 0
+		{"true + 1", 2.0, ""},
+		{"len(L)", 2.0, ""},
+		{"T * 2", nil, `"abc" is not a number`},
+		{"L * 2", nil, "a list is not a number"},
+
+		// division by zero
+		{"A / 0", nil, "division by zero"},
+		{"A % (B - 2.5)", nil, "division by zero"},
+		{"1 / E", nil, "division by zero"},
+	}
+	for _, tt := range tests {
+		schema := formulaTestSchema(tt.formula)
+		if err := compileFormulas(schema); err != nil {
+			t.Errorf("%s: compile: %v", tt.formula, err)
+			continue
+		}
+		got, err := evalFormulaField(schema[len(schema)-1], formulaTestRow)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got %v, %v; want error %q", tt.formula, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %#v, %v; want %#v", tt.formula, got, err, tt.want)
+		}
+	}
+}
+
+func TestCompileFormulas(t *testing.T) {
+	tests := []struct {
+		formulas []string
+		err      string // part of the expected error, "" for none
+	}{
+		{[]string{"A + 1", "F1 * 2"}, ""},
+		{[]string{"F2 * 2", "A + 1"}, ""}, // order in the schema doesn't matter
+		{[]string{"[A] + [B]"}, ""},
+		{[]string{"A + Nope"}, `unknown field "Nope"`},
+		{[]string{"if(A > 1, [No Such], 0)"}, `unknown field "No Such"`},
+		{[]string{"F1 + 1"}, "circular reference"},
+		{[]string{"F2 + 1", "F1 + 1"}, "circular reference"},
+		{[]string{"F2", "F3", "F1 & A"}, "circular reference"},
+		{[]string{"1 +"}, "unexpected end of formula"},
+		{[]string{"nope(1)"}, "unknown function"},
+		{[]string{""}, "empty formula"},
+	}
+	for _, tt := range tests {
+		err := compileFormulas(formulaTestSchema(tt.formulas...))
+		if tt.err == "" && err != nil {
+			t.Errorf("%q: %v", tt.formulas, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%q: error %v, want %q", tt.formulas, err, tt.err)
+		}
+	}
+}
+
+func TestComputeFormulasInOrder(t *testing.T) {
+	schema := formulaTestSchema("F2 * 2", "A + 1", "1 / 0")
+	if err := compileFormulas(schema); err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{"A": 7}
+	computeFormulas(schema, data)
+	want := map[string]interface{}{"F1": 16.0, "F2": 8.0, "F3": nil}
+	for k, v := range want {
+		if got, ok := data[k]; !ok || got != v {
+			t.Errorf("%s = %#v, want %#v", k, got, v)
+		}
+	}
+}
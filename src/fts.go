@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Full-text search uses the SQLite FTS5 extension when the driver was built with it
+// (go build -tags sqlite_fts5); otherwise search falls back to substring matching
+// (see search.go). entries_fts holds one row per non-empty field of every entry with
+// rowid = entry id * ftsRowsPerEntry + field position, so the rows of one entry are a
+// rowid range. Triggers on entries keep it in sync with every write.
+
+// ftsRowsPerEntry is the rowid range reserved for the fields of one entry
+const ftsRowsPerEntry = 65536
+
+// ftsEnabled is set by ensureFTS when the driver supports FTS5
+var ftsEnabled bool
+
+// ftsTriggerNames are the triggers keeping entries_fts in sync with entries
+var ftsTriggerNames = []string{"entries_fts_insert", "entries_fts_delete", "entries_fts_update"}
+
+// ftsFieldRows returns the SELECT of the (rowid, field, text) index rows of the
+// entries named row, read from from; lists are indexed as their items separated by spaces
+func ftsFieldRows(row, from string) string {
+	return fmt.Sprintf(`SELECT %[1]s.id * %[3]d + row_number() OVER (PARTITION BY %[1]s.id), j.key,
+		CASE j.type WHEN 'array' THEN (SELECT group_concat(value, ' ') FROM json_each(j.value)) ELSE CAST(j.value AS TEXT) END
+	FROM %[2]sjson_each(CASE WHEN json_valid(%[1]s.data) THEN %[1]s.data ELSE '{}' END) j
+	WHERE j.type NOT IN ('null', 'true', 'false', 'object')`, row, from, ftsRowsPerEntry)
+}
+
+// ftsDeleteRows is the DELETE of the index rows of the entry named row
+func ftsDeleteRows(row string) string {
+	return fmt.Sprintf("DELETE FROM entries_fts WHERE rowid BETWEEN %[1]s.id * %[2]d AND %[1]s.id * %[2]d + %[3]d", row, ftsRowsPerEntry, ftsRowsPerEntry-1)
+}
+
+// ensureFTS creates the full-text index of entries if the driver supports FTS5.
+// The index is rebuilt when its triggers are missing: on the first open of an
+// existing database, and after a build without FTS5 dropped them.
+func ensureFTS(db *sql.DB) error {
+	var supported bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&supported); err != nil {
+		return err
+	}
+	ftsEnabled = supported
+	if !supported {
+		log.Println("warning: the SQLite driver was built without FTS5 (go build -tags sqlite_fts5), search falls back to substring matching")
+		// writes from this build would fail in the triggers of an FTS5 build
+		for _, name := range ftsTriggerNames {
+			if _, err := db.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN (?, ?, ?)",
+		ftsTriggerNames[0], ftsTriggerNames[1], ftsTriggerNames[2]).Scan(&n); err != nil {
+		return err
+	}
+	if n == len(ftsTriggerNames) {
+		return nil
+	}
+
+	log.Println("building the full-text index")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, q := range []string{
+		"CREATE VIRTUAL TABLE IF NOT EXISTS entries_fts USING fts5(field UNINDEXED, text, tokenize = 'unicode61 remove_diacritics 2')",
+		"DELETE FROM entries_fts",
+		"INSERT INTO entries_fts (rowid, field, text) " + ftsFieldRows("e", "entries e, "),
+		"DROP TRIGGER IF EXISTS " + ftsTriggerNames[0],
+		"DROP TRIGGER IF EXISTS " + ftsTriggerNames[1],
+		"DROP TRIGGER IF EXISTS " + ftsTriggerNames[2],
+		"CREATE TRIGGER " + ftsTriggerNames[0] + " AFTER INSERT ON entries BEGIN " +
+			"INSERT INTO entries_fts (rowid, field, text) " + ftsFieldRows("NEW", "") + "; END",
+		"CREATE TRIGGER " + ftsTriggerNames[1] + " AFTER DELETE ON entries BEGIN " +
+			ftsDeleteRows("OLD") + "; END",
+		"CREATE TRIGGER " + ftsTriggerNames[2] + " AFTER UPDATE OF data ON entries BEGIN " +
+			ftsDeleteRows("OLD") + "; INSERT INTO entries_fts (rowid, field, text) " + ftsFieldRows("NEW", "") + "; END",
+	} {
+		if _, err := tx.Exec(q); err != nil {
+			return fmt.Errorf("full-text index: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ftsMatchRows is the SQL condition selecting the entries with an index row
+// matching an FTS5 query (bound first), optionally limited to the fields bound next
+func ftsMatchRows(fields int) string {
+	q := fmt.Sprintf("entries.id IN (SELECT rowid / %d FROM entries_fts WHERE entries_fts MATCH ?", ftsRowsPerEntry)
+	if fields > 0 {
+		q += " AND field IN (?" + strings.Repeat(", ?", fields-1) + ")"
+	}
+	return q + ")"
+}
+
+// ftsRank is the SQL expression ranking an entry by the best bm25 score of its
+// index rows for the FTS5 query bound to it; entries without a match rank last
+func ftsRank() string {
+	return fmt.Sprintf("IFNULL((SELECT MIN(rank) FROM entries_fts WHERE entries_fts MATCH ? AND rowid BETWEEN entries.id * %[1]d AND entries.id * %[1]d + %[2]d), 0)",
+		ftsRowsPerEntry, ftsRowsPerEntry-1)
+}
@@ -0,0 +1,1178 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// column resizing constraints and sizing constants (use float32 for fyne sizes)
+const (
+	minColWidth      float32 = 40.0
+	singleLineHeight float32 = 30.0
+	listItemHeight   float32 = 20.0
+	listMaxVisible           = 3
+)
+
+var (
+	headerColor   = color.NRGBA{R: 240, G: 240, B: 240, A: 20}
+	evenRowColor  = color.NRGBA{R: 250, G: 250, B: 250, A: 20}
+	oddRowColor   = color.NRGBA{R: 245, G: 245, B: 255, A: 40}
+	linkOKColor   = color.NRGBA{R: 0, G: 0, B: 200, A: 255}
+	linkMissColor = color.NRGBA{R: 200, G: 0, B: 0, A: 255}
+	invalidColor  = color.NRGBA{R: 220, G: 40, B: 40, A: 255}
+	matchColor    = color.NRGBA{R: 255, G: 220, B: 0, A: 70}
+	currentColor  = color.NRGBA{R: 255, G: 140, B: 0, A: 140}
+	selectColor   = color.NRGBA{R: 60, G: 120, B: 220, A: 60}
+)
+
+// dataGrid shows the entries table in a virtualized widget.Table.
+// Only the visible cells are instantiated; the table recycles them while scrolling,
+// so the number of widgets does not grow with the number of rows.
+type dataGrid struct {
+	win    fyne.Window
+	db     *sql.DB
+	sheet  int
+	schema []FieldDef
+
+	// column widths indexed like schema, the last entry is the actions column;
+	// they are stored with the sheet when a resize drag ends
+	colWidths []float32
+
+	// effective schema for the current view and the original schema index of each column
+	effective   []FieldDef
+	origIndexes []int
+
+	// view currently shown (ID 0 => All) and its rows, already merged with schema defaults
+	view View
+	rows []Row
+
+	// validation problems of the loaded rows: row id -> field name -> message
+	problems map[int]map[string]string
+
+	// target sheet and labels of the referenced rows of each relation field, by field name
+	relTargets map[string]int
+	relLabels  map[string]map[int]string
+
+	// cells matching view.Search in row order, and the index of the current match
+	matches    []widget.TableCellID
+	matchSet   map[widget.TableCellID]bool
+	matchIndex int
+
+	// cell cursor (see cursor.go): the current cell and the other corner of the
+	// selected range, whether one of its editors has the focus and the value the
+	// cell had when editing started; cellAt finds the recycled cell showing a cell id
+	cursor     widget.TableCellID
+	anchor     widget.TableCellID
+	editing    bool
+	editBefore interface{}
+	cellAt     map[widget.TableCellID]*gridCell
+	keys       *gridKeys
+
+	// ids of the rows checked in the actions column for bulk actions, the select-all
+	// check of the actions header, and a callback run when the checked rows change
+	checked   map[int]bool
+	allCheck  *widget.Check
+	onChecked func()
+
+	// edits not written yet (see edits.go): row id -> field name -> value, the
+	// timer writing them, the error of the last failed write and the bar showing it
+	pending    map[int]map[string]interface{}
+	flushTimer *time.Timer
+	saveErr    error
+	saveBar    fyne.CanvasObject
+	saveLabel  *widget.Label
+
+	// all rows share one height so the table can stay on its constant-time scroll path
+	rowHeight float32
+
+	// directory that link paths are relative to
+	exeDir string
+
+	table   *widget.Table
+	content fyne.CanvasObject // the table above the keyboard handler, and the save bar
+}
+
+// newDataGrid creates the grid widget of a sheet; call populateTableGrid to load rows.
+func newDataGrid(win fyne.Window, db *sql.DB, sheet int, schema []FieldDef, colWidths []float32) *dataGrid {
+	g := &dataGrid{
+		win:       win,
+		db:        db,
+		sheet:     sheet,
+		schema:    schema,
+		colWidths: colWidths,
+		rowHeight: singleLineHeight,
+		cellAt:    map[widget.TableCellID]*gridCell{},
+		checked:   map[int]bool{},
+		pending:   map[int]map[string]interface{}{},
+	}
+	if p, err := os.Executable(); err == nil {
+		g.exeDir = filepath.Dir(p)
+	}
+
+	g.table = widget.NewTable(g.length, g.createCell, g.updateCell)
+	g.table.ShowHeaderRow = true
+	g.table.CreateHeader = g.createHeader
+	g.table.UpdateHeader = g.updateHeader
+	g.keys = newGridKeys(g)
+	g.content = container.NewBorder(nil, g.newSaveBar(), nil, nil, container.NewStack(g.keys, g.table))
+
+	// the canvas gets these while the cursor has the focus
+	win.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyHome, Modifier: fyne.KeyModifierShortcutDefault}, func(fyne.Shortcut) {
+		g.setCursor(widget.TableCellID{})
+	})
+	win.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyEnd, Modifier: fyne.KeyModifierShortcutDefault}, func(fyne.Shortcut) {
+		g.setCursor(widget.TableCellID{Row: len(g.rows) - 1, Col: len(g.effective) - 1})
+	})
+	win.Canvas().AddShortcut(&fyne.ShortcutCopy{}, func(fyne.Shortcut) { g.copySelection() })
+	win.Canvas().AddShortcut(&fyne.ShortcutPaste{}, func(fyne.Shortcut) { g.pasteSelection() })
+	win.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyD, Modifier: fyne.KeyModifierShortcutDefault}, func(fyne.Shortcut) {
+		g.fillSelection(true)
+	})
+	win.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyR, Modifier: fyne.KeyModifierShortcutDefault}, func(fyne.Shortcut) {
+		g.fillSelection(false)
+	})
+	return g
+}
+
+// populateTableGrid reloads the rows of view v from the database and refreshes the visible cells.
+// v.Columns empty => show all columns; otherwise restrict to those names (in order of schema)
+func (g *dataGrid) populateTableGrid(v View) {
+	g.flush()
+	g.view = v
+	rows, err := getViewRows(g.db, g.sheet, g.schema, v)
+	if err != nil {
+		log.Println("Error loading data:", err)
+		dialog.ShowError(fmt.Errorf("view %q: %w", v.Name, err), g.win)
+		rows = nil
+	}
+	for i := range rows {
+		rows[i].Data = mergeWithSchema(g.schema, rows[i].Data)
+	}
+	g.rows = rows
+	g.applyPending()
+	g.keepChecked()
+	g.validateRows()
+	g.loadRelationLabels()
+
+	// build a set for visible columns if provided
+	showAll := len(v.Columns) == 0
+	visSet := map[string]bool{}
+	for _, n := range v.Columns {
+		visSet[n] = true
+	}
+
+	// build effective schema in order and remember original indexes so widths stay per field
+	g.effective = nil
+	g.origIndexes = nil
+	for i, f := range g.schema {
+		if showAll || visSet[f.Name] {
+			g.effective = append(g.effective, f)
+			g.origIndexes = append(g.origIndexes, i)
+		}
+	}
+
+	// lists show up to listMaxVisible items before scrolling, everything else is one line
+	g.rowHeight = singleLineHeight
+	for _, f := range g.effective {
+		if f.Type == "[]string" {
+			g.rowHeight = float32(listMaxVisible)*listItemHeight + 6 // padding
+			break
+		}
+	}
+
+	for ci := 0; ci <= len(g.effective); ci++ {
+		g.table.SetColumnWidth(ci, g.colWidths[g.widthIndex(ci)])
+	}
+	g.findMatches()
+
+	// keep the cursor on the sheet when rows or columns went away
+	g.editing = false
+	g.cursor.Row = max(0, min(g.cursor.Row, len(g.rows)-1))
+	g.cursor.Col = max(0, min(g.cursor.Col, len(g.effective)-1))
+	g.anchor.Row = max(0, min(g.anchor.Row, len(g.rows)-1))
+	g.anchor.Col = max(0, min(g.anchor.Col, len(g.effective)-1))
+	g.table.Refresh()
+}
+
+// findMatches collects the cells of the loaded rows that contain the search text.
+// The rows were narrowed in SQL already; this only decides which cells to highlight.
+func (g *dataGrid) findMatches() {
+	g.matches = nil
+	g.matchSet = map[widget.TableCellID]bool{}
+	g.matchIndex = -1
+	terms := parseSearch(g.schema, g.view.Search)
+	if len(terms) == 0 {
+		return
+	}
+	for ri, r := range g.rows {
+		for ci, f := range g.effective {
+			if cellMatches(f, r, g.relLabels[f.Name], terms) {
+				id := widget.TableCellID{Row: ri, Col: ci}
+				g.matches = append(g.matches, id)
+				g.matchSet[id] = true
+			}
+		}
+	}
+}
+
+// moveMatch makes the next (delta 1) or previous (delta -1) match current, cycling
+// at both ends, and scrolls to it. It returns the position text for the toolbar.
+func (g *dataGrid) moveMatch(delta int) string {
+	if len(g.matches) == 0 {
+		return g.matchStatus()
+	}
+	prev := g.matchIndex
+	if prev < 0 && delta < 0 {
+		g.matchIndex = len(g.matches) - 1
+	} else {
+		g.matchIndex = ((prev+delta)%len(g.matches) + len(g.matches)) % len(g.matches)
+	}
+	if prev >= 0 {
+		g.table.RefreshItem(g.matches[prev])
+	}
+	cur := g.matches[g.matchIndex]
+	g.table.ScrollTo(cur)
+	g.table.RefreshItem(cur)
+	return g.matchStatus()
+}
+
+// matchStatus describes the current match and the number of matches
+func (g *dataGrid) matchStatus() string {
+	if strings.TrimSpace(g.view.Search) == "" {
+		return ""
+	}
+	if len(g.matches) == 0 {
+		return "No matches"
+	}
+	return fmt.Sprintf("%d/%d", g.matchIndex+1, len(g.matches))
+}
+
+// widthIndex maps a table column to its entry in colWidths.
+func (g *dataGrid) widthIndex(col int) int {
+	if col < len(g.origIndexes) && g.origIndexes[col] < len(g.colWidths)-1 {
+		return g.origIndexes[col]
+	}
+	return len(g.colWidths) - 1
+}
+
+// resizeColumn changes a column width by dx, clamped to minColWidth.
+// Only the table layout is refreshed, no cells are rebuilt.
+func (g *dataGrid) resizeColumn(col int, dx float32) {
+	widx := g.widthIndex(col)
+	newW := float32(math.Max(float64(minColWidth), float64(g.colWidths[widx]+dx)))
+	if newW != g.colWidths[widx] {
+		g.colWidths[widx] = newW
+		g.table.SetColumnWidth(col, newW)
+	}
+}
+
+// saveWidths stores the column widths with the sheet, by field name
+func (g *dataGrid) saveWidths() {
+	widths := map[string]float32{"": g.colWidths[len(g.colWidths)-1]}
+	for i, f := range g.schema {
+		widths[f.Name] = g.colWidths[i]
+	}
+	if err := saveSheetWidths(g.db, g.sheet, widths); err != nil {
+		log.Printf("warning: failed to save column widths: %v", err)
+	}
+}
+
+// loadRelationLabels looks up the display labels of the rows the loaded rows refer to.
+func (g *dataGrid) loadRelationLabels() {
+	g.relTargets = map[string]int{}
+	g.relLabels = map[string]map[int]string{}
+	for _, f := range g.schema {
+		if f.Type != "relation" {
+			continue
+		}
+		g.relLabels[f.Name] = map[int]string{}
+		target, err := relationTarget(g.db, g.sheet, f)
+		if err != nil {
+			log.Printf("warning: %v", err)
+			continue
+		}
+		g.relTargets[f.Name] = target
+		seen := map[int]bool{}
+		var ids []int
+		for _, r := range g.rows {
+			for _, id := range relationIDs(r.Data[f.Name]) {
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+		}
+		labels, err := relationLabels(g.db, target, f, ids)
+		if err != nil {
+			log.Printf("warning: failed to load labels of %s: %v", f.Name, err)
+			continue
+		}
+		g.relLabels[f.Name] = labels
+	}
+}
+
+// updateLookups recomputes the lookup and rollup fields of the loaded rows after an
+// edit (rows of the same sheet may refer to each other) and refreshes changed cells.
+func (g *dataGrid) updateLookups() {
+	var fields []string
+	for _, f := range g.schema {
+		if f.Type == "lookup" || f.Type == "rollup" {
+			fields = append(fields, f.Name)
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+	old := make([]string, len(g.rows))
+	for i, r := range g.rows {
+		for _, name := range fields {
+			old[i] += fmt.Sprint(r.Data[name]) + "\x00"
+		}
+	}
+	if err := fillLookups(g.db, g.sheet, g.schema, g.rows); err != nil {
+		log.Printf("warning: failed to update lookups: %v", err)
+		return
+	}
+	for i, r := range g.rows {
+		now := ""
+		for _, name := range fields {
+			now += fmt.Sprint(r.Data[name]) + "\x00"
+		}
+		if now != old[i] {
+			for _, name := range fields {
+				g.refreshField(i, name)
+			}
+		}
+	}
+}
+
+// updateFormulas recomputes the formula fields of a cached row (the database copy was
+// written by setField) and refreshes the formula cells whose value or error changed.
+func (g *dataGrid) updateFormulas(rowIdx int) {
+	r := g.rows[rowIdx]
+	for _, f := range formulaFields(g.schema) {
+		old := fmt.Sprint(r.Data[f.Name]) + "\x00" + g.problem(r.ID, f.Name)
+		v, err := evalFormulaField(f, r.Data)
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		}
+		r.Data[f.Name] = v
+		g.setProblemText(r.ID, f.Name, msg)
+		if fmt.Sprint(v)+"\x00"+msg != old {
+			g.refreshField(rowIdx, f.Name)
+		}
+	}
+}
+
+// validateRows recomputes the validation problems of all loaded rows.
+func (g *dataGrid) validateRows() {
+	g.problems = map[int]map[string]string{}
+	formulas := formulaFields(g.schema)
+	for _, r := range g.rows {
+		if probs := validateRow(g.schema, r.Data); probs != nil {
+			g.problems[r.ID] = probs
+		}
+		for _, f := range formulas {
+			if _, err := evalFormulaField(f, r.Data); err != nil {
+				g.setProblemText(r.ID, f.Name, err.Error())
+			}
+		}
+	}
+	dups, err := uniqueViolations(g.db, g.sheet, g.schema)
+	if err != nil {
+		log.Printf("warning: unique check failed: %v", err)
+		return
+	}
+	for field, ids := range dups {
+		for id := range ids {
+			g.setProblemText(id, field, "value is not unique")
+		}
+	}
+}
+
+// revalidate re-checks one field of a row after an edit and refreshes the cells
+// whose problem changed; for Unique fields other rows may change too.
+func (g *dataGrid) revalidate(rowIdx int, field string) {
+	r := g.rows[rowIdx]
+	var f FieldDef
+	for _, sf := range g.schema {
+		if sf.Name == field {
+			f = sf
+		}
+	}
+	if f.Name == "" {
+		return
+	}
+
+	if f.Unique {
+		before := fmt.Sprint(g.problems)
+		g.validateRows()
+		if fmt.Sprint(g.problems) != before {
+			g.table.Refresh()
+		}
+		return
+	}
+
+	old := g.problem(r.ID, field)
+	msg := ""
+	if err := validateFieldValue(f, r.Data[field]); err != nil {
+		msg = err.Error()
+	}
+	g.setProblemText(r.ID, field, msg)
+	if msg != old {
+		g.refreshField(rowIdx, field)
+	}
+}
+
+// problem returns the validation message of a cell, "" when it is valid.
+func (g *dataGrid) problem(id int, field string) string {
+	return g.problems[id][field]
+}
+
+// setProblemText records (or with msg "" clears) the problem of a cell.
+func (g *dataGrid) setProblemText(id int, field, msg string) {
+	if msg == "" {
+		if probs := g.problems[id]; probs != nil {
+			delete(probs, field)
+			if len(probs) == 0 {
+				delete(g.problems, id)
+			}
+		}
+		return
+	}
+	if g.problems[id] == nil {
+		g.problems[id] = map[string]string{}
+	}
+	g.problems[id][field] = msg
+}
+
+// refreshField rebinds the cell showing field of the row at rowIdx, if the view shows it.
+func (g *dataGrid) refreshField(rowIdx int, field string) {
+	for ci, f := range g.effective {
+		if f.Name == field {
+			g.table.RefreshItem(widget.TableCellID{Row: rowIdx, Col: ci})
+			return
+		}
+	}
+}
+
+func (g *dataGrid) length() (int, int) {
+	return len(g.rows), len(g.effective) + 1 // +1 for actions column
+}
+
+func (g *dataGrid) createHeader() fyne.CanvasObject {
+	return newHeaderCell(g)
+}
+
+func (g *dataGrid) updateHeader(id widget.TableCellID, o fyne.CanvasObject) {
+	h := o.(*headerCell)
+	h.col = id.Col
+	if id.Col < len(g.effective) {
+		h.label.SetText(g.effective[id.Col].Label)
+		h.resizer.Show()
+		h.all.Hide()
+		if g.allCheck == h.all {
+			g.allCheck = nil
+		}
+	} else {
+		h.label.SetText("Actions")
+		h.resizer.Hide()
+		h.all.Show()
+		g.allCheck = h.all
+		g.updateAllCheck()
+	}
+}
+
+func (g *dataGrid) createCell() fyne.CanvasObject {
+	return newGridCell(g)
+}
+
+func (g *dataGrid) updateCell(id widget.TableCellID, o fyne.CanvasObject) {
+	c := o.(*gridCell)
+	if id.Row >= len(g.rows) {
+		return
+	}
+	if g.cellAt[c.id] == c {
+		delete(g.cellAt, c.id)
+	}
+	c.id = id
+	g.cellAt[id] = c
+	c.showCursor(id == g.cursor && id.Col < len(g.effective))
+	c.showSelected(g.inRange(id))
+
+	// alternating row color
+	if id.Row%2 == 0 {
+		c.bg.FillColor = evenRowColor
+	} else {
+		c.bg.FillColor = oddRowColor
+	}
+	c.bg.Refresh()
+
+	r := g.rows[id.Row]
+	if id.Col >= len(g.effective) {
+		c.showProblem("")
+		c.showUnsaved(false)
+		c.showMatch(nil)
+		c.bindActions(id.Row, r)
+		return
+	}
+	f := g.effective[id.Col]
+	c.bind(id.Row, r, f)
+	c.showProblem(g.problem(r.ID, f.Name))
+	c.showUnsaved(g.isUnsaved(r.ID, f.Name))
+	switch {
+	case g.matchIndex >= 0 && g.matches[g.matchIndex] == id:
+		c.showMatch(currentColor)
+	case g.matchSet[id]:
+		c.showMatch(matchColor)
+	default:
+		c.showMatch(nil)
+	}
+}
+
+// keepChecked drops the checked rows that are no longer loaded, so bulk actions
+// only touch rows on screen
+func (g *dataGrid) keepChecked() {
+	loaded := map[int]bool{}
+	for _, r := range g.rows {
+		loaded[r.ID] = true
+	}
+	changed := false
+	for id := range g.checked {
+		if !loaded[id] {
+			delete(g.checked, id)
+			changed = true
+		}
+	}
+	if changed && g.onChecked != nil {
+		g.onChecked()
+	}
+}
+
+// setChecked checks or unchecks the row with id
+func (g *dataGrid) setChecked(id int, on bool) {
+	if on {
+		g.checked[id] = true
+	} else {
+		delete(g.checked, id)
+	}
+	g.updateAllCheck()
+	if g.onChecked != nil {
+		g.onChecked()
+	}
+}
+
+// checkAll checks every loaded row, or none
+func (g *dataGrid) checkAll(on bool) {
+	g.checked = map[int]bool{}
+	if on {
+		for _, r := range g.rows {
+			g.checked[r.ID] = true
+		}
+	}
+	g.table.Refresh()
+	if g.onChecked != nil {
+		g.onChecked()
+	}
+}
+
+// checkedIDs returns the ids of the checked rows in grid order
+func (g *dataGrid) checkedIDs() []int {
+	var ids []int
+	for _, r := range g.rows {
+		if g.checked[r.ID] {
+			ids = append(ids, r.ID)
+		}
+	}
+	return ids
+}
+
+// updateAllCheck shows whether every loaded row is checked on the select-all check
+func (g *dataGrid) updateAllCheck() {
+	if g.allCheck == nil {
+		return
+	}
+	all := len(g.rows) > 0 && len(g.checked) == len(g.rows)
+	g.allCheck.OnChanged = nil
+	g.allCheck.SetChecked(all)
+	g.allCheck.OnChanged = g.checkAll
+}
+
+// linkColor returns blue for existing link targets and red for missing ones.
+func (g *dataGrid) linkColor(path string) color.Color {
+	if path != "" {
+		if _, err := os.Stat(filepath.Join(g.exeDir, path)); err != nil {
+			return linkMissColor
+		}
+	}
+	return linkOKColor
+}
+
+// headerCell is the recycled header template: label plus a column resizer.
+type headerCell struct {
+	widget.BaseWidget
+	col     int
+	label   *widget.Label
+	all     *widget.Check // select-all of the actions column
+	resizer *colResizer
+	content fyne.CanvasObject
+}
+
+func newHeaderCell(g *dataGrid) *headerCell {
+	h := &headerCell{label: widget.NewLabel("")}
+	h.label.Alignment = fyne.TextAlignLeading
+	h.resizer = newColResizer(func(dx float32) {
+		g.resizeColumn(h.col, dx)
+	}, g.saveWidths)
+	h.all = widget.NewCheck("", nil)
+	h.all.Hide()
+	h.content = container.NewBorder(nil, nil, nil, h.resizer,
+		container.NewStack(canvas.NewRectangle(headerColor), container.NewBorder(nil, nil, h.all, nil, h.label)))
+	h.ExtendBaseWidget(h)
+	return h
+}
+
+func (h *headerCell) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(h.content)
+}
+
+// gridCell is the recycled data cell template. It lazily creates one editor per
+// field type and swaps the visible editor when the table binds it to another column.
+type gridCell struct {
+	widget.BaseWidget
+	g  *dataGrid
+	id widget.TableCellID // cell the template is bound to
+
+	bg      *canvas.Rectangle
+	content *fyne.Container
+
+	// search highlight behind the editor
+	match *canvas.Rectangle
+
+	// red outline and warning marker of an invalid value
+	outline *canvas.Rectangle
+	marker  *problemMarker
+
+	// orange outline of a value that could not be written (see edits.go)
+	unsavedBox *canvas.Rectangle
+
+	// outline of the cell cursor and the shade of a selected range
+	cursorBox *canvas.Rectangle
+	selBox    *canvas.Rectangle
+
+	idLabel      *widget.Label
+	formulaLabel *widget.Label
+	intEntry     *cellEntry
+	floatEntry   *cellEntry
+	textEntry    *cellEntry
+	check        *widget.Check
+	dateEntry    *widget.DateEntry
+	enumSelect   *widget.Select
+
+	// datetime editor: date picker plus an HH:MM entry
+	dtDate *widget.DateEntry
+	dtTime *widget.Entry
+	dtCell fyne.CanvasObject
+
+	rowCheck   *widget.Check
+	trash      *widget.Button
+	historyBtn *widget.Button
+	formBtn    *widget.Button
+	actions    fyne.CanvasObject
+
+	// link editor: label (right click opens) swapped for an entry on left click
+	linkText    *canvas.Text
+	linkBox     fyne.CanvasObject
+	linkSwap    *fyne.Container
+	linkEntry   *cellEntry
+	linkOverlay *clickableOverlay
+	linkCell    fyne.CanvasObject
+
+	// relation editor: labels of the referenced rows, a click opens the picker
+	relationLabel   *widget.Label
+	relationOverlay *clickableOverlay
+	relationCell    fyne.CanvasObject
+
+	// list editor is rebuilt only when bound to another row/field or its data changed elsewhere
+	list    fyne.CanvasObject
+	listKey string
+}
+
+func newGridCell(g *dataGrid) *gridCell {
+	c := &gridCell{
+		g:       g,
+		bg:      canvas.NewRectangle(evenRowColor),
+		match:   canvas.NewRectangle(color.Transparent),
+		content: container.NewStack(),
+		outline: canvas.NewRectangle(color.Transparent),
+		marker:  newProblemMarker(g.win),
+
+		cursorBox: canvas.NewRectangle(color.Transparent),
+		selBox:    canvas.NewRectangle(selectColor),
+
+		unsavedBox: canvas.NewRectangle(color.Transparent),
+	}
+	c.outline.StrokeColor = invalidColor
+	c.outline.StrokeWidth = 2
+	c.outline.Hide()
+	c.unsavedBox.StrokeColor = unsavedColor
+	c.unsavedBox.StrokeWidth = 2
+	c.unsavedBox.Hide()
+	c.cursorBox.StrokeColor = theme.Color(theme.ColorNamePrimary)
+	c.cursorBox.StrokeWidth = 2
+	c.cursorBox.Hide()
+	c.selBox.Hide()
+	c.marker.Hide()
+	c.ExtendBaseWidget(c)
+	return c
+}
+
+func (c *gridCell) CreateRenderer() fyne.WidgetRenderer {
+	// the marker sits in the top right corner above the editor
+	corner := container.NewBorder(container.NewHBox(layout.NewSpacer(), c.marker), nil, nil, nil)
+	return widget.NewSimpleRenderer(container.NewStack(c.bg, c.match, c.selBox, c.content, c.outline, c.unsavedBox, c.cursorBox, corner))
+}
+
+// showMatch highlights the cell as a search match in col; nil removes the highlight.
+func (c *gridCell) showMatch(col color.Color) {
+	if col == nil {
+		col = color.Transparent
+	}
+	if c.match.FillColor != col {
+		c.match.FillColor = col
+		c.match.Refresh()
+	}
+}
+
+// showProblem outlines the cell and shows msg on its marker; "" clears both.
+func (c *gridCell) showProblem(msg string) {
+	c.marker.msg = msg
+	if msg == "" {
+		c.outline.Hide()
+		c.marker.Hide()
+		return
+	}
+	c.outline.Show()
+	c.marker.Show()
+}
+
+// MinSize is used by the table as the template size, so it carries the shared row height.
+func (c *gridCell) MinSize() fyne.Size {
+	return fyne.NewSize(minColWidth, c.g.rowHeight)
+}
+
+// show makes o the only visible editor of the cell.
+func (c *gridCell) show(o fyne.CanvasObject) {
+	if len(c.content.Objects) == 1 && c.content.Objects[0] == o {
+		return
+	}
+	c.content.Objects = []fyne.CanvasObject{o}
+	c.content.Refresh()
+}
+
+// setEntryText updates an entry without firing its OnChanged callback.
+func setEntryText(e *widget.Entry, text string) {
+	e.OnChanged = nil
+	if e.Text != text {
+		e.SetText(text)
+	}
+}
+
+// bind shows the editor for field f of row r (at index rowIdx in the grid).
+func (c *gridCell) bind(rowIdx int, r Row, f FieldDef) {
+	g := c.g
+	id := r.ID
+	fieldName := f.Name
+
+	switch f.Type {
+	case "int":
+		if strings.EqualFold(f.Name, "ID") {
+			if c.idLabel == nil {
+				c.idLabel = widget.NewLabel("")
+			}
+			c.idLabel.SetText(fmt.Sprintf("%v", r.ID))
+			c.show(c.idLabel)
+			return
+		}
+		if c.intEntry == nil {
+			c.intEntry = c.newEditor(false)
+		}
+		setEntryText(&c.intEntry.Entry, intText(r.Data[f.Name]))
+		c.intEntry.OnChanged = func(s string) {
+			v, err := parseFieldValue(f, s, "")
+			if err != nil {
+				// keep the stored value but flag the text that could not be saved
+				g.setProblemText(id, fieldName, err.Error())
+				c.showProblem(err.Error())
+				return
+			}
+			g.setField(rowIdx, id, fieldName, v)
+			c.showProblem(g.problem(id, fieldName))
+		}
+		c.show(c.intEntry)
+	case "float":
+		if c.floatEntry == nil {
+			c.floatEntry = c.newEditor(false)
+		}
+		setEntryText(&c.floatEntry.Entry, floatText(r.Data[f.Name]))
+		c.floatEntry.OnChanged = func(s string) {
+			v, err := parseFieldValue(f, s, "")
+			if err != nil {
+				g.setProblemText(id, fieldName, err.Error())
+				c.showProblem(err.Error())
+				return
+			}
+			g.setField(rowIdx, id, fieldName, v)
+			c.showProblem(g.problem(id, fieldName))
+		}
+		c.show(c.floatEntry)
+	case "bool":
+		if c.check == nil {
+			c.check = widget.NewCheck("", nil)
+		}
+		c.check.OnChanged = nil
+		b, _ := r.Data[f.Name].(bool)
+		c.check.SetChecked(b)
+		c.check.OnChanged = func(b bool) {
+			g.setField(rowIdx, id, fieldName, b)
+		}
+		c.show(c.check)
+	case "date":
+		if c.dateEntry == nil {
+			c.dateEntry = widget.NewDateEntry()
+		}
+		c.dateEntry.OnChanged = nil
+		c.dateEntry.SetDate(fieldTime(r.Data[f.Name]))
+		c.dateEntry.OnChanged = func(t *time.Time) {
+			val := ""
+			if t != nil {
+				val = t.Format(dateLayout)
+			}
+			g.setField(rowIdx, id, fieldName, val)
+		}
+		c.show(c.dateEntry)
+	case "datetime":
+		c.bindDateTime(rowIdx, r, f)
+	case "enum":
+		if c.enumSelect == nil {
+			c.enumSelect = widget.NewSelect(nil, nil)
+		}
+		c.enumSelect.OnChanged = nil
+		c.enumSelect.Options = f.Options
+		c.enumSelect.Selected, _ = r.Data[f.Name].(string)
+		c.enumSelect.Refresh()
+		c.enumSelect.OnChanged = func(s string) {
+			g.setField(rowIdx, id, fieldName, s)
+		}
+		c.show(c.enumSelect)
+	case "formula", "lookup", "rollup":
+		// computed values are read-only
+		if c.formulaLabel == nil {
+			c.formulaLabel = widget.NewLabel("")
+			c.formulaLabel.Truncation = fyne.TextTruncateEllipsis
+		}
+		c.formulaLabel.SetText(formatFieldValue(f, r.Data[f.Name], ", "))
+		c.show(c.formulaLabel)
+	case "link":
+		c.bindLink(rowIdx, r, f)
+	case "relation":
+		c.bindRelation(rowIdx, r, f)
+	case "[]string":
+		list := toStringList(r.Data[f.Name])
+		key := fmt.Sprintf("%d/%s/%q", id, fieldName, list)
+		if c.list == nil || c.listKey != key {
+			c.listKey = key
+			c.list = makeListEditorInline(g.win, list, func() *cellEntry { return c.newEditor(false) }, func(out []string) {
+				g.setField(rowIdx, id, fieldName, out)
+				c.listKey = fmt.Sprintf("%d/%s/%q", id, fieldName, out)
+			})
+		}
+		c.show(c.list)
+	default:
+		// string and unknown types use a multiline editor
+		if c.textEntry == nil {
+			c.textEntry = c.newEditor(true)
+		}
+		val := ""
+		if v, ok := r.Data[f.Name]; ok {
+			if s, ok := v.(string); ok {
+				val = s
+			} else {
+				val = fmt.Sprintf("%v", v)
+			}
+		}
+		setEntryText(&c.textEntry.Entry, val)
+		c.textEntry.OnChanged = func(s string) {
+			g.setField(rowIdx, id, fieldName, s)
+		}
+		c.show(c.textEntry)
+	}
+}
+
+// bindDateTime shows a date picker and a time entry; changing either stores both.
+func (c *gridCell) bindDateTime(rowIdx int, r Row, f FieldDef) {
+	g := c.g
+	if c.dtCell == nil {
+		c.dtDate = widget.NewDateEntry()
+		c.dtTime = widget.NewEntry()
+		c.dtTime.SetPlaceHolder("15:04")
+		c.dtCell = container.NewBorder(nil, nil, nil, c.dtTime, c.dtDate)
+	}
+
+	c.dtDate.OnChanged = nil
+	t := fieldTime(r.Data[f.Name])
+	c.dtDate.SetDate(t)
+	if t != nil {
+		setEntryText(c.dtTime, t.Format("15:04"))
+	} else {
+		setEntryText(c.dtTime, "")
+	}
+
+	id := r.ID
+	fieldName := f.Name
+	save := func() {
+		if c.dtDate.Date == nil {
+			g.setField(rowIdx, id, fieldName, "")
+			return
+		}
+		d := *c.dtDate.Date
+		if hm, err := time.Parse("15:04", strings.TrimSpace(c.dtTime.Text)); err == nil {
+			d = time.Date(d.Year(), d.Month(), d.Day(), hm.Hour(), hm.Minute(), 0, 0, time.Local)
+		}
+		g.setField(rowIdx, id, fieldName, d.Format(dateTimeLayout))
+	}
+	c.dtDate.OnChanged = func(*time.Time) { save() }
+	c.dtTime.OnChanged = func(s string) {
+		if _, err := time.Parse("15:04", strings.TrimSpace(s)); err == nil || s == "" {
+			save()
+		}
+	}
+	c.show(c.dtCell)
+}
+
+// fieldTime parses a stored date/datetime value, nil when empty or invalid
+func fieldTime(v interface{}) *time.Time {
+	s, _ := v.(string)
+	if s == "" {
+		return nil
+	}
+	t, err := parseTime(s)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// bindLink shows a link label; left click edits the path, right click opens the file.
+func (c *gridCell) bindLink(rowIdx int, r Row, f FieldDef) {
+	g := c.g
+	if c.linkCell == nil {
+		c.linkText = canvas.NewText("", linkOKColor)
+		// center label vertically within a vbox, then make it expand via Stack
+		c.linkBox = container.NewVBox(layout.NewSpacer(), container.NewHBox(c.linkText), layout.NewSpacer())
+		c.linkSwap = container.NewStack(c.linkBox) // will be swapped to entry when editing
+		c.linkEntry = c.newEditor(false)
+		c.linkOverlay = newClickableOverlay(nil, nil)
+		c.linkCell = container.NewStack(c.linkSwap, c.linkOverlay)
+	}
+
+	curText := ""
+	if v, ok := r.Data[f.Name]; ok {
+		if s, ok := v.(string); ok {
+			curText = s
+		} else {
+			curText = fmt.Sprintf("%v", v)
+		}
+	}
+	c.linkText.Text = curText
+	c.linkText.Color = g.linkColor(curText)
+	c.linkText.Refresh()
+
+	// a recycled cell always starts in label mode
+	c.linkSwap.Objects = []fyne.CanvasObject{c.linkBox}
+	c.linkSwap.Refresh()
+	c.linkOverlay.Show()
+
+	id := r.ID
+	fieldName := f.Name
+	c.linkOverlay.onLeftClick = func() {
+		setEntryText(&c.linkEntry.Entry, c.linkText.Text)
+		c.linkEntry.OnSubmitted = func(s string) {
+			g.setField(rowIdx, id, fieldName, s)
+			c.linkText.Text = s
+			c.linkText.Color = g.linkColor(s)
+			c.linkText.Refresh()
+			c.linkSwap.Objects = []fyne.CanvasObject{c.linkBox}
+			c.linkSwap.Refresh()
+			c.linkOverlay.Show()
+		}
+		c.linkEntry.OnChanged = func(s string) {
+			g.setField(rowIdx, id, fieldName, s)
+		}
+		c.linkOverlay.Hide()
+		// replace with an entry that will fill the cell
+		c.linkSwap.Objects = []fyne.CanvasObject{c.linkEntry}
+		c.linkSwap.Refresh()
+		// focus the entry so the user can type immediately
+		g.win.Canvas().Focus(c.linkEntry)
+	}
+	c.linkOverlay.onRightClick = func() {
+		if c.linkText.Text == "" {
+			dialog.ShowInformation("Open link", "No file specified", g.win)
+			return
+		}
+		target := filepath.Join(g.exeDir, c.linkText.Text)
+		if err := exec.Command("xdg-open", target).Start(); err != nil {
+			c.linkText.Color = linkMissColor
+			c.linkText.Refresh()
+			dialog.ShowError(fmt.Errorf("open failed: %w", err), g.win)
+		}
+	}
+	c.show(c.linkCell)
+}
+
+// bindRelation shows the labels of the referenced rows; a click opens the row picker.
+func (c *gridCell) bindRelation(rowIdx int, r Row, f FieldDef) {
+	g := c.g
+	if c.relationCell == nil {
+		c.relationLabel = widget.NewLabel("")
+		c.relationLabel.Truncation = fyne.TextTruncateEllipsis
+		c.relationOverlay = newClickableOverlay(nil, nil)
+		c.relationCell = container.NewStack(c.relationLabel, c.relationOverlay)
+	}
+	ids := relationIDs(r.Data[f.Name])
+	text := relationText(ids, g.relLabels[f.Name])
+	if text == "" {
+		text = "—"
+	}
+	c.relationLabel.SetText(text)
+
+	id := r.ID
+	c.relationOverlay.onLeftClick = func() {
+		g.setCursor(c.id)
+		target, ok := g.relTargets[f.Name]
+		if !ok {
+			dialog.ShowError(fmt.Errorf("field %s: no sheet named %q", f.Label, f.Target), g.win)
+			return
+		}
+		showRelationPicker(g.win, g.db, target, f, ids, func(chosen []int) {
+			labels, err := relationLabels(g.db, target, f, chosen)
+			if err != nil {
+				log.Printf("warning: failed to load labels of %s: %v", f.Name, err)
+			}
+			for k, v := range labels {
+				g.relLabels[f.Name][k] = v
+			}
+			g.setField(rowIdx, id, f.Name, relationValue(f, chosen))
+			g.refreshField(rowIdx, f.Name)
+		})
+	}
+	c.relationOverlay.onRightClick = nil
+	c.show(c.relationCell)
+}
+
+// bindActions shows the per-row action buttons.
+func (c *gridCell) bindActions(rowIdx int, r Row) {
+	g := c.g
+	if c.actions == nil {
+		c.rowCheck = widget.NewCheck("", nil)
+		c.trash = widget.NewButton("🗑", nil)
+		c.historyBtn = widget.NewButton("History", nil)
+		c.formBtn = widget.NewButton("Form", nil)
+		c.actions = container.NewHBox(c.rowCheck, c.trash, c.historyBtn, c.formBtn)
+	}
+	id := r.ID
+	c.rowCheck.OnChanged = nil
+	c.rowCheck.SetChecked(g.checked[id])
+	c.rowCheck.OnChanged = func(on bool) {
+		g.setChecked(id, on)
+	}
+	c.formBtn.OnTapped = func() {
+		g.showRowForm(rowIdx)
+	}
+	c.historyBtn.OnTapped = func() {
+		g.flush()
+		showRowHistory(g.win, g.db, id, func() { g.populateTableGrid(g.view) })
+	}
+	c.trash.OnTapped = func() {
+		dialog.ShowConfirm("Delete", "Delete this row? You can undo this with Ctrl+Z.", func(yes bool) {
+			if !yes || !g.flush() {
+				return
+			}
+			if err := undoableDeleteRow(g.db, id); err != nil {
+				dialog.ShowError(err, g.win)
+				return
+			}
+			g.populateTableGrid(g.view)
+		}, g.win)
+	}
+	c.show(c.actions)
+}
+
+// problemMarker is the warning icon of an invalid cell. Hovering or tapping it
+// shows the validation message in a small popup that closes by itself.
+type problemMarker struct {
+	widget.BaseWidget
+	win   fyne.Window
+	msg   string
+	icon  *widget.Icon
+	popup *widget.PopUp
+}
+
+func newProblemMarker(win fyne.Window) *problemMarker {
+	m := &problemMarker{win: win, icon: widget.NewIcon(theme.ErrorIcon())}
+	m.ExtendBaseWidget(m)
+	return m
+}
+
+func (m *problemMarker) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(m.icon)
+}
+
+func (m *problemMarker) MinSize() fyne.Size {
+	s := theme.IconInlineSize()
+	return fyne.NewSize(s, s)
+}
+
+// showMessage opens the popup below the marker
+func (m *problemMarker) showMessage() {
+	if m.msg == "" {
+		return
+	}
+	if m.popup != nil {
+		m.popup.Hide()
+	}
+	m.popup = widget.NewPopUp(widget.NewLabel(m.msg), m.win.Canvas())
+	pos := fyne.CurrentApp().Driver().AbsolutePositionForObject(m)
+	m.popup.ShowAtPosition(pos.Add(fyne.NewPos(0, m.Size().Height)))
+
+	// the popup covers the canvas for pointer events, so it can't follow MouseOut
+	p := m.popup
+	time.AfterFunc(3*time.Second, func() {
+		fyne.Do(p.Hide)
+	})
+}
+
+func (m *problemMarker) Tapped(*fyne.PointEvent) { m.showMessage() }
+
+func (m *problemMarker) MouseIn(*desktop.MouseEvent)    { m.showMessage() }
+func (m *problemMarker) MouseMoved(*desktop.MouseEvent) {}
+func (m *problemMarker) MouseOut()                      {}
@@ -0,0 +1,921 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// import modes
+const (
+	importReplace = "replace" // delete all rows (and views, if the file has any) first
+	importAppend  = "append"  // insert every imported row as a new row
+	importUpsert  = "upsert"  // update rows matching the key field, insert the rest
+)
+
+// importUpdate is an existing row that an upsert will overwrite
+type importUpdate struct {
+	ID     int
+	Data   map[string]interface{}
+	FileID int // the row's ID in the file, 0 when it has none
+
+	imported map[string]bool // fields set from the file
+}
+
+// importConflict is an imported row that cannot be applied (invalid values or an
+// ambiguous upsert key); it is skipped
+type importConflict struct {
+	Index  int // 0-based position in the imported rows, -1 for a stored row
+	Key    string
+	Reason string
+}
+
+func (c importConflict) String() string {
+	if c.Index < 0 {
+		return c.Reason // about a stored row, not an imported one
+	}
+	return fmt.Sprintf("row %d (key %q): %s", c.Index+1, c.Key, c.Reason)
+}
+
+// importPlan is the dry-run result of an import into one sheet; applyImport commits it
+type importPlan struct {
+	Sheet     int    // target sheet; 0 creates a sheet named SheetName
+	SheetName string // name in a multi-sheet file, "" for a single-sheet file
+	Mode      string
+	KeyField  string
+	Deletes   int   // rows removed by replace
+	Keep      []int // rows replace keeps because rows of other sheets refer to them
+	Inserts   []map[string]interface{}
+	InsertIDs []int // the ID in the file of each insert, 0 when it has none
+	Updates   []importUpdate
+	Conflicts []importConflict
+
+	Views        []View // views to insert
+	ReplaceViews bool   // delete existing views first
+
+	Schema []FieldDef         // new schema of the sheet, nil when it stays the same
+	Widths map[string]float32 // column widths to store, nil to keep them
+
+	schema []FieldDef // schema the rows are written with
+}
+
+// Summary describes the plan for the confirmation dialog
+func (p *importPlan) Summary() string {
+	var b strings.Builder
+	switch {
+	case p.Sheet == 0:
+		fmt.Fprintf(&b, "Sheet %s (new, not undoable)\n", p.SheetName)
+	case p.SheetName != "":
+		fmt.Fprintf(&b, "Sheet %s\n", p.SheetName)
+	}
+	switch p.Mode {
+	case importUpsert:
+		fmt.Fprintf(&b, "Mode: upsert by %s\n", p.KeyField)
+	default:
+		fmt.Fprintf(&b, "Mode: %s\n", p.Mode)
+	}
+	if p.Mode == importReplace {
+		fmt.Fprintf(&b, "Rows deleted: %d\n", p.Deletes)
+	}
+	fmt.Fprintf(&b, "Rows inserted: %d\n", len(p.Inserts))
+	fmt.Fprintf(&b, "Rows updated: %d\n", len(p.Updates))
+	fmt.Fprintf(&b, "Conflicts (skipped): %d\n", len(p.Conflicts))
+	if p.Schema != nil && p.Sheet == 0 {
+		fmt.Fprintf(&b, "Schema: %d fields\n", len(p.Schema))
+	} else if p.Schema != nil && p.Mode == importReplace {
+		fmt.Fprintf(&b, "Schema replaced by the file's %d fields (not undoable)\n", len(p.Schema))
+	} else if p.Schema != nil {
+		fmt.Fprintf(&b, "Schema extended to %d fields from the file (not undoable)\n", len(p.Schema))
+	}
+	if p.ReplaceViews {
+		fmt.Fprintf(&b, "Views replaced by %d imported views\n", len(p.Views))
+	} else if len(p.Views) > 0 {
+		fmt.Fprintf(&b, "New views: %d\n", len(p.Views))
+	}
+	for _, c := range p.Conflicts {
+		b.WriteString("  " + c.String() + "\n")
+	}
+	return b.String()
+}
+
+// importSummary describes the plans of one import file
+func importSummary(plans []*importPlan) string {
+	var parts []string
+	for _, p := range plans {
+		parts = append(parts, p.Summary())
+	}
+	return strings.Join(parts, "\n")
+}
+
+// jsonImport is the content of one sheet of a JSON import file
+type jsonImport struct {
+	Name     string // sheet name; "" for a single-sheet file
+	Entries  []map[string]interface{}
+	Views    []View
+	HasViews bool               // the file carried a views block at all
+	Schema   []FieldDef         // nil when the file has no schema block
+	Widths   map[string]float32 // nil when the file has no widths
+}
+
+// parseJSONImport reads our JSON export format: an object { "sheets": [...] } with an
+// object { "name": ..., "schema": [...], "entries": [...], "views": [...], "widths": {...} }
+// per sheet. A single-sheet file is one such object without a name, or a legacy array
+// of entries.
+func parseJSONImport(data []byte) ([]*jsonImport, error) {
+	var j interface{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+
+	switch t := j.(type) {
+	case []interface{}:
+		// legacy array of objects
+		in := &jsonImport{}
+		if err := json.Unmarshal(data, &in.Entries); err != nil {
+			return nil, err
+		}
+		return []*jsonImport{in}, nil
+	case map[string]interface{}:
+		rawSheets, ok := t["sheets"].([]interface{})
+		if !ok {
+			in, err := parseJSONSheet(t)
+			if err != nil {
+				return nil, err
+			}
+			return []*jsonImport{in}, nil
+		}
+		var out []*jsonImport
+		names := map[string]bool{}
+		for i, raw := range rawSheets {
+			obj, ok := raw.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("sheet %d is not an object", i+1)
+			}
+			in, err := parseJSONSheet(obj)
+			if err != nil {
+				return nil, fmt.Errorf("sheet %d: %w", i+1, err)
+			}
+			in.Name = strings.TrimSpace(in.Name)
+			if in.Name == "" {
+				return nil, fmt.Errorf("sheet %d has no name", i+1)
+			}
+			if names[strings.ToLower(in.Name)] {
+				return nil, fmt.Errorf("sheet %q appears twice", in.Name)
+			}
+			names[strings.ToLower(in.Name)] = true
+			out = append(out, in)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown import format")
+}
+
+// parseJSONSheet reads the object of one sheet
+func parseJSONSheet(t map[string]interface{}) (*jsonImport, error) {
+	in := &jsonImport{}
+	if name, ok := t["name"].(string); ok {
+		in.Name = name
+	}
+	if rawEntries, ok := t["entries"]; ok {
+		entriesBytes, _ := json.Marshal(rawEntries)
+		if err := json.Unmarshal(entriesBytes, &in.Entries); err != nil {
+			return nil, err
+		}
+	}
+	if rawViews, ok := t["views"]; ok {
+		in.HasViews = true
+		viewsBytes, _ := json.Marshal(rawViews)
+		// tolerate several shapes: fields that don't fit are left empty
+		_ = json.Unmarshal(viewsBytes, &in.Views)
+		for i := range in.Views {
+			in.Views[i].ID = 0
+		}
+	}
+	if rawSchema, ok := t["schema"]; ok && rawSchema != nil {
+		schemaBytes, _ := json.Marshal(rawSchema)
+		if err := json.Unmarshal(schemaBytes, &in.Schema); err != nil {
+			return nil, fmt.Errorf("schema block: %v", err)
+		}
+		if err := compileSchema(in.Schema); err != nil {
+			return nil, fmt.Errorf("schema block: %v", err)
+		}
+	}
+	if rawWidths, ok := t["widths"]; ok && rawWidths != nil {
+		widthsBytes, _ := json.Marshal(rawWidths)
+		// widths are cosmetic: ignore a block that doesn't fit
+		_ = json.Unmarshal(widthsBytes, &in.Widths)
+	}
+	return in, nil
+}
+
+// exportJSON writes every sheet with its schema, rows, views and column widths in the
+// format read by parseJSONImport. schema is used for the sheet current, the other
+// sheets are written with their stored schema.
+func exportJSON(db sqlExecer, current int, schema []FieldDef) ([]byte, error) {
+	sheets, err := getSheets(db)
+	if err != nil {
+		return nil, err
+	}
+	var out []map[string]interface{}
+	for _, sh := range sheets {
+		sheetSchema := schema
+		if sh.ID != current {
+			if _, sheetSchema, err = storedSchema(db, sh.ID); err != nil {
+				return nil, err
+			}
+		}
+		obj, err := exportSheetJSON(db, sh, sheetSchema)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, obj)
+	}
+	return json.MarshalIndent(map[string]interface{}{"sheets": out}, "", "  ")
+}
+
+// exportSheetJSON returns the export object of one sheet
+func exportSheetJSON(db sqlExecer, sh Sheet, schema []FieldDef) (map[string]interface{}, error) {
+	// all rows with their lookup and rollup values
+	rows, err := getViewRows(db, sh.ID, schema, View{})
+	if err != nil {
+		return nil, err
+	}
+	var entries []map[string]interface{}
+	for _, r := range rows {
+		objData := mergeWithSchema(schema, r.Data)
+		obj := attachIDToDataMap(r.ID, objData)
+		entries = append(entries, obj)
+	}
+
+	// include views
+	views, err := getAllViews(db, sh.ID)
+	if err != nil {
+		// non-fatal: continue with empty views
+		views = nil
+	}
+	var exportedViews []map[string]interface{}
+	for _, v := range views {
+		exportedViews = append(exportedViews, map[string]interface{}{
+			"Name":    v.Name,
+			"Columns": v.Columns,
+			"Filters": v.Filters,
+			"Sort":    v.Sort,
+			"Form":    v.Form,
+		})
+	}
+
+	return map[string]interface{}{
+		"name":    sh.Name,
+		"schema":  schema,
+		"entries": entries,
+		"views":   exportedViews,
+		"widths":  sh.Widths,
+	}, nil
+}
+
+// importKey returns the comparable key of a value for upsert matching
+func importKey(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(t)
+	case string:
+		return strings.TrimSpace(t)
+	default:
+		return fmt.Sprintf("%v", t)
+	}
+}
+
+// planWorkbookImport plans the import of every sheet of a file. The sheet of a
+// single-sheet file is imported into current (with schema); named sheets go into
+// the sheet of the same name, which is created if there is none.
+func planWorkbookImport(db sqlExecer, current int, schema []FieldDef, ins []*jsonImport, mode, keyField string) ([]*importPlan, error) {
+	sheets := make([]int, len(ins))
+	schemas := make([][]FieldDef, len(ins))
+	for i, in := range ins {
+		sheet, sheetSchema := current, schema
+		if in.Name != "" {
+			sh, err := sheetByName(db, in.Name)
+			switch {
+			case err != nil:
+				// a new sheet starts from the file's schema, or the current one
+				sheet = 0
+			case sh.ID != current:
+				sheet = sh.ID
+				version, stored, err := storedSchema(db, sh.ID)
+				if err != nil {
+					return nil, err
+				}
+				if version > 0 {
+					sheetSchema = stored
+				}
+			}
+		}
+		sheets[i], schemas[i] = sheet, sheetSchema
+	}
+
+	// relations may refer to rows of any sheet of the file by their exported ID
+	fileIDs := map[int]map[int]bool{}
+	for i, in := range ins {
+		if sheets[i] != 0 {
+			fileIDs[sheets[i]] = exportedIDs(in.Entries)
+		}
+	}
+	var plans []*importPlan
+	for i, in := range ins {
+		p, err := planSheetImport(db, sheets[i], schemas[i], in, mode, keyField, fileIDs)
+		if err != nil {
+			if in.Name != "" {
+				err = fmt.Errorf("sheet %s: %w", in.Name, err)
+			}
+			return nil, err
+		}
+		p.SheetName = in.Name
+		plans = append(plans, p)
+	}
+	if err := keepReferencedRows(db, plans); err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// planImport computes what importing one sheet of a file in the given mode would do
+// without writing anything. keyField is only used for upserts; "ID" matches row ids.
+// A schema block replaces schema in replace mode and adds its missing fields otherwise.
+// sheet 0 plans a new sheet.
+func planImport(db sqlExecer, sheet int, schema []FieldDef, in *jsonImport, mode, keyField string) (*importPlan, error) {
+	p, err := planSheetImport(db, sheet, schema, in, mode, keyField, map[int]map[int]bool{sheet: exportedIDs(in.Entries)})
+	if err != nil {
+		return nil, err
+	}
+	return p, keepReferencedRows(db, []*importPlan{p})
+}
+
+// planSheetImport is planImport for one sheet of a file; fileIDs holds the exported
+// IDs of the rows the file brings for each existing sheet it imports into
+func planSheetImport(db sqlExecer, sheet int, schema []FieldDef, in *jsonImport, mode, keyField string, fileIDs map[int]map[int]bool) (*importPlan, error) {
+	p := &importPlan{Sheet: sheet, Mode: mode, KeyField: keyField}
+	entries, views, hasViews := in.Entries, in.Views, in.HasViews
+	if sheet == 0 {
+		// nothing to replace or update in a new sheet
+		mode = importAppend
+		p.Mode, p.KeyField = mode, ""
+		p.Schema = schema
+		if in.Schema != nil {
+			p.Schema, schema = in.Schema, in.Schema
+		}
+	}
+	if mode == importReplace || sheet == 0 {
+		p.Widths = in.Widths
+	}
+
+	if in.Schema != nil && sheet != 0 {
+		target := in.Schema
+		if mode != importReplace {
+			var err error
+			if target, err = mergeSchemas(in.Schema, schema); err != nil {
+				return nil, err
+			}
+		}
+		if !sameFieldDefs(target, schema) {
+			p.Schema = target
+			schema = target
+		}
+	}
+
+	existing, err := getAllRows(db, sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	// sheets the relation fields point to; references into a new sheet or replaced
+	// rows can't be checked, those to rows of the file are pointed to the imported rows
+	targets := map[string]int{}
+	for _, f := range schema {
+		if f.Type != "relation" {
+			continue
+		}
+		target, err := relationTarget(db, sheet, f)
+		if err != nil {
+			continue
+		}
+		if target != sheet || (mode != importReplace && sheet != 0) {
+			targets[f.Name] = target
+		}
+	}
+
+	// convert values to the schema types; rows that don't fit are reported as conflicts
+	var valid []map[string]interface{}
+	var validIndex []int
+	for i, e := range entries {
+		n, err := normalizeRow(schema, e)
+		if err != nil {
+			p.Conflicts = append(p.Conflicts, importConflict{Index: i, Reason: err.Error()})
+			continue
+		}
+		if probs := validateRow(schema, mergeWithSchema(schema, n)); len(probs) > 0 {
+			p.Conflicts = append(p.Conflicts, importConflict{Index: i, Reason: problemText(probs)})
+			continue
+		}
+		reason, err := missingRelationText(db, schema, targets, fileIDs, n)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			p.Conflicts = append(p.Conflicts, importConflict{Index: i, Reason: reason})
+			continue
+		}
+		valid = append(valid, n)
+		validIndex = append(validIndex, i)
+	}
+	entries = valid
+
+	// file index of every planned insert/update, for the unique check below
+	var insertIdx, updateIdx []int
+
+	switch mode {
+	case importReplace:
+		p.Deletes = len(existing)
+		for vi, e := range entries {
+			p.Inserts = append(p.Inserts, mergeWithSchema(schema, e))
+			p.InsertIDs = append(p.InsertIDs, exportedID(e))
+			insertIdx = append(insertIdx, validIndex[vi])
+		}
+	case importAppend:
+		for vi, e := range entries {
+			p.Inserts = append(p.Inserts, mergeWithSchema(schema, e))
+			p.InsertIDs = append(p.InsertIDs, exportedID(e))
+			insertIdx = append(insertIdx, validIndex[vi])
+		}
+	case importUpsert:
+		if keyField == "" {
+			return nil, fmt.Errorf("upsert needs a key field")
+		}
+		byID := strings.EqualFold(keyField, "ID")
+		index := map[string][]Row{}
+		for _, r := range existing {
+			k := importKey(r.Data[keyField])
+			if byID {
+				k = strconv.Itoa(r.ID)
+			}
+			index[k] = append(index[k], r)
+		}
+
+		seen := map[string]bool{}
+		for vi, e := range entries {
+			i := validIndex[vi]
+			k := importKey(e[keyField])
+			switch {
+			case k == "":
+				p.Conflicts = append(p.Conflicts, importConflict{Index: i, Reason: "key field is empty"})
+				continue
+			case seen[k]:
+				p.Conflicts = append(p.Conflicts, importConflict{Index: i, Key: k, Reason: "duplicate key in file"})
+				continue
+			}
+			seen[k] = true
+
+			matches := index[k]
+			switch len(matches) {
+			case 0:
+				p.Inserts = append(p.Inserts, mergeWithSchema(schema, e))
+				p.InsertIDs = append(p.InsertIDs, exportedID(e))
+				insertIdx = append(insertIdx, i)
+			case 1:
+				// imported fields win, fields missing from the file keep their current values
+				data := mergeWithSchema(schema, matches[0].Data)
+				imported := map[string]bool{}
+				for fk, fv := range e {
+					data[fk] = fv
+					imported[fk] = true
+				}
+				p.Updates = append(p.Updates, importUpdate{ID: matches[0].ID, Data: data, FileID: exportedID(e), imported: imported})
+				updateIdx = append(updateIdx, i)
+			default:
+				p.Conflicts = append(p.Conflicts, importConflict{Index: i, Key: k, Reason: fmt.Sprintf("matches %d existing rows", len(matches))})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown import mode %q", mode)
+	}
+	for _, data := range p.Inserts {
+		computeFormulas(schema, data)
+	}
+	for _, u := range p.Updates {
+		computeFormulas(schema, u.Data)
+	}
+	p.dropUniqueConflicts(schema, existing, insertIdx, updateIdx)
+	p.schema = schema
+
+	if mode == importReplace && hasViews {
+		p.ReplaceViews = true
+		p.Views = views
+	} else if hasViews {
+		// keep existing views, add the ones with new names
+		current, err := getAllViews(db, sheet)
+		if err != nil {
+			return nil, err
+		}
+		names := map[string]bool{}
+		for _, v := range current {
+			names[v.Name] = true
+		}
+		for _, v := range views {
+			if !names[v.Name] {
+				p.Views = append(p.Views, v)
+				names[v.Name] = true
+			}
+		}
+	}
+	return p, nil
+}
+
+// missingRelationText describes the first relation value of data that refers to a
+// row missing from its target sheet (targets, by field name) and from the rows the
+// file brings for it (fileIDs), "" when there is none
+func missingRelationText(db sqlExecer, schema []FieldDef, targets map[string]int, fileIDs map[int]map[int]bool, data map[string]interface{}) (string, error) {
+	for _, f := range schema {
+		target, ok := targets[f.Name]
+		if !ok {
+			continue
+		}
+		var stored []int
+		for _, id := range relationIDs(data[f.Name]) {
+			if !fileIDs[target][id] {
+				stored = append(stored, id)
+			}
+		}
+		missing, err := missingRelationID(db, target, f, stored)
+		if err != nil {
+			return "", err
+		}
+		if missing != 0 {
+			return fmt.Sprintf("%s: row %d does not exist", f.Name, missing), nil
+		}
+	}
+	return "", nil
+}
+
+// keepReferencedRows keeps the rows a replace would delete while a row of a sheet
+// the import leaves alone refers to them through a blocking relation field; each is
+// reported as a conflict. Set-null and cascading references are followed on apply.
+func keepReferencedRows(db sqlExecer, plans []*importPlan) error {
+	replaced := map[int]bool{}
+	for _, p := range plans {
+		if p.Mode == importReplace && p.Sheet != 0 {
+			replaced[p.Sheet] = true
+		}
+	}
+	if len(replaced) == 0 {
+		return nil
+	}
+	incoming, err := incomingRelations(db)
+	if err != nil {
+		return err
+	}
+	for _, p := range plans {
+		if !replaced[p.Sheet] {
+			continue
+		}
+		var refs []relationRef
+		for _, ref := range incoming[p.Sheet] {
+			if !replaced[ref.sheet] && ref.field.OnDelete != relationSetNull && ref.field.OnDelete != relationCascade {
+				refs = append(refs, ref)
+			}
+		}
+		if len(refs) == 0 {
+			continue
+		}
+		existing, err := getAllRows(db, p.Sheet)
+		if err != nil {
+			return err
+		}
+		for _, r := range existing {
+			for _, ref := range refs {
+				rows, err := referencingRows(db, ref, r.ID)
+				if err != nil {
+					return err
+				}
+				if len(rows) > 0 {
+					from, err := getSheet(db, ref.sheet)
+					if err != nil {
+						return err
+					}
+					p.Keep = append(p.Keep, r.ID)
+					p.Deletes--
+					p.Conflicts = append(p.Conflicts, importConflict{Index: -1, Key: strconv.Itoa(r.ID),
+						Reason: fmt.Sprintf("row %d is kept: row %d of sheet %s refers to it in %s", r.ID, rows[0], from.Name, ref.field.Label)})
+					break
+				}
+			}
+		}
+		sort.SliceStable(p.Conflicts, func(a, b int) bool { return p.Conflicts[a].Index < p.Conflicts[b].Index })
+	}
+	return nil
+}
+
+// exportedID returns the ID a row had in the exporting database, 0 when it has none
+func exportedID(data map[string]interface{}) int {
+	for k, v := range data {
+		if strings.EqualFold(k, "ID") {
+			if ids := relationIDs(v); len(ids) == 1 {
+				return ids[0]
+			}
+		}
+	}
+	return 0
+}
+
+// exportedIDs returns the set of exported IDs of entries
+func exportedIDs(entries []map[string]interface{}) map[int]bool {
+	out := map[int]bool{}
+	for _, e := range entries {
+		if id := exportedID(e); id != 0 {
+			out[id] = true
+		}
+	}
+	return out
+}
+
+// dropUniqueConflicts moves imported rows whose value of a Unique field is already
+// used by a remaining row or an earlier imported row to the conflicts.
+func (p *importPlan) dropUniqueConflicts(schema []FieldDef, existing []Row, insertIdx, updateIdx []int) {
+	var unique []FieldDef
+	for _, f := range schema {
+		if f.Unique && !(f.Type == "int" && strings.EqualFold(f.Name, "ID")) {
+			unique = append(unique, f)
+		}
+	}
+	if len(unique) == 0 {
+		return
+	}
+
+	// values held by rows the import leaves untouched
+	updated := map[int]bool{}
+	for _, u := range p.Updates {
+		updated[u.ID] = true
+	}
+	taken := map[string]map[string]bool{}
+	for _, f := range unique {
+		taken[f.Name] = map[string]bool{}
+		if p.Mode == importReplace {
+			continue
+		}
+		for _, r := range existing {
+			if !updated[r.ID] {
+				if k := uniqueKey(r.Data[f.Name]); k != "" {
+					taken[f.Name][k] = true
+				}
+			}
+		}
+	}
+
+	// claim reports whether data's unique values are free and marks them taken
+	claim := func(index int, data map[string]interface{}) bool {
+		for _, f := range unique {
+			if k := uniqueKey(data[f.Name]); k != "" && taken[f.Name][k] {
+				p.Conflicts = append(p.Conflicts, importConflict{Index: index, Key: k, Reason: f.Name + ": value is not unique"})
+				return false
+			}
+		}
+		for _, f := range unique {
+			if k := uniqueKey(data[f.Name]); k != "" {
+				taken[f.Name][k] = true
+			}
+		}
+		return true
+	}
+
+	var updates []importUpdate
+	for i, u := range p.Updates {
+		if claim(updateIdx[i], u.Data) {
+			updates = append(updates, u)
+		}
+	}
+	var inserts []map[string]interface{}
+	var insertIDs []int
+	for i, data := range p.Inserts {
+		if claim(insertIdx[i], data) {
+			inserts = append(inserts, data)
+			if i < len(p.InsertIDs) {
+				insertIDs = append(insertIDs, p.InsertIDs[i])
+			}
+		}
+	}
+	p.Updates, p.Inserts, p.InsertIDs = updates, inserts, insertIDs
+	sort.SliceStable(p.Conflicts, func(a, b int) bool { return p.Conflicts[a].Index < p.Conflicts[b].Index })
+}
+
+// uniqueKey is the comparable form of a value for the Unique rule; "" for empty values
+func uniqueKey(v interface{}) string {
+	if isEmptyValue(v) {
+		return ""
+	}
+	switch v.(type) {
+	case []string, []interface{}:
+		js, _ := json.Marshal(toStringList(v))
+		return string(js)
+	}
+	return importKey(v)
+}
+
+// applyImport commits the plans of one file in a single transaction; on any error
+// nothing is changed. The whole import is recorded as one undo step, unless it creates
+// a sheet or changes a schema: that is recorded as a new schema version and clears the
+// undo log of the sheets involved, like a migration.
+func applyImport(db *sql.DB, plans ...*importPlan) error {
+	undoable := true
+	for _, p := range plans {
+		if p.Sheet == 0 || p.Schema != nil {
+			undoable = false
+		}
+	}
+	if undoable {
+		return withUndo(db, "Import", "", func(tx *sql.Tx, rec *undoRecorder) error {
+			return applyPlans(tx, rec, plans)
+		})
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, p := range plans {
+		if p.Sheet == 0 {
+			sh, err := insertSheet(tx, p.SheetName, p.Schema)
+			if err != nil {
+				return err
+			}
+			p.Sheet = sh.ID
+		} else if p.Schema != nil {
+			version, _, err := storedSchema(tx, p.Sheet)
+			if err != nil {
+				return err
+			}
+			if err := recordSchemaVersion(tx, p.Sheet, version+1, p.Schema); err != nil {
+				return err
+			}
+		}
+	}
+	if err := applyPlans(tx, nil, plans); err != nil {
+		return err
+	}
+	for _, p := range plans {
+		if _, err := tx.Exec("DELETE FROM undo_log WHERE sheet_id = ?", p.Sheet); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// applyPlans writes the plans in tx; rec may be nil. Imported rows get new ids, so
+// the ids of all sheets are allocated first; then relation values and relation
+// filters of views referring to rows of the file by their exported ID are pointed
+// to the rows they became, within a sheet and across the sheets of the file.
+func applyPlans(tx *sql.Tx, rec *undoRecorder, plans []*importPlan) error {
+	// the replaced rows of all sheets go in one delete, so references between
+	// them don't block it
+	var doomed []int
+	for _, p := range plans {
+		if p.Mode != importReplace {
+			continue
+		}
+		keep := map[int]bool{}
+		for _, id := range p.Keep {
+			keep[id] = true
+		}
+		rows, err := getAllRows(tx, p.Sheet)
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if !keep[r.ID] {
+				doomed = append(doomed, r.ID)
+			}
+		}
+	}
+	if err := deleteRows(tx, rec, doomed); err != nil {
+		return err
+	}
+
+	newIDs := map[int]map[int]int{} // sheet -> exported ID -> row id
+	inserted := make([][]int, len(plans))
+	for i, p := range plans {
+		if newIDs[p.Sheet] == nil {
+			newIDs[p.Sheet] = map[int]int{}
+		}
+		for j := range p.Inserts {
+			res, err := tx.Exec("INSERT INTO entries (data, sheet_id) VALUES ('{}', ?)", p.Sheet)
+			if err != nil {
+				return err
+			}
+			id, err := res.LastInsertId()
+			if err != nil {
+				return err
+			}
+			rec.created("entries", int(id))
+			inserted[i] = append(inserted[i], int(id))
+			if j < len(p.InsertIDs) && p.InsertIDs[j] != 0 {
+				newIDs[p.Sheet][p.InsertIDs[j]] = int(id)
+			}
+		}
+		for _, u := range p.Updates {
+			if u.FileID != 0 {
+				newIDs[p.Sheet][u.FileID] = u.ID
+			}
+		}
+	}
+
+	for i, p := range plans {
+		remaps := p.relationRemaps(tx, newIDs)
+		for j, data := range p.Inserts {
+			remapRelations(p.schema, data, remaps, nil)
+			if err := replaceRow(tx, inserted[i][j], data); err != nil {
+				return err
+			}
+		}
+		for _, u := range p.Updates {
+			if err := rec.touch("entries", u.ID); err != nil {
+				return err
+			}
+			remapRelations(p.schema, u.Data, remaps, u.imported)
+			if err := replaceRow(tx, u.ID, u.Data); err != nil {
+				return err
+			}
+		}
+		if p.ReplaceViews {
+			if err := rec.touchAll("views", p.Sheet); err != nil {
+				return err
+			}
+			if err := deleteAllViews(tx, p.Sheet); err != nil {
+				return err
+			}
+		}
+		for _, v := range p.Views {
+			for k, flt := range v.Filters {
+				if m, ok := remaps[flt.Field]; ok && flt.Op == filterListContains {
+					if id, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(flt.Value), "#")); err == nil {
+						if n, ok := m[id]; ok {
+							v.Filters[k].Value = strconv.Itoa(n)
+						}
+					}
+				}
+			}
+			if v.IDs != nil {
+				v.IDs = remapIDs(v.IDs, newIDs[p.Sheet])
+			}
+			id, err := insertView(tx, p.Sheet, v)
+			if err != nil {
+				return err
+			}
+			rec.created("views", int(id))
+		}
+		// column widths are not part of the undo history
+		if p.Widths != nil {
+			if err := saveSheetWidths(tx, p.Sheet, p.Widths); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// relationRemaps returns, by relation field of the plan's schema, the exported IDs
+// of the rows imported into the field's target sheet and the ids they became
+func (p *importPlan) relationRemaps(db sqlExecer, newIDs map[int]map[int]int) map[string]map[int]int {
+	out := map[string]map[int]int{}
+	for _, f := range p.schema {
+		if f.Type != "relation" {
+			continue
+		}
+		target, err := relationTarget(db, p.Sheet, f)
+		if err != nil {
+			continue // the target sheet is gone, its ids stay as they are
+		}
+		if m := newIDs[target]; len(m) > 0 {
+			out[f.Name] = m
+		}
+	}
+	return out
+}
+
+// remapRelations points the relation values of data through remaps; only fields
+// in only are changed, all when it is nil
+func remapRelations(schema []FieldDef, data map[string]interface{}, remaps map[string]map[int]int, only map[string]bool) {
+	for _, f := range schema {
+		m, ok := remaps[f.Name]
+		if !ok || (only != nil && !only[f.Name]) {
+			continue
+		}
+		if v, ok := data[f.Name]; ok && v != nil {
+			data[f.Name] = relationValue(f, remapIDs(relationIDs(v), m))
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var importTestSchema = []FieldDef{
+	{Name: "ID", Type: "int"},
+	{Name: "Code", Type: "string", Unique: true},
+	{Name: "Name", Type: "string"},
+	{Name: "Qty", Type: "int"},
+}
+
+// importRowText is the Code, Name and Qty of a planned row
+func importRowText(data map[string]interface{}) string {
+	return fmt.Sprintf("%v/%v/%v", data["Code"], data["Name"], data["Qty"])
+}
+
+func TestPlanImport(t *testing.T) {
+	db := openTestDB(t)
+	s := newTestSheet(t, db, "Stock", importTestSchema)
+	ids := map[string]int{} // existing rows by Code
+	for _, r := range []map[string]interface{}{
+		{"Code": "A", "Name": "Ann", "Qty": 1},
+		{"Code": "B", "Name": "Bob", "Qty": 2},
+		{"Code": "C", "Name": "Dup", "Qty": 3},
+		{"Code": "D", "Name": "Dup", "Qty": 4},
+	} {
+		id, err := insertRow(db, s.ID, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[r["Code"].(string)] = int(id)
+	}
+	codes := map[int]string{}
+	for code, id := range ids {
+		codes[id] = code
+	}
+
+	tests := []struct {
+		name      string
+		mode, key string
+		entries   string   // the imported rows as JSON
+		deletes   int      // want
+		inserts   []string // want, as importRowText
+		updates   []string // want, as Code of the updated row=importRowText
+		conflicts []string // want, as index: part of the reason
+	}{
+		{
+			name: "replace", mode: importReplace,
+			entries: `[{"Code": "A", "Name": "New"}, {"Code": "X"}]`,
+			deletes: 4, inserts: []string{"A/New/0", "X//0"},
+		},
+		{
+			name: "replace duplicate in file", mode: importReplace,
+			entries: `[{"Code": "X"}, {"Code": "X", "Qty": 2}]`,
+			deletes: 4, inserts: []string{"X//0"}, conflicts: []string{"1: Code: value is not unique"},
+		},
+		{
+			name: "append", mode: importAppend,
+			entries: `[{"Code": "X", "Qty": 5}, {"Name": "No code"}, {"Name": "No code either"}]`,
+			inserts: []string{"X//5", "/No code/0", "/No code either/0"}, // empty values don't clash
+		},
+		{
+			name: "append unique clash with existing", mode: importAppend,
+			entries: `[{"Code": "A"}, {"Code": "X"}]`,
+			inserts: []string{"X//0"}, conflicts: []string{"0: Code: value is not unique"},
+		},
+		{
+			name: "append unique clash in file", mode: importAppend,
+			entries: `[{"Code": "X"}, {"Code": "Y"}, {"Code": "X"}]`,
+			inserts: []string{"X//0", "Y//0"}, conflicts: []string{"2: Code: value is not unique"},
+		},
+		{
+			name: "append invalid value", mode: importAppend,
+			entries:   `[{"Code": "X", "Qty": "many"}, {"Code": "Y", "Qty": 1.5}]`,
+			conflicts: []string{"0: Qty:", "1: Qty: not an integer"},
+		},
+		{
+			name: "upsert by field", mode: importUpsert, key: "Code",
+			entries:   `[{"Code": "A", "Qty": 9}, {"Code": "X", "Name": "Xe"}, {"Code": "A", "Qty": 10}, {"Qty": 3}]`,
+			inserts:   []string{"X/Xe/0"},
+			updates:   []string{"A=A/Ann/9"}, // fields missing from the file are kept
+			conflicts: []string{"2: duplicate key in file", "3: key field is empty"},
+		},
+		{
+			name: "upsert key matching several rows", mode: importUpsert, key: "Name",
+			entries:   `[{"Name": "Dup", "Qty": 7}, {"Name": "Bob", "Qty": 8}]`,
+			updates:   []string{"B=B/Bob/8"},
+			conflicts: []string{"0: matches 2 existing rows"},
+		},
+		{
+			name: "upsert by ID", mode: importUpsert, key: "ID",
+			entries: fmt.Sprintf(`[{"ID": %d, "Name": "Bea"}, {"ID": 9999, "Code": "N"}]`, ids["B"]),
+			inserts: []string{"N//0"},
+			updates: []string{"B=B/Bea/2"},
+		},
+		{
+			name: "upsert unique clash with existing", mode: importUpsert, key: "Name",
+			entries:   `[{"Name": "Bob", "Code": "A"}, {"Name": "New", "Code": "C"}]`,
+			conflicts: []string{"0: Code: value is not unique", "1: Code: value is not unique"},
+		},
+		{
+			name: "upsert swapping unique values", mode: importUpsert, key: "Name",
+			entries: `[{"Name": "Ann", "Code": "B"}, {"Name": "Bob", "Code": "A"}]`,
+			updates: []string{"A=B/Ann/1", "B=A/Bob/2"},
+		},
+		{
+			name: "upsert unique clash between imported rows", mode: importUpsert, key: "Name",
+			entries:   `[{"Name": "Ann", "Code": "X"}, {"Name": "New", "Code": "X"}]`,
+			updates:   []string{"A=X/Ann/1"},
+			conflicts: []string{"1: Code: value is not unique"},
+		},
+	}
+	for _, tt := range tests {
+		var entries []map[string]interface{}
+		if err := json.Unmarshal([]byte(tt.entries), &entries); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		p, err := planImport(db, s.ID, importTestSchema, &jsonImport{Entries: entries}, tt.mode, tt.key)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if p.Deletes != tt.deletes {
+			t.Errorf("%s: deletes %d, want %d", tt.name, p.Deletes, tt.deletes)
+		}
+		var inserts []string
+		for _, data := range p.Inserts {
+			inserts = append(inserts, importRowText(data))
+		}
+		if fmt.Sprint(inserts) != fmt.Sprint(tt.inserts) {
+			t.Errorf("%s: inserts %v, want %v", tt.name, inserts, tt.inserts)
+		}
+		var updates []string
+		for _, u := range p.Updates {
+			updates = append(updates, codes[u.ID]+"="+importRowText(u.Data))
+		}
+		if fmt.Sprint(updates) != fmt.Sprint(tt.updates) {
+			t.Errorf("%s: updates %v, want %v", tt.name, updates, tt.updates)
+		}
+		if len(p.Conflicts) != len(tt.conflicts) {
+			t.Errorf("%s: conflicts %v, want %v", tt.name, p.Conflicts, tt.conflicts)
+			continue
+		}
+		for i, c := range p.Conflicts {
+			if got := fmt.Sprintf("%d: %s", c.Index, c.Reason); !strings.HasPrefix(got, tt.conflicts[i]) {
+				t.Errorf("%s: conflict %q, want %q", tt.name, got, tt.conflicts[i])
+			}
+		}
+	}
+
+	if _, err := planImport(db, s.ID, importTestSchema, &jsonImport{}, importUpsert, ""); err == nil {
+		t.Error("upsert without a key field: no error")
+	}
+	if _, err := planImport(db, s.ID, importTestSchema, &jsonImport{}, "merge", ""); err == nil {
+		t.Error("unknown mode: no error")
+	}
+}
+
+func TestDropUniqueConflicts(t *testing.T) {
+	schema := []FieldDef{
+		{Name: "ID", Type: "int", Unique: true}, // row ids are unique anyway and not checked
+		{Name: "Code", Type: "string", Unique: true},
+		{Name: "Tags", Type: "[]string", Unique: true},
+	}
+	existing := []Row{
+		{ID: 1, Data: map[string]interface{}{"Code": "A", "Tags": []interface{}{"x", "y"}}},
+		{ID: 2, Data: map[string]interface{}{"Code": "B"}},
+	}
+	p := &importPlan{
+		Mode: importUpsert,
+		Updates: []importUpdate{
+			{ID: 2, Data: map[string]interface{}{"ID": 1, "Code": "C"}},
+		},
+		Inserts: []map[string]interface{}{
+			{"ID": 1, "Code": "B"},                          // freed by the update of row 2
+			{"Code": "D", "Tags": []string{"x", "y"}},       // same list as row 1
+			{"Code": "C"},                                   // taken by the update
+			{"Code": "E", "Tags": []string{"y", "x"}},       // another order is another value
+			{"Code": "", "Tags": []interface{}{}},           // empty values never clash
+			{"Code": "", "Tags": []interface{}{}, "ID": 99}, // neither do they with each other
+		},
+		Conflicts: []importConflict{{Index: 3, Reason: "earlier conflict"}},
+	}
+	p.dropUniqueConflicts(schema, existing, []int{1, 2, 4, 5, 6, 7}, []int{0})
+
+	var inserts []string
+	for _, data := range p.Inserts {
+		inserts = append(inserts, fmt.Sprint(data["Code"]))
+	}
+	if want := "[B E  ]"; fmt.Sprint(inserts) != want {
+		t.Errorf("inserts %v, want %s", inserts, want)
+	}
+	if len(p.Updates) != 1 {
+		t.Errorf("updates %v, want the update of row 2", p.Updates)
+	}
+	var conflicts []string
+	for _, c := range p.Conflicts {
+		conflicts = append(conflicts, fmt.Sprintf("%d: %s", c.Index, c.Reason))
+	}
+	want := []string{"2: Tags: value is not unique", "3: earlier conflict", "4: Code: value is not unique"}
+	if fmt.Sprint(conflicts) != fmt.Sprint(want) {
+		t.Errorf("conflicts %q, want %q", conflicts, want)
+	}
+}
+
+func TestWorkbookImportRemapsRelations(t *testing.T) {
+	people := []FieldDef{{Name: "ID", Type: "int"}, {Name: "Name", Type: "string"}}
+	tasks := []FieldDef{
+		{Name: "ID", Type: "int"},
+		{Name: "Title", Type: "string"},
+		{Name: "Owner", Type: "relation", Target: "People", Display: "Name"},
+		{Name: "Helpers", Type: "relation", Target: "People", Multiple: true},
+		{Name: "Parent", Type: "relation"},
+		{Name: "OwnerName", Type: "lookup", Relation: "Owner", LookupField: "Name"},
+	}
+	for _, schema := range [][]FieldDef{people, tasks} {
+		if err := compileSchema(schema); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// export a workbook of People and Tasks relating to them and to each other
+	src := openTestDB(t)
+	sheets, err := getSheets(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := newTestSheet(t, src, "People", people)
+	ts := newTestSheet(t, src, "Tasks", tasks)
+	ann, _ := insertRow(src, ps.ID, map[string]interface{}{"Name": "Ann"})
+	bob, _ := insertRow(src, ps.ID, map[string]interface{}{"Name": "Bob"})
+	plan, _ := insertRow(src, ts.ID, map[string]interface{}{"Title": "Plan", "Owner": int(bob), "Helpers": []int{int(ann), int(bob)}})
+	if _, err := insertRow(src, ts.ID, map[string]interface{}{"Title": "Build", "Owner": int(ann), "Helpers": []int{}, "Parent": int(plan)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := insertView(src, ts.ID, View{Name: "Ann's", Filters: []ViewFilter{{Field: "Owner", Op: filterListContains, Value: fmt.Sprint(ann)}}}); err != nil {
+		t.Fatal(err)
+	}
+	js, err := exportJSON(src, sheets[0].ID, importTestSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// import it into a database whose first sheet already holds rows with those ids
+	db := openTestDB(t)
+	first, err := getSheets(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := insertRow(db, first[0].ID, map[string]interface{}{"Code": fmt.Sprint("c", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ins, err := parseJSONImport(js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans, err := planWorkbookImport(db, first[0].ID, importTestSchema, ins, importAppend, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range plans {
+		if len(p.Conflicts) > 0 {
+			t.Fatalf("sheet %s: conflicts %v", p.SheetName, p.Conflicts)
+		}
+	}
+	if err := applyImport(db, plans...); err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[int]string{}
+	psNew, err := sheetByName(db, "People")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := getAllRows(db, psNew.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rows {
+		names[r.ID] = fmt.Sprint(r.Data["Name"])
+	}
+	tsNew, err := sheetByName(db, "Tasks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err = getViewRows(db, tsNew.ID, tasks, View{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := map[int]string{}
+	for _, r := range rows {
+		titles[r.ID] = fmt.Sprint(r.Data["Title"])
+	}
+	var got []string
+	for _, r := range rows {
+		var helpers []string
+		for _, id := range relationIDs(r.Data["Helpers"]) {
+			helpers = append(helpers, names[id])
+		}
+		got = append(got, fmt.Sprintf("%s owner=%s helpers=%v parent=%s lookup=%v", r.Data["Title"],
+			names[firstID(r.Data["Owner"])], helpers, titles[firstID(r.Data["Parent"])], r.Data["OwnerName"]))
+	}
+	want := []string{
+		"Plan owner=Bob helpers=[Ann Bob] parent= lookup=Bob",
+		"Build owner=Ann helpers=[] parent=Plan lookup=Ann",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("imported tasks\n%q\nwant\n%q", got, want)
+	}
+
+	views, err := getAllViews(db, tsNew.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(views) != 1 || len(views[0].Filters) != 1 {
+		t.Fatalf("imported views %v", views)
+	}
+	var id int
+	fmt.Sscan(views[0].Filters[0].Value, &id)
+	if names[id] != "Ann" {
+		t.Errorf("view filter on Owner = %q, want the id of Ann", views[0].Filters[0].Value)
+	}
+}
+
+// firstID returns the first id of a relation value, 0 when it is empty
+func firstID(v interface{}) int {
+	if ids := relationIDs(v); len(ids) > 0 {
+		return ids[0]
+	}
+	return 0
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Lookup and rollup fields show values of the rows that a relation field of the
+// same row refers to. They are computed whenever rows are read and never stored,
+// so they follow every change of the referenced rows:
+//   - a "lookup" holds the LookupField values of the referenced rows (the value
+//     itself for a single relation, a list for a multiple one);
+//   - a "rollup" reduces those values with its Aggregate.
+
+// rollup aggregates (FieldDef.Aggregate)
+const (
+	rollupCount = "count" // number of referenced rows
+	rollupSum   = "sum"
+	rollupMin   = "min"
+	rollupMax   = "max"
+	rollupAvg   = "avg"
+	rollupJoin  = "join" // values as text, separated by ", "
+)
+
+// lookupChunk is how many ids are looked up per query
+const lookupChunk = 500
+
+// isComputedField reports whether the values of f are computed rather than entered
+func isComputedField(f FieldDef) bool {
+	return f.Type == "formula" || f.Type == "lookup" || f.Type == "rollup"
+}
+
+// queryableFields returns the fields that views can filter and sort on in SQL;
+// lookup and rollup values don't exist in the database
+func queryableFields(schema []FieldDef) []FieldDef {
+	var out []FieldDef
+	for _, f := range schema {
+		if f.Type != "lookup" && f.Type != "rollup" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// checkLookupField checks that a lookup or rollup field names a relation field of
+// schema, a field to read and (for a rollup) a known aggregate
+func checkLookupField(schema []FieldDef, f FieldDef) error {
+	found := false
+	for _, rf := range schema {
+		if rf.Name == f.Relation && rf.Type == "relation" {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("field %s: Relation must name a relation field", f.Name)
+	}
+	if f.Type == "rollup" {
+		switch f.Aggregate {
+		case rollupCount:
+			return nil
+		case rollupSum, rollupMin, rollupMax, rollupAvg, rollupJoin:
+		default:
+			return fmt.Errorf("field %s: Aggregate must be one of count, sum, min, max, avg, join", f.Name)
+		}
+	}
+	if f.LookupField == "" {
+		return fmt.Errorf("field %s: LookupField is missing", f.Name)
+	}
+	return nil
+}
+
+// fillLookups computes the lookup and rollup fields of rows of sheet in place
+func fillLookups(db sqlExecer, sheet int, schema []FieldDef, rows []Row) error {
+	relations := map[string]FieldDef{}
+	for _, f := range schema {
+		if f.Type == "relation" {
+			relations[f.Name] = f
+		}
+	}
+	// referenced rows by target sheet, loaded once per sheet
+	loaded := map[int]map[int]map[string]interface{}{}
+
+	for _, f := range schema {
+		if f.Type != "lookup" && f.Type != "rollup" {
+			continue
+		}
+		rel, ok := relations[f.Relation]
+		if !ok {
+			continue
+		}
+		target, err := relationTarget(db, sheet, rel)
+		if err != nil {
+			// the target sheet is gone: nothing to look up
+			for i := range rows {
+				rows[i].Data[f.Name] = lookupResult(f, rel, nil)
+			}
+			continue
+		}
+		if loaded[target] == nil {
+			loaded[target] = map[int]map[string]interface{}{}
+		}
+		refs := loaded[target]
+		var missing []int
+		for _, r := range rows {
+			for _, id := range relationIDs(r.Data[rel.Name]) {
+				if _, ok := refs[id]; !ok {
+					refs[id] = nil
+					missing = append(missing, id)
+				}
+			}
+		}
+		if err := loadReferencedRows(db, target, missing, refs); err != nil {
+			return err
+		}
+
+		for i := range rows {
+			var found []map[string]interface{}
+			for _, id := range relationIDs(rows[i].Data[rel.Name]) {
+				if data := refs[id]; data != nil {
+					found = append(found, data)
+				}
+			}
+			rows[i].Data[f.Name] = lookupResult(f, rel, found)
+		}
+	}
+	return nil
+}
+
+// loadReferencedRows reads the rows with ids of sheet into refs, with the row id
+// under "ID"; ids of rows that don't exist stay nil
+func loadReferencedRows(db sqlExecer, sheet int, ids []int, refs map[int]map[string]interface{}) error {
+	for start := 0; start < len(ids); start += lookupChunk {
+		chunk := ids[start:min(start+lookupChunk, len(ids))]
+		args := []interface{}{sheet}
+		marks := make([]string, len(chunk))
+		for i, id := range chunk {
+			marks[i] = "?"
+			args = append(args, id)
+		}
+		rows, err := queryRows(db, "SELECT id, data FROM entries WHERE sheet_id = ? AND id IN ("+strings.Join(marks, ", ")+")", args...)
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			refs[r.ID] = attachIDToDataMap(r.ID, r.Data)
+		}
+	}
+	return nil
+}
+
+// lookupResult computes the value of lookup or rollup field f from the referenced rows
+func lookupResult(f FieldDef, rel FieldDef, refs []map[string]interface{}) interface{} {
+	if f.Type == "rollup" && f.Aggregate == rollupCount {
+		return len(refs)
+	}
+	// list values are flattened, so a lookup of a []string field is one list
+	var vals []interface{}
+	for _, data := range refs {
+		v, ok := data[f.LookupField]
+		if !ok || v == nil {
+			continue
+		}
+		if list, ok := v.([]interface{}); ok {
+			vals = append(vals, list...)
+		} else {
+			vals = append(vals, v)
+		}
+	}
+
+	if f.Type == "lookup" {
+		if !rel.Multiple {
+			if len(refs) == 0 {
+				return nil
+			}
+			return refs[0][f.LookupField]
+		}
+		if vals == nil {
+			vals = []interface{}{}
+		}
+		return vals
+	}
+
+	switch f.Aggregate {
+	case rollupJoin:
+		parts := make([]string, len(vals))
+		for i, v := range vals {
+			parts[i] = lookupText(v, ", ")
+		}
+		return strings.Join(parts, ", ")
+	case rollupSum, rollupAvg:
+		sum, n := 0.0, 0
+		for _, v := range vals {
+			if x, ok := numericValue(v); ok {
+				sum += x
+				n++
+			}
+		}
+		if f.Aggregate == rollupSum {
+			return sum
+		}
+		if n == 0 {
+			return nil
+		}
+		return sum / float64(n)
+	case rollupMin, rollupMax:
+		var best interface{}
+		for _, v := range vals {
+			if best == nil || lookupLess(v, best) == (f.Aggregate == rollupMin) {
+				best = v
+			}
+		}
+		return best
+	}
+	return nil
+}
+
+// lookupLess orders numbers numerically and everything else as text, ignoring case
+func lookupLess(a, b interface{}) bool {
+	x, okA := numericValue(a)
+	y, okB := numericValue(b)
+	if okA && okB {
+		return x < y
+	}
+	return strings.ToLower(lookupText(a, "")) < strings.ToLower(lookupText(b, ""))
+}
+
+// lookupText renders a lookup or rollup value; list items are joined with sep
+func lookupText(v interface{}, sep string) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(t)
+	case []interface{}:
+		parts := make([]string, len(t))
+		for i, it := range t {
+			parts[i] = lookupText(it, sep)
+		}
+		return strings.Join(parts, sep)
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFillLookups(t *testing.T) {
+	db := openTestDB(t)
+	items := newTestSheet(t, db, "Items", []FieldDef{
+		{Name: "Name", Type: "string"},
+		{Name: "Price", Type: "float"},
+		{Name: "Tags", Type: "[]string"},
+	})
+	ids := map[string]int{}
+	for _, data := range []map[string]interface{}{
+		{"Name": "pen", "Price": 1.5, "Tags": []string{"blue"}},
+		{"Name": "book", "Price": 12.0, "Tags": []string{"paper", "new"}},
+		{"Name": "Mug", "Price": 4.0},
+		{"Name": "gone", "Price": 100.0, "Tags": []string{"gone"}},
+	} {
+		id, err := insertRow(db, items.ID, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[data["Name"].(string)] = int(id)
+	}
+
+	tests := []struct {
+		field FieldDef
+		want  string // the values of the three orders
+	}{
+		{FieldDef{Type: "lookup", Relation: "Item", LookupField: "Name"}, "pen | <nil> | <nil>"},
+		{FieldDef{Type: "lookup", Relation: "Items", LookupField: "Name"}, "[pen book Mug] | [] | [book]"},
+		{FieldDef{Type: "lookup", Relation: "Items", LookupField: "Tags"}, "[blue paper new] | [] | [paper new]"},
+		{FieldDef{Type: "lookup", Relation: "Lost", LookupField: "Name"}, "<nil> | <nil> | <nil>"},
+		{FieldDef{Type: "rollup", Relation: "Items", Aggregate: rollupCount}, "3 | 0 | 1"},
+		{FieldDef{Type: "rollup", Relation: "Item", Aggregate: rollupCount}, "1 | 0 | 0"},
+		{FieldDef{Type: "rollup", Relation: "Lost", Aggregate: rollupCount}, "0 | 0 | 0"},
+		{FieldDef{Type: "rollup", Relation: "Items", LookupField: "Price", Aggregate: rollupSum}, "17.5 | 0 | 12"},
+		{FieldDef{Type: "rollup", Relation: "Items", LookupField: "Price", Aggregate: rollupAvg}, "5.833333333333333 | <nil> | 12"},
+		{FieldDef{Type: "rollup", Relation: "Items", LookupField: "Price", Aggregate: rollupMin}, "1.5 | <nil> | 12"},
+		{FieldDef{Type: "rollup", Relation: "Items", LookupField: "Price", Aggregate: rollupMax}, "12 | <nil> | 12"},
+		{FieldDef{Type: "rollup", Relation: "Items", LookupField: "Name", Aggregate: rollupMin}, "book | <nil> | book"}, // text ignores case
+		{FieldDef{Type: "rollup", Relation: "Items", LookupField: "Name", Aggregate: rollupMax}, "pen | <nil> | book"},
+		{FieldDef{Type: "rollup", Relation: "Items", LookupField: "Name", Aggregate: rollupJoin}, "pen, book, Mug |  | book"},
+		{FieldDef{Type: "rollup", Relation: "Items", LookupField: "Tags", Aggregate: rollupJoin}, "blue, paper, new |  | paper, new"},
+	}
+
+	schema := []FieldDef{
+		{Name: "Item", Type: "relation", Target: "Items"},
+		{Name: "Items", Type: "relation", Target: "Items", Multiple: true},
+		{Name: "Lost", Type: "relation", Target: "Gone"},
+	}
+	for i, tt := range tests {
+		tt.field.Name = fmt.Sprintf("L%d", i+1)
+		schema = append(schema, tt.field)
+	}
+	orders := newTestSheet(t, db, "Orders", schema)
+	for _, data := range []map[string]interface{}{
+		{"Item": ids["pen"], "Items": []int{ids["pen"], ids["book"], ids["Mug"]}, "Lost": 1},
+		{},
+		{"Item": ids["gone"], "Items": []int{ids["gone"], ids["book"]}},
+	} {
+		if _, err := insertRow(db, orders.ID, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a relation to a row that no longer exists looks up nothing
+	if err := deleteRow(db, ids["gone"]); err != nil {
+		t.Fatal(err)
+	}
+
+	_, schema, err := storedSchema(db, orders.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := getAllRows(db, orders.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fillLookups(db, orders.ID, schema, rows); err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range tests {
+		name := fmt.Sprintf("L%d", i+1)
+		var got []string
+		for _, r := range rows {
+			got = append(got, fmt.Sprint(r.Data[name]))
+		}
+		if strings.Join(got, " | ") != tt.want {
+			t.Errorf("%s %s of %s.%s: got %s, want %s", tt.field.Type, tt.field.Aggregate, tt.field.Relation, tt.field.LookupField, strings.Join(got, " | "), tt.want)
+		}
+	}
+}
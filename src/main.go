@@ -1,15 +1,73 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 )
 
+// appID identifies the application to Fyne, which keys the preferences
+// (recent databases) by it
+const appID = "io.github.plusk0.spreadsheet"
+
+// appOptions are the file locations chosen on the command line or in the environment
+type appOptions struct {
+	DBPath     string
+	ConfigPath string
+	Schema     string // schemaStored, schemaFile or schemaMerge; "" asks in the GUI
+	Sheet      string // sheet the CLI works on; "" is the first
+}
+
+// parseAppOptions reads --db and --config from the front of args. A flag wins
+// over SPREADSHEET_DB / SPREADSHEET_CONFIG, which win over the files in the
+// working directory. The remaining arguments (a CLI command) are returned too.
+func parseAppOptions(args []string, errOut io.Writer) (appOptions, []string, error) {
+	opts := appOptions{DBPath: "./data.db", ConfigPath: "./config.json"}
+	if v := os.Getenv("SPREADSHEET_DB"); v != "" {
+		opts.DBPath = v
+	}
+	if v := os.Getenv("SPREADSHEET_CONFIG"); v != "" {
+		opts.ConfigPath = v
+	}
+
+	fs := flag.NewFlagSet("spreadsheet", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.Usage = func() { fmt.Fprint(errOut, cliUsage) }
+	fs.StringVar(&opts.DBPath, "db", opts.DBPath, "SQLite database file (env SPREADSHEET_DB)")
+	fs.StringVar(&opts.ConfigPath, "config", opts.ConfigPath, "schema file (env SPREADSHEET_CONFIG)")
+	fs.StringVar(&opts.Sheet, "sheet", "", "sheet used by commands (default: the first)")
+	fs.StringVar(&opts.Schema, "schema", "", "stored, file or merge: the schema used when the database's differs from the config")
+	if err := fs.Parse(args); err != nil {
+		return opts, nil, err
+	}
+	switch opts.Schema {
+	case "", schemaStored, schemaFile, schemaMerge:
+	default:
+		err := fmt.Errorf("invalid --schema %q (want stored, file or merge)", opts.Schema)
+		fmt.Fprintln(errOut, err)
+		return opts, nil, err
+	}
+	return opts, fs.Args(), nil
+}
+
 func main() {
+	opts, rest, err := parseAppOptions(os.Args[1:], os.Stderr)
+	if err != nil {
+		os.Exit(2)
+	}
+
+	// a command runs headless, without loading the GUI
+	if len(rest) > 0 {
+		os.Exit(runCLI(rest, opts, os.Stdout, os.Stderr))
+	}
+
 	// load schema from JSON config (preferred)
-	schema, err := loadConfig("./config.json")
+	schema, err := loadConfig(opts.ConfigPath)
 	if err != nil {
 		log.Fatal("failed to load config:", err)
 	}
@@ -17,17 +75,17 @@ func main() {
 	log.Printf("loaded schema: %+v", schema)
 
 	// Initialize the application
-	a := app.New()
-
-	// Set up the SQLite database
-	db := initializeDB()
-	defer db.Close()
+	a := app.NewWithID(appID)
 
 	// Create the main window
 	win := a.NewWindow("Simple Data Management App")
 
-	// Set the content of the window (UI) with schema
-	win.SetContent(createUI(win, db, schema))
+	// Set up the SQLite database and the window content
+	s := newAppSession(a, win, schema, opts.Schema)
+	defer s.close()
+	s.db = initializeDB(opts.DBPath)
+	s.dbPath = opts.DBPath
+	s.start()
 
 	// Set a default window size
 	win.Resize(fyne.NewSize(900, 640))
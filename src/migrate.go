@@ -0,0 +1,523 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// schema change actions for a field of the stored schema
+const (
+	migrateKeep    = "keep"    // leave the values alone (same name and type, or key kept as is)
+	migrateRemove  = "remove"  // delete the key from every row
+	migrateRename  = "rename"  // move the values to Target, converting them if the type differs
+	migrateConvert = "convert" // same name, new type: convert the values in place
+)
+
+// schemaChange is what happens to one field of the stored schema
+type schemaChange struct {
+	Field  FieldDef // field as stored
+	Action string
+	Target string // new field name for rename/convert
+}
+
+// schemaMigration turns rows of a sheet written with the stored schema into rows of the loaded one
+type schemaMigration struct {
+	Sheet       int
+	FromVersion int        // 0 when the sheet has no recorded schema yet
+	Old         []FieldDef // stored (or inferred) schema
+	New         []FieldDef // schema to migrate to: config.json or a merge with the stored one
+	Changes     []schemaChange
+	ListSep     string // separator for string <-> []string conversions
+}
+
+// schemaFailure is a value that could not be converted; the field gets its default value
+type schemaFailure struct {
+	RowID int
+	Field string
+	Value string
+	Err   string
+}
+
+func (f schemaFailure) String() string {
+	return fmt.Sprintf("row %d, %s=%q: %s", f.RowID, f.Field, f.Value, f.Err)
+}
+
+// schemaReport is the (dry-run) outcome of a migration
+type schemaReport struct {
+	RowsChanged int
+	Failures    []schemaFailure
+}
+
+// schema sources offered when the stored schema and config.json differ
+const (
+	schemaStored = "stored" // the schema saved in the database
+	schemaFile   = "file"   // config.json; the rows are migrated to it
+	schemaMerge  = "merge"  // config.json plus the stored fields it lacks
+)
+
+// storedSchema returns the schema of a sheet saved in the database and its version,
+// 0 and nil when there is none
+func storedSchema(db sqlExecer, sheet int) (int, []FieldDef, error) {
+	var version int
+	var fieldsStr string
+	err := db.QueryRow("SELECT version, fields FROM schema WHERE sheet_id = ?", sheet).Scan(&version, &fieldsStr)
+	if err == sql.ErrNoRows {
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	var fields []FieldDef
+	if err := json.Unmarshal([]byte(fieldsStr), &fields); err != nil {
+		return 0, nil, fmt.Errorf("schema version %d: %v", version, err)
+	}
+	if err := compileSchema(fields); err != nil {
+		return 0, nil, fmt.Errorf("schema version %d: %v", version, err)
+	}
+	return version, fields, nil
+}
+
+// recordSchemaVersion stores schema as the current schema of a sheet and in its version history
+func recordSchemaVersion(db sqlExecer, sheet, version int, schema []FieldDef) error {
+	js, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Format(time.RFC3339)
+	if _, err := db.Exec("INSERT INTO schema_versions (sheet_id, version, applied_at, fields) VALUES (?, ?, ?, ?)",
+		sheet, version, now, string(js)); err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT OR REPLACE INTO schema (sheet_id, version, fields, updated_at) VALUES (?, ?, ?, ?)",
+		sheet, version, string(js), now)
+	return err
+}
+
+// mergeSchemas returns the fields of preferred followed by the fields of other that
+// preferred doesn't have; a field in both keeps its definition from preferred
+func mergeSchemas(other, preferred []FieldDef) ([]FieldDef, error) {
+	names := map[string]bool{}
+	var out []FieldDef
+	for _, f := range preferred {
+		names[f.Name] = true
+		out = append(out, f)
+	}
+	for _, f := range other {
+		if !names[f.Name] {
+			out = append(out, f)
+		}
+	}
+	if err := compileSchema(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// resolveSchema returns the schema to work with in a sheet for the given source.
+// config.json describes the first sheet; the other sheets always use their stored
+// schema. A sheet without a stored schema uses the file's.
+func resolveSchema(db sqlExecer, sheet int, file []FieldDef, source string) ([]FieldDef, error) {
+	version, stored, err := storedSchema(db, sheet)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		return file, nil
+	}
+	first, err := isFirstSheet(db, sheet)
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		return stored, nil
+	}
+	switch source {
+	case schemaStored:
+		return stored, nil
+	case schemaMerge:
+		return mergeSchemas(stored, file)
+	case schemaFile, "":
+		return file, nil
+	}
+	return nil, fmt.Errorf("unknown schema source %q (want stored, file or merge)", source)
+}
+
+// schemaDiff lists how the fields of config.json differ from the stored ones
+func schemaDiff(stored, file []FieldDef) string {
+	var b strings.Builder
+	fileByName := map[string]FieldDef{}
+	for _, f := range file {
+		fileByName[f.Name] = f
+	}
+	storedNames := map[string]bool{}
+	for _, f := range stored {
+		storedNames[f.Name] = true
+		nf, ok := fileByName[f.Name]
+		switch {
+		case !ok:
+			fmt.Fprintf(&b, "  only in the database: %s (%s)\n", f.Name, f.Type)
+		case nf.Type != f.Type:
+			fmt.Fprintf(&b, "  %s: %s in the database, %s in config.json\n", f.Name, f.Type, nf.Type)
+		case !sameFieldDefs([]FieldDef{f}, []FieldDef{nf}):
+			fmt.Fprintf(&b, "  %s: options or rules differ\n", f.Name)
+		}
+	}
+	for _, f := range file {
+		if !storedNames[f.Name] {
+			fmt.Fprintf(&b, "  only in config.json: %s (%s)\n", f.Name, f.Type)
+		}
+	}
+	if b.Len() == 0 {
+		b.WriteString("  the fields are in a different order\n")
+	}
+	return b.String()
+}
+
+// inferStoredSchema guesses the schema of a sheet without a recorded version from the
+// keys found in its rows. A key keeps its loaded field definition when all its JSON values
+// fit that type; otherwise (and for unknown keys) the type is read from the values.
+func inferStoredSchema(db sqlExecer, sheet int, schema []FieldDef) ([]FieldDef, error) {
+	rows, err := db.Query("SELECT DISTINCT j.key, j.type FROM entries, json_each(entries.data) AS j WHERE entries.sheet_id = ? AND json_valid(entries.data)", sheet)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loaded := map[string]FieldDef{}
+	for _, f := range schema {
+		loaded[f.Name] = f
+	}
+	types := map[string]string{}
+	fits := map[string]bool{}
+	for rows.Next() {
+		var key, typ string
+		if err := rows.Scan(&key, &typ); err != nil {
+			return nil, err
+		}
+		if key == "_raw" || typ == "null" {
+			continue
+		}
+		t := "string"
+		switch typ {
+		case "array":
+			t = "[]string"
+		case "integer":
+			t = "int"
+		case "real":
+			t = "float"
+		case "true", "false":
+			t = "bool"
+		}
+		// mixed JSON types in one key are read as text
+		if prev, ok := types[key]; ok && prev != t {
+			t = "string"
+		}
+		types[key] = t
+		if f, ok := loaded[key]; ok {
+			if _, seen := fits[key]; !seen {
+				fits[key] = true
+			}
+			fits[key] = fits[key] && jsonTypeFits(f.Type, typ)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var out []FieldDef
+	for _, f := range schema {
+		if _, ok := types[f.Name]; !ok {
+			continue
+		}
+		if fits[f.Name] {
+			out = append(out, f)
+		} else {
+			out = append(out, FieldDef{Name: f.Name, Type: types[f.Name], Label: f.Name})
+		}
+	}
+	var extra []string
+	for k := range types {
+		if _, ok := loaded[k]; !ok {
+			extra = append(extra, k)
+		}
+	}
+	sort.Strings(extra)
+	for _, k := range extra {
+		out = append(out, FieldDef{Name: k, Type: types[k], Label: k})
+	}
+	return out, nil
+}
+
+// jsonTypeFits reports whether a JSON value type (as reported by json_each) is a valid
+// stored value of a field type
+func jsonTypeFits(fieldType, jsonType string) bool {
+	switch fieldType {
+	case "int", "float":
+		return jsonType == "integer" || jsonType == "real"
+	case "bool":
+		return jsonType == "true" || jsonType == "false"
+	case "[]string":
+		return jsonType == "array"
+	case "relation":
+		return jsonType == "integer" || jsonType == "array" || jsonType == "null"
+	case "formula", "lookup", "rollup":
+		return true
+	}
+	return jsonType == "text"
+}
+
+// planSchemaMigration compares the stored schema with the loaded one. It returns nil
+// when they are equal; otherwise a migration with suggested changes: same-name fields
+// with another type are converted, a removed field is renamed to an added field with
+// the same name ignoring case, and other removed fields are deleted.
+func planSchemaMigration(db sqlExecer, sheet int, schema []FieldDef) (*schemaMigration, error) {
+	version, old, err := storedSchema(db, sheet)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		if old, err = inferStoredSchema(db, sheet, schema); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &schemaMigration{Sheet: sheet, FromVersion: version, Old: old, New: schema, ListSep: ";"}
+	newByName := map[string]FieldDef{}
+	for _, f := range schema {
+		newByName[f.Name] = f
+	}
+	claimed := map[string]bool{}
+	for _, f := range old {
+		if nf, ok := newByName[f.Name]; ok {
+			claimed[f.Name] = true
+			if nf.Type != f.Type && !isComputedField(nf) && !isComputedField(f) {
+				m.Changes = append(m.Changes, schemaChange{Field: f, Action: migrateConvert, Target: f.Name})
+			} else {
+				m.Changes = append(m.Changes, schemaChange{Field: f, Action: migrateKeep, Target: f.Name})
+			}
+		}
+	}
+	for _, f := range old {
+		if _, ok := newByName[f.Name]; ok {
+			continue
+		}
+		c := schemaChange{Field: f, Action: migrateRemove}
+		for _, nf := range schema {
+			if !claimed[nf.Name] && !isComputedField(nf) && strings.EqualFold(nf.Name, f.Name) {
+				c.Action, c.Target = migrateRename, nf.Name
+				claimed[nf.Name] = true
+				break
+			}
+		}
+		m.Changes = append(m.Changes, c)
+	}
+
+	if version > 0 && sameFieldDefs(old, schema) {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// sameFieldDefs compares two schemas by their config representation
+func sameFieldDefs(a, b []FieldDef) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
+
+// NeedsData reports whether the migration rewrites rows (and so should be confirmed)
+func (m *schemaMigration) NeedsData() bool {
+	for _, c := range m.Changes {
+		if c.Action != migrateKeep {
+			return true
+		}
+	}
+	return false
+}
+
+// AddedFields returns the loaded fields that have no stored field of the same name;
+// these are the possible rename targets.
+func (m *schemaMigration) AddedFields() []FieldDef {
+	oldNames := map[string]bool{}
+	for _, f := range m.Old {
+		oldNames[f.Name] = true
+	}
+	var out []FieldDef
+	for _, f := range m.New {
+		if !oldNames[f.Name] && !isComputedField(f) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// validate rejects two stored fields renamed to the same field
+func (m *schemaMigration) validate() error {
+	targets := map[string]string{}
+	for _, c := range m.Changes {
+		if c.Action != migrateRename {
+			continue
+		}
+		if prev, ok := targets[c.Target]; ok {
+			return fmt.Errorf("%s and %s are both renamed to %s", prev, c.Field.Name, c.Target)
+		}
+		targets[c.Target] = c.Field.Name
+	}
+	return nil
+}
+
+// convertStoredValue converts a value of field from to the type of field to
+func convertStoredValue(from, to FieldDef, v interface{}, listSep string) (interface{}, error) {
+	if from.Type == to.Type {
+		return v, nil
+	}
+	if v == nil {
+		return defaultFieldValue(to), nil
+	}
+	// lists and text convert item-wise instead of through one text value
+	if to.Type == "[]string" {
+		if _, ok := v.([]interface{}); ok {
+			return toStringList(v), nil
+		}
+	}
+	text := formatFieldValue(from, v, listSep)
+	if from.Type == "string" || from.Type == "link" || from.Type == "enum" {
+		if s, ok := v.(string); ok {
+			text = s
+		}
+	}
+	return parseFieldValue(to, text, listSep)
+}
+
+// migrateRows rewrites every row according to m. With apply unset nothing is written.
+func migrateRows(db sqlExecer, m *schemaMigration, apply bool) (*schemaReport, error) {
+	rows, err := getAllRows(db, m.Sheet)
+	if err != nil {
+		return nil, err
+	}
+	newByName := map[string]FieldDef{}
+	for _, f := range m.New {
+		newByName[f.Name] = f
+	}
+
+	rep := &schemaReport{}
+	for _, r := range rows {
+		if _, raw := r.Data["_raw"]; raw {
+			continue
+		}
+		before, _ := json.Marshal(r.Data)
+		data := map[string]interface{}{}
+		for k, v := range r.Data {
+			data[k] = v
+		}
+		for _, c := range m.Changes {
+			v, present := r.Data[c.Field.Name]
+			switch c.Action {
+			case migrateRemove:
+				delete(data, c.Field.Name)
+			case migrateRename, migrateConvert:
+				if c.Action == migrateRename {
+					delete(data, c.Field.Name)
+				}
+				if !present {
+					continue
+				}
+				to := newByName[c.Target]
+				nv, err := convertStoredValue(c.Field, to, v, m.ListSep)
+				if err != nil {
+					rep.Failures = append(rep.Failures, schemaFailure{RowID: r.ID, Field: c.Field.Name, Value: formatFieldValue(c.Field, v, m.ListSep), Err: err.Error()})
+					nv = defaultFieldValue(to)
+				}
+				data[c.Target] = nv
+			}
+		}
+		after, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		if string(before) == string(after) {
+			continue
+		}
+		rep.RowsChanged++
+		if !apply {
+			continue
+		}
+		if _, err := db.Exec("UPDATE entries SET data = ? WHERE id = ?", string(after), r.ID); err != nil {
+			return nil, err
+		}
+		if err := recordRowChange(db, r.ID, r.Data, data, false); err != nil {
+			return nil, err
+		}
+	}
+	return rep, nil
+}
+
+// previewSchemaMigration reports what applySchemaMigration would do without writing anything
+func previewSchemaMigration(db sqlExecer, m *schemaMigration) (*schemaReport, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return migrateRows(db, m, false)
+}
+
+// applySchemaMigration rewrites all rows of the sheet and records the new schema version in
+// one transaction. The sheet's undo log is cleared: its snapshots hold rows of the old schema.
+func applySchemaMigration(db *sql.DB, m *schemaMigration) (*schemaReport, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rep, err := migrateRows(tx, m, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordSchemaVersion(tx, m.Sheet, m.FromVersion+1, m.New); err != nil {
+		return nil, err
+	}
+	if rep.RowsChanged > 0 {
+		if _, err := tx.Exec("DELETE FROM undo_log WHERE sheet_id = ?", m.Sheet); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// Summary describes the migration and its (dry-run) report for the confirmation dialog
+func (m *schemaMigration) Summary(rep *schemaReport) string {
+	var b strings.Builder
+	if m.FromVersion == 0 {
+		b.WriteString("No schema version recorded yet; the stored fields were read from the rows.\n")
+	} else {
+		fmt.Fprintf(&b, "Schema version %d -> %d\n", m.FromVersion, m.FromVersion+1)
+	}
+	for _, c := range m.Changes {
+		switch c.Action {
+		case migrateRemove:
+			fmt.Fprintf(&b, "  remove %s\n", c.Field.Name)
+		case migrateRename:
+			fmt.Fprintf(&b, "  rename %s -> %s\n", c.Field.Name, c.Target)
+		case migrateConvert:
+			fmt.Fprintf(&b, "  convert %s\n", c.Field.Name)
+		}
+	}
+	if rep != nil {
+		fmt.Fprintf(&b, "Rows changed: %d\n", rep.RowsChanged)
+		fmt.Fprintf(&b, "Conversion failures (set to default): %d\n", len(rep.Failures))
+		for _, f := range rep.Failures {
+			b.WriteString("  " + f.String() + "\n")
+		}
+	}
+	return b.String()
+}
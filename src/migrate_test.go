@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var migrateTestSchema = []FieldDef{
+	{Name: "ID", Type: "int"},
+	{Name: "Name", Type: "string"},
+	{Name: "Qty", Type: "string"},
+	{Name: "Tags", Type: "string"},
+	{Name: "Note", Type: "string"},
+	{Name: "Old", Type: "int"},
+}
+
+// migrateTestSheet adds a sheet with migrateTestSchema as its stored schema and two
+// rows, and plans the migration of the sheet to schema, compiled like a loaded one
+func migrateTestSheet(t *testing.T, schema []FieldDef) (*sql.DB, *schemaMigration, []int) {
+	t.Helper()
+	db := openTestDB(t)
+	s := newTestSheet(t, db, "Stock", migrateTestSchema)
+	var ids []int
+	for _, r := range []map[string]interface{}{
+		{"Name": "a", "Qty": "5", "Tags": "x;y", "Note": "n1", "Old": 1},
+		{"Name": "b", "Qty": "many", "Tags": "", "Note": "n2", "Old": 2},
+	} {
+		id, err := insertRow(db, s.ID, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, int(id))
+	}
+	schema = append([]FieldDef(nil), schema...)
+	if err := compileSchema(schema); err != nil {
+		t.Fatal(err)
+	}
+	m, err := planSchemaMigration(db, s.ID, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, m, ids
+}
+
+// migrateTestTarget renames Name (by case only), converts Qty and Tags, drops Note
+// and Old and adds Extra
+var migrateTestTarget = []FieldDef{
+	{Name: "ID", Type: "int"},
+	{Name: "name", Type: "string"},
+	{Name: "Qty", Type: "int"},
+	{Name: "Tags", Type: "[]string"},
+	{Name: "Extra", Type: "bool"},
+}
+
+func TestPlanSchemaMigration(t *testing.T) {
+	_, m, _ := migrateTestSheet(t, migrateTestTarget)
+	if m == nil {
+		t.Fatal("no migration planned")
+	}
+	var changes []string
+	for _, c := range m.Changes {
+		changes = append(changes, strings.TrimSpace(c.Field.Name+" "+c.Action+" "+c.Target))
+	}
+	want := []string{"ID keep ID", "Qty convert Qty", "Tags convert Tags", "Name rename name", "Note remove", "Old remove"}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Errorf("changes %q, want %q", changes, want)
+	}
+	if m.FromVersion != 1 {
+		t.Errorf("from version %d, want 1", m.FromVersion)
+	}
+	if !m.NeedsData() {
+		t.Error("NeedsData() = false for renamed, converted and dropped fields")
+	}
+	var added []string
+	for _, f := range m.AddedFields() {
+		added = append(added, f.Name)
+	}
+	if fmt.Sprint(added) != "[name Extra]" {
+		t.Errorf("added fields %v, want [name Extra]", added)
+	}
+}
+
+func TestPlanSchemaMigrationNoData(t *testing.T) {
+	labels := append([]FieldDef(nil), migrateTestSchema...)
+	labels[1].Label = "Full name"
+	rules := append([]FieldDef(nil), migrateTestSchema...)
+	rules[2].Required, rules[2].MaxLength = true, 10
+	tests := []struct {
+		name   string
+		schema []FieldDef
+	}{
+		{"label", labels},
+		{"validation rules", rules},
+		{"field order", []FieldDef{migrateTestSchema[5], migrateTestSchema[4], migrateTestSchema[3], migrateTestSchema[2], migrateTestSchema[1], migrateTestSchema[0]}},
+	}
+	for _, tt := range tests {
+		_, m, _ := migrateTestSheet(t, tt.schema)
+		if m == nil {
+			t.Errorf("%s: no migration planned, want a new schema version", tt.name)
+			continue
+		}
+		if m.NeedsData() {
+			t.Errorf("%s: NeedsData() = true, changes %v", tt.name, m.Changes)
+		}
+	}
+
+	if _, m, _ := migrateTestSheet(t, migrateTestSchema); m != nil {
+		t.Errorf("same schema: planned %v, want nil", m.Changes)
+	}
+}
+
+func TestApplySchemaMigration(t *testing.T) {
+	db, m, ids := migrateTestSheet(t, migrateTestTarget)
+	// an undo step holding a row of the old schema
+	if err := undoableSetCells(db, migrateTestSchema, "Edit Note", "", []cellChange{{ID: ids[0], Field: "Note", Value: "n1!"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	rowText := func(id int) string {
+		data, err := loadRowData(db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(data)
+	}
+	before := []string{rowText(ids[0]), rowText(ids[1])}
+
+	rep, err := previewSchemaMigration(db, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.RowsChanged != 2 || len(rep.Failures) != 1 {
+		t.Errorf("preview: %d rows changed, failures %v; want 2 and 1", rep.RowsChanged, rep.Failures)
+	}
+	if got := []string{rowText(ids[0]), rowText(ids[1])}; fmt.Sprint(got) != fmt.Sprint(before) {
+		t.Errorf("preview changed the rows: %v, was %v", got, before)
+	}
+
+	rep, err = applySchemaMigration(db, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.RowsChanged != 2 {
+		t.Errorf("apply: %d rows changed, want 2", rep.RowsChanged)
+	}
+	if len(rep.Failures) != 1 || rep.Failures[0].RowID != ids[1] || rep.Failures[0].Field != "Qty" || rep.Failures[0].Value != "many" {
+		t.Errorf("apply: failures %v, want Qty=many of row %d", rep.Failures, ids[1])
+	}
+	want := []string{
+		"map[Qty:5 Tags:[x y] name:a]",
+		"map[Qty:0 Tags:[] name:b]", // the failed value gets the default
+	}
+	if got := []string{rowText(ids[0]), rowText(ids[1])}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("rows %v, want %v", got, want)
+	}
+
+	version, stored, err := storedSchema(db, m.Sheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 || !sameFieldDefs(stored, m.New) {
+		t.Errorf("stored schema version %d %v, want 2 %v", version, stored, m.New)
+	}
+	if again, err := planSchemaMigration(db, m.Sheet, m.New); err != nil || again != nil {
+		t.Errorf("plan after apply: %v, %v; want nil", again, err)
+	}
+	var steps int
+	if err := db.QueryRow("SELECT COUNT(*) FROM undo_log WHERE sheet_id = ?", m.Sheet).Scan(&steps); err != nil {
+		t.Fatal(err)
+	}
+	if steps != 0 {
+		t.Errorf("%d undo steps kept, want the undo log cleared", steps)
+	}
+}
+
+func TestSchemaMigrationRenameClash(t *testing.T) {
+	db, m, _ := migrateTestSheet(t, migrateTestTarget)
+	for i, c := range m.Changes {
+		if c.Field.Name == "Note" {
+			m.Changes[i].Action, m.Changes[i].Target = migrateRename, "name"
+		}
+	}
+	if _, err := applySchemaMigration(db, m); err == nil || !strings.Contains(err.Error(), "both renamed to name") {
+		t.Errorf("two fields renamed to name: error %v", err)
+	}
+	if version, _, err := storedSchema(db, m.Sheet); err != nil || version != 1 {
+		t.Errorf("stored schema version %d, %v after a rejected migration, want 1", version, err)
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A "relation" field holds ids of rows in its Target sheet (the own sheet when
+// Target is empty): one id or null, or with Multiple a list of ids. Cells show the
+// Display field of the referenced rows. OnDelete decides what happens to the
+// referencing rows when a referenced row is deleted.
+
+// relation delete rules (FieldDef.OnDelete)
+const (
+	relationBlock   = "block"   // refuse to delete a referenced row (default)
+	relationSetNull = "setnull" // remove the id from the referencing rows
+	relationCascade = "cascade" // delete the referencing rows too
+)
+
+// relationChoiceLimit is how many rows the relation picker lists per search
+const relationChoiceLimit = 100
+
+// relationChoice is a row that a relation field can point to
+type relationChoice struct {
+	ID    int
+	Label string
+}
+
+// checkRelationField checks the OnDelete rule of a relation field
+func checkRelationField(f FieldDef) error {
+	switch f.OnDelete {
+	case "", relationBlock, relationSetNull, relationCascade:
+		return nil
+	}
+	return fmt.Errorf("field %s: OnDelete must be %s, %s or %s", f.Name, relationBlock, relationSetNull, relationCascade)
+}
+
+// relationIDs returns the row ids of a stored relation value (a number, a list
+// of numbers, or null)
+func relationIDs(v interface{}) []int {
+	switch t := v.(type) {
+	case int:
+		return []int{t}
+	case int64:
+		return []int{int(t)}
+	case float64:
+		return []int{int(t)}
+	case []int:
+		return t
+	case []interface{}:
+		var out []int
+		for _, it := range t {
+			out = append(out, relationIDs(it)...)
+		}
+		return out
+	}
+	return nil
+}
+
+// relationValue returns the stored value of a relation field holding ids
+func relationValue(f FieldDef, ids []int) interface{} {
+	if f.Multiple {
+		if ids == nil {
+			ids = []int{}
+		}
+		return ids
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return ids[0]
+}
+
+// parseRelationIDs reads ids separated by listSep; a leading "#" is allowed
+func parseRelationIDs(f FieldDef, s, listSep string) (interface{}, error) {
+	if listSep == "" {
+		listSep = ";"
+	}
+	var ids []int
+	for _, it := range strings.Split(s, listSep) {
+		it = strings.TrimPrefix(strings.TrimSpace(it), "#")
+		if it == "" {
+			continue
+		}
+		id, err := strconv.Atoi(it)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("%q is not a row id", it)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) > 1 && !f.Multiple {
+		return nil, fmt.Errorf("only one row allowed")
+	}
+	return relationValue(f, ids), nil
+}
+
+// relationTarget returns the id of the sheet a relation field of sheet points to
+func relationTarget(db sqlExecer, sheet int, f FieldDef) (int, error) {
+	if f.Target == "" {
+		return sheet, nil
+	}
+	s, err := sheetByName(db, f.Target)
+	if err != nil {
+		return 0, fmt.Errorf("field %s: %w", f.Name, err)
+	}
+	return s.ID, nil
+}
+
+// missingRelationID returns the first id of a relation value of f that is not a
+// row of the target sheet, 0 when they all exist
+func missingRelationID(db sqlExecer, target int, f FieldDef, value interface{}) (int, error) {
+	ids := relationIDs(value)
+	found, err := relationLabels(db, target, f, ids)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if _, ok := found[id]; !ok {
+			return id, nil
+		}
+	}
+	return 0, nil
+}
+
+// relationDisplayExpr returns the SQL expression of the label of a referenced row
+// of the entries table named table
+func relationDisplayExpr(f FieldDef, table string) (string, []interface{}) {
+	if f.Display == "" || strings.EqualFold(f.Display, "ID") {
+		return "'#' || " + table + ".id", nil
+	}
+	return "COALESCE(CAST(json_extract(" + table + ".data, ?) AS TEXT), '')", []interface{}{jsonPath(f.Display)}
+}
+
+// relationLabels returns the display labels of the rows with ids in the target
+// sheet. Ids of rows that no longer exist are missing from the result.
+func relationLabels(db sqlExecer, target int, f FieldDef, ids []int) (map[int]string, error) {
+	out := map[int]string{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	expr, args := relationDisplayExpr(f, "entries")
+	args = append(args, target)
+	marks := make([]string, len(ids))
+	for i, id := range ids {
+		marks[i] = "?"
+		args = append(args, id)
+	}
+	rows, err := db.Query("SELECT id, "+expr+" FROM entries WHERE sheet_id = ? AND id IN ("+strings.Join(marks, ", ")+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var label string
+		if err := rows.Scan(&id, &label); err != nil {
+			return nil, err
+		}
+		out[id] = label
+	}
+	return out, rows.Err()
+}
+
+// relationText renders ids with their labels; unknown ids show as missing
+func relationText(ids []int, labels map[int]string) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		if l, ok := labels[id]; ok {
+			parts[i] = l
+		} else {
+			parts[i] = fmt.Sprintf("#%d (missing)", id)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// relationChoices returns the rows of the target sheet whose label or id matches
+// query, ordered by label. The search runs in SQLite.
+func relationChoices(db sqlExecer, target int, f FieldDef, query string) ([]relationChoice, error) {
+	expr, args := relationDisplayExpr(f, "entries")
+	q := "SELECT id, " + expr + " AS label FROM entries WHERE sheet_id = ?"
+	args = append(args, target)
+	if query = strings.TrimSpace(query); query != "" {
+		cond, condArgs := relationDisplayExpr(f, "entries")
+		q += " AND (instr(lower(" + cond + "), lower(?)) > 0 OR CAST(id AS TEXT) = ?)"
+		args = append(append(args, condArgs...), query, strings.TrimPrefix(query, "#"))
+	}
+	q += " ORDER BY label COLLATE NOCASE, id LIMIT ?"
+	args = append(args, relationChoiceLimit)
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []relationChoice
+	for rows.Next() {
+		var c relationChoice
+		if err := rows.Scan(&c.ID, &c.Label); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// deleteBlockedError is returned when a row can't be deleted because another row
+// refers to it through a relation field with the block rule
+type deleteBlockedError struct {
+	ID    int    // row being deleted
+	Row   int    // row referring to it
+	Field string // label of the relation field
+}
+
+func (e *deleteBlockedError) Error() string {
+	return fmt.Sprintf("cannot delete row %d: row %d refers to it in %s", e.ID, e.Row, e.Field)
+}
+
+// relationRef is a relation field of a sheet pointing to a row being deleted
+type relationRef struct {
+	sheet  int
+	schema []FieldDef
+	field  FieldDef
+}
+
+// incomingRelations returns the relation fields of every sheet by the id of the sheet they point to
+func incomingRelations(db sqlExecer) (map[int][]relationRef, error) {
+	sheets, err := getSheets(db)
+	if err != nil {
+		return nil, err
+	}
+	out := map[int][]relationRef{}
+	for _, sh := range sheets {
+		_, schema, err := storedSchema(db, sh.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range schema {
+			if f.Type != "relation" {
+				continue
+			}
+			target, err := relationTarget(db, sh.ID, f)
+			if err != nil {
+				continue // the target sheet is gone, nothing can point to its rows
+			}
+			out[target] = append(out[target], relationRef{sheet: sh.ID, schema: schema, field: f})
+		}
+	}
+	return out, nil
+}
+
+// referencingRows returns the ids of the rows of ref's sheet whose relation field holds id
+func referencingRows(db sqlExecer, ref relationRef, id int) ([]int, error) {
+	rows, err := db.Query(`SELECT id FROM entries WHERE sheet_id = ? AND EXISTS
+		(SELECT 1 FROM json_each(entries.data, ?) WHERE json_each.value = ?) ORDER BY id`,
+		ref.sheet, jsonPath(ref.field.Name), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []int
+	for rows.Next() {
+		var rid int
+		if err := rows.Scan(&rid); err != nil {
+			return nil, err
+		}
+		out = append(out, rid)
+	}
+	return out, rows.Err()
+}
+
+// deleteRows deletes rows following the OnDelete rules of the relation fields
+// pointing to them: cascading rows are deleted too, set-null references are
+// cleared, and a blocking reference from a row that stays fails the whole delete.
+func deleteRows(tx *sql.Tx, rec *undoRecorder, ids []int) error {
+	incoming, err := incomingRelations(tx)
+	if err != nil {
+		return err
+	}
+
+	// a reference of row to the deleted row id through ref
+	type reference struct {
+		ref relationRef
+		row int
+		id  int
+	}
+	doomed := map[int]bool{}
+	var order []int
+	var clears, blocks []reference
+	queue := append([]int(nil), ids...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if doomed[id] {
+			continue
+		}
+		doomed[id] = true
+		order = append(order, id)
+
+		sheet, err := recordSheet(tx, "entries", id)
+		if err != nil {
+			return err
+		}
+		if sheet == 0 {
+			continue // no such row, nothing refers to it
+		}
+		for _, ref := range incoming[sheet] {
+			rows, err := referencingRows(tx, ref, id)
+			if err != nil {
+				return err
+			}
+			for _, row := range rows {
+				switch ref.field.OnDelete {
+				case relationCascade:
+					queue = append(queue, row)
+				case relationSetNull:
+					clears = append(clears, reference{ref, row, id})
+				default:
+					blocks = append(blocks, reference{ref, row, id})
+				}
+			}
+		}
+	}
+	// references from rows deleted anyway don't block or need clearing
+	for _, b := range blocks {
+		if !doomed[b.row] {
+			return &deleteBlockedError{ID: b.id, Row: b.row, Field: b.ref.field.Label}
+		}
+	}
+
+	sort.Slice(clears, func(i, j int) bool { return clears[i].row < clears[j].row })
+	for _, c := range clears {
+		if doomed[c.row] {
+			continue
+		}
+		if err := rec.touch("entries", c.row); err != nil {
+			return err
+		}
+		data, err := loadRowData(tx, c.row)
+		if err != nil {
+			return err
+		}
+		var keep []int
+		for _, id := range relationIDs(data[c.ref.field.Name]) {
+			if id != c.id {
+				keep = append(keep, id)
+			}
+		}
+		v := relationValue(c.ref.field, keep)
+		data[c.ref.field.Name] = v
+		values := computeFormulas(c.ref.schema, data)
+		values[c.ref.field.Name] = v
+		if err := updateFields(tx, c.row, values); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range order {
+		if err := rec.touch("entries", id); err != nil {
+			return err
+		}
+		if err := deleteRow(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameRelationTargets points the relation fields aimed at sheet oldName to
+// newName, recording a new schema version for every sheet that changes
+func renameRelationTargets(db sqlExecer, oldName, newName string) error {
+	sheets, err := getSheets(db)
+	if err != nil {
+		return err
+	}
+	for _, sh := range sheets {
+		version, schema, err := storedSchema(db, sh.ID)
+		if err != nil {
+			return err
+		}
+		changed := false
+		for i, f := range schema {
+			if f.Type == "relation" && strings.EqualFold(f.Target, oldName) {
+				schema[i].Target = newName
+				changed = true
+			}
+		}
+		if changed {
+			if err := recordSchemaVersion(db, sh.ID, version+1, schema); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
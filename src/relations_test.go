@@ -0,0 +1,319 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCheckRelationField(t *testing.T) {
+	for _, rule := range []string{"", relationBlock, relationSetNull, relationCascade} {
+		if err := checkRelationField(FieldDef{Name: "R", Type: "relation", OnDelete: rule}); err != nil {
+			t.Errorf("OnDelete %q: %v", rule, err)
+		}
+	}
+	if err := checkRelationField(FieldDef{Name: "R", Type: "relation", OnDelete: "restrict"}); err == nil {
+		t.Error(`OnDelete "restrict": no error`)
+	}
+}
+
+func TestRelationValueLimits(t *testing.T) {
+	two, three := 2, 3
+	single := FieldDef{Name: "R", Type: "relation"}
+	multiple := FieldDef{Name: "R", Type: "relation", Multiple: true}
+	bounded := FieldDef{Name: "R", Type: "relation", Multiple: true, MinItems: &two, MaxItems: &three}
+	tests := []struct {
+		f    FieldDef
+		in   interface{}
+		want string // the stored value, or the error
+	}{
+		{single, 3.0, "3"},
+		{single, []interface{}{3.0}, "3"},
+		{single, []interface{}{}, "<nil>"},
+		{single, []interface{}{3.0, 4.0}, "only one row allowed"},
+		{single, "#3", "3"},
+		{multiple, 3.0, "[3]"},
+		{multiple, []interface{}{3.0, 4.0}, "[3 4]"},
+		{multiple, "3; #4", "[3 4]"},
+		{multiple, []interface{}{}, "[]"},
+		{bounded, []interface{}{}, "[]"}, // only Required needs a row
+		{bounded, []interface{}{3.0}, "needs at least 2 rows"},
+		{bounded, []interface{}{3.0, 4.0, 5.0}, "[3 4 5]"},
+		{bounded, []interface{}{3.0, 4.0, 5.0, 6.0}, "allows at most 3 rows"},
+	}
+	for _, tt := range tests {
+		v, err := normalizeFieldValue(tt.f, tt.in)
+		if err == nil {
+			err = validateFieldValue(tt.f, v)
+		}
+		got := fmt.Sprint(v)
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("%v (multiple %v): got %s, want %s", tt.in, tt.f.Multiple, got, tt.want)
+		}
+	}
+}
+
+func TestIncomingRelations(t *testing.T) {
+	db := openTestDB(t)
+	a := newTestSheet(t, db, "A", []FieldDef{{Name: "Self", Type: "relation"}, {Name: "Also", Type: "relation", Target: "A"}})
+	b := newTestSheet(t, db, "B", []FieldDef{{Name: "ToA", Type: "relation", Target: "A"}, {Name: "Text", Type: "string"}})
+	newTestSheet(t, db, "C", []FieldDef{{Name: "ToGone", Type: "relation", Target: "Gone"}})
+
+	incoming, err := incomingRelations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for target, refs := range incoming {
+		for _, ref := range refs {
+			got = append(got, fmt.Sprintf("%d<-%d.%s", target, ref.sheet, ref.field.Name))
+		}
+	}
+	sort.Strings(got)
+	want := []string{
+		fmt.Sprintf("%d<-%d.Self", a.ID, a.ID),
+		fmt.Sprintf("%d<-%d.Also", a.ID, a.ID),
+		fmt.Sprintf("%d<-%d.ToA", a.ID, b.ID),
+	}
+	sort.Strings(want)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("incoming relations %v, want %v", got, want)
+	}
+}
+
+// relationTestDB holds People, whose Manager points into People (OnDelete self), and
+// Tasks, whose Owner and Helpers point to People (OnDelete rule):
+//
+//	ann, bob managed by ann, cid managed by bob
+//	plan owned by ann, helped by ann and bob; build owned by bob, helped by cid
+type relationTestDB struct {
+	db            *sql.DB
+	people, tasks Sheet
+	ids           map[string]int // rows by name or title
+}
+
+func newRelationTestDB(t *testing.T, rule, self string) *relationTestDB {
+	t.Helper()
+	db := openTestDB(t)
+	r := &relationTestDB{db: db, ids: map[string]int{}}
+	r.people = newTestSheet(t, db, "People", []FieldDef{
+		{Name: "Name", Type: "string"},
+		{Name: "Manager", Type: "relation", OnDelete: self},
+	})
+	r.tasks = newTestSheet(t, db, "Tasks", []FieldDef{
+		{Name: "Title", Type: "string"},
+		{Name: "Owner", Type: "relation", Target: "People", OnDelete: rule},
+		{Name: "Helpers", Type: "relation", Target: "People", Multiple: true, OnDelete: rule},
+	})
+	add := func(sheet int, key string, data map[string]interface{}) {
+		id, err := insertRow(db, sheet, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.ids[key] = int(id)
+	}
+	add(r.people.ID, "ann", map[string]interface{}{"Name": "ann"})
+	add(r.people.ID, "bob", map[string]interface{}{"Name": "bob", "Manager": r.ids["ann"]})
+	add(r.people.ID, "cid", map[string]interface{}{"Name": "cid", "Manager": r.ids["bob"]})
+	add(r.tasks.ID, "plan", map[string]interface{}{"Title": "plan", "Owner": r.ids["ann"], "Helpers": []int{r.ids["ann"], r.ids["bob"]}})
+	add(r.tasks.ID, "build", map[string]interface{}{"Title": "build", "Owner": r.ids["bob"], "Helpers": []int{r.ids["cid"]}})
+	return r
+}
+
+// state describes the rows of both sheets with the names they refer to, e.g.
+// "bob>ann" for bob managed by ann and "plan:ann[ann bob]" for a task
+func (r *relationTestDB) state(t *testing.T) string {
+	t.Helper()
+	names := map[int]string{}
+	for k, id := range r.ids {
+		names[id] = k
+	}
+	name := func(ids []int) string {
+		var out []string
+		for _, id := range ids {
+			out = append(out, names[id])
+		}
+		return strings.Join(out, " ")
+	}
+	var parts []string
+	people, err := getAllRows(r.db, r.people.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range people {
+		parts = append(parts, fmt.Sprintf("%s>%s", p.Data["Name"], name(relationIDs(p.Data["Manager"]))))
+	}
+	tasks, err := getAllRows(r.db, r.tasks.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range tasks {
+		parts = append(parts, fmt.Sprintf("%s:%s[%s]", p.Data["Title"], name(relationIDs(p.Data["Owner"])), name(relationIDs(p.Data["Helpers"]))))
+	}
+	return strings.Join(parts, ", ")
+}
+
+const relationTestStart = "ann>, bob>ann, cid>bob, plan:ann[ann bob], build:bob[cid]"
+
+func TestDeleteRows(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule, self string   // OnDelete of Tasks' fields and of Manager
+		del        []string // rows to delete
+		blocked    string   // the row of the blocking reference, "" when the delete works
+		want       string
+	}{
+		{"block by default", "", relationSetNull, []string{"ann"}, "plan", relationTestStart},
+		{"block", relationBlock, relationSetNull, []string{"ann"}, "plan", relationTestStart},
+		{"self block", relationSetNull, relationBlock, []string{"ann"}, "bob", relationTestStart},
+		{"unreferenced row", relationBlock, relationBlock, []string{"plan"}, "", "ann>, bob>ann, cid>bob, build:bob[cid]"},
+		{"missing row", relationBlock, relationBlock, []string{"plan", "plan", "nobody"}, "", "ann>, bob>ann, cid>bob, build:bob[cid]"},
+		{"set null", relationSetNull, relationSetNull, []string{"ann"}, "",
+			"bob>, cid>bob, plan:[bob], build:bob[cid]"},
+		{"cascade", relationCascade, relationSetNull, []string{"ann"}, "",
+			"bob>, cid>bob, build:bob[cid]"},
+		{"self cascade", relationSetNull, relationCascade, []string{"ann"}, "",
+			"plan:[], build:[]"},
+		{"self cascade blocked by another sheet", relationBlock, relationCascade, []string{"cid"}, "build", relationTestStart},
+		{"cascade across sheets", relationCascade, relationCascade, []string{"ann"}, "", ""},
+		{"references among deleted rows don't block", relationSetNull, relationBlock, []string{"ann", "bob", "cid"}, "",
+			"plan:[], build:[]"},
+		{"a reference from a staying row blocks", relationSetNull, relationBlock, []string{"ann", "bob"}, "cid", relationTestStart},
+	}
+	for _, tt := range tests {
+		r := newRelationTestDB(t, tt.rule, tt.self)
+		var ids []int
+		for _, k := range tt.del {
+			id, ok := r.ids[k]
+			if !ok {
+				id = 9999
+			}
+			ids = append(ids, id)
+		}
+		tx, err := r.db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = deleteRows(tx, nil, ids)
+		var blocked *deleteBlockedError
+		switch {
+		case tt.blocked == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.blocked != "" && (!errors.As(err, &blocked) || blocked.Row != r.ids[tt.blocked]):
+			t.Errorf("%s: error %v, want a reference of %s blocking", tt.name, err, tt.blocked)
+		}
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.state(t); got != tt.want {
+			t.Errorf("%s: rows\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+		if tt.blocked == "" && tt.del[0] == "ann" {
+			// the deletion is in the row history
+			revs, err := getRowRevisions(r.db, r.ids["ann"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(revs) == 0 || revs[0].Field != "" {
+				t.Errorf("%s: revisions of the deleted row %v, want the deletion first", tt.name, revs)
+			}
+		}
+	}
+}
+
+func TestReplaceImportRelations(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		gone     string // a task deleted before the import
+		withTask bool   // the file replaces Tasks too
+		keep     []string
+		want     string
+	}{
+		{"blocking references keep their rows", relationBlock, "", false, []string{"ann", "bob", "cid"},
+			"ann>, bob>ann, cid>bob, new>, plan:ann[ann bob], build:bob[cid]"},
+		{"unreferenced rows are replaced", relationBlock, "build", false, []string{"ann", "bob"},
+			"ann>, bob>ann, new>, plan:ann[ann bob]"},
+		{"set null", relationSetNull, "", false, nil,
+			"new>, plan:[], build:[]"},
+		{"cascade", relationCascade, "", false, nil,
+			"new>"},
+		{"sheets replaced together don't block", relationBlock, "", true, nil,
+			"new>, task:new[new]"},
+	}
+	for _, tt := range tests {
+		r := newRelationTestDB(t, tt.rule, relationSetNull)
+		r.ids["new"] = 0 // named below, once it exists
+		if tt.gone != "" {
+			if err := deleteRow(r.db, r.ids[tt.gone]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ins := []*jsonImport{{Name: "People", Entries: []map[string]interface{}{{"ID": 1000.0, "Name": "new"}}}}
+		if tt.withTask {
+			ins = append(ins, &jsonImport{Name: "Tasks", Entries: []map[string]interface{}{{"Title": "task", "Owner": 1000.0, "Helpers": []interface{}{1000.0}}}})
+		}
+		_, schema, err := storedSchema(r.db, r.people.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plans, err := planWorkbookImport(r.db, r.people.ID, schema, ins, importReplace, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keep []string
+		for _, id := range plans[0].Keep {
+			for k, kid := range r.ids {
+				if kid == id {
+					keep = append(keep, k)
+				}
+			}
+		}
+		sort.Strings(keep)
+		if fmt.Sprint(keep) != fmt.Sprint(tt.keep) || plans[0].Deletes != 3-len(tt.keep) || len(plans[0].Conflicts) != len(tt.keep) {
+			t.Errorf("%s: keeps %v, deletes %d, conflicts %v; want to keep %v", tt.name, keep, plans[0].Deletes, plans[0].Conflicts, tt.keep)
+		}
+		if err := applyImport(r.db, plans...); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		people, err := getAllRows(r.db, r.people.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range people {
+			if p.Data["Name"] == "new" {
+				r.ids["new"] = p.ID
+			}
+		}
+		if tt.withTask {
+			tasks, _ := getAllRows(r.db, r.tasks.ID)
+			r.ids["task"] = tasks[0].ID
+		}
+		if got := r.state(t); got != tt.want {
+			t.Errorf("%s: rows\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+		// replaced rows are deleted with a revision
+		if len(tt.keep) == 0 {
+			revs, err := getRowRevisions(r.db, r.ids["cid"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(revs) == 0 || revs[0].Field != "" {
+				t.Errorf("%s: revisions of a replaced row %v, want the deletion first", tt.name, revs)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+// Find and replace rewrites text in the string and []string cells of a sheet.
+// The changes are computed first (planReplace) so they can be previewed, then
+// written in one transaction that is one undo step (applyReplace).
+
+// replace scopes (replaceOptions.Scope)
+const (
+	replaceScopeView    = "view"    // rows and visible columns of the current view
+	replaceScopeColumns = "columns" // the chosen Fields, in every row
+	replaceScopeAll     = "all"     // every text field of every row
+)
+
+// replaceOptions describes one find and replace
+type replaceOptions struct {
+	Find    string
+	Replace string // with Regex, $1 or ${name} insert capture groups
+	Scope   string
+	Fields  []string // field names for replaceScopeColumns
+
+	CaseSensitive bool
+	WholeWord     bool
+	Regex         bool
+}
+
+// replaceChange is the new value of one cell
+type replaceChange struct {
+	ID     int
+	Field  string
+	Before interface{}
+	After  interface{}
+}
+
+// isReplaceable reports whether find and replace works on the values of f
+func isReplaceable(f FieldDef) bool {
+	return f.Type == "string" || f.Type == "[]string"
+}
+
+// compileReplace builds the regular expression of the Find text of o
+func compileReplace(o replaceOptions) (*regexp.Regexp, error) {
+	if o.Find == "" {
+		return nil, fmt.Errorf("nothing to find")
+	}
+	expr := o.Find
+	if o.Regex {
+		// report errors in the user's expression, not the wrapped one
+		if _, err := regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %w", err)
+		}
+	} else {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if o.WholeWord {
+		expr = `\b(?:` + expr + `)\b`
+	}
+	if !o.CaseSensitive {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// replaceText applies the replacement of o to s
+func replaceText(re *regexp.Regexp, o replaceOptions, s string) string {
+	if o.Regex {
+		return re.ReplaceAllString(s, o.Replace)
+	}
+	return re.ReplaceAllLiteralString(s, o.Replace)
+}
+
+// planReplace returns the cells of sheet that the replacement changes, in row and
+// field order. v is the current view, used by replaceScopeView.
+func planReplace(db sqlExecer, sheet int, schema []FieldDef, v View, o replaceOptions) ([]replaceChange, error) {
+	re, err := compileReplace(o)
+	if err != nil {
+		return nil, err
+	}
+
+	in := map[string]bool{}
+	rowsOf := View{}
+	switch o.Scope {
+	case replaceScopeView:
+		rowsOf = v
+		for _, name := range v.Columns {
+			in[name] = true
+		}
+		if len(v.Columns) == 0 {
+			for _, f := range schema {
+				in[f.Name] = true
+			}
+		}
+	case replaceScopeColumns:
+		if len(o.Fields) == 0 {
+			return nil, fmt.Errorf("no columns selected")
+		}
+		for _, name := range o.Fields {
+			in[name] = true
+		}
+	case replaceScopeAll:
+		for _, f := range schema {
+			in[f.Name] = true
+		}
+	default:
+		return nil, fmt.Errorf("unknown scope %q", o.Scope)
+	}
+	var fields []FieldDef
+	for _, f := range schema {
+		if in[f.Name] && isReplaceable(f) {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no text columns in scope")
+	}
+
+	rows, err := getViewRows(db, sheet, schema, rowsOf)
+	if err != nil {
+		return nil, err
+	}
+	var out []replaceChange
+	for _, r := range rows {
+		for _, f := range fields {
+			before := r.Data[f.Name]
+			var after interface{}
+			if f.Type == "[]string" {
+				items := toStringList(before)
+				if len(items) == 0 {
+					continue
+				}
+				list := make([]string, len(items))
+				for i, it := range items {
+					list[i] = replaceText(re, o, it)
+				}
+				after = list
+				before = items
+			} else {
+				s, ok := before.(string)
+				if !ok {
+					continue
+				}
+				after = replaceText(re, o, s)
+			}
+			if !reflect.DeepEqual(before, after) {
+				out = append(out, replaceChange{ID: r.ID, Field: f.Name, Before: before, After: after})
+			}
+		}
+	}
+	return out, nil
+}
+
+// applyReplace writes the planned changes in one transaction recorded as one undo step
+func applyReplace(db *sql.DB, schema []FieldDef, find string, changes []replaceChange) error {
+	cells := make([]cellChange, len(changes))
+	for i, c := range changes {
+		cells[i] = cellChange{ID: c.ID, Field: c.Field, Value: c.After}
+	}
+	return undoableSetCells(db, schema, fmt.Sprintf("Replace %q", find), "", cells)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"os/user"
+	"sort"
+	"time"
+)
+
+// Revision is one recorded change of a single field of a row.
+// OldValue/NewValue hold the JSON encoded values; "" means the field was absent.
+// A revision with an empty Field marks the deletion of the whole row.
+type Revision struct {
+	ID        int
+	RowID     int
+	ChangedAt time.Time
+	Field     string
+	OldValue  string
+	NewValue  string
+	User      string
+}
+
+// revisionCoalesceDelay merges consecutive edits of the same field by the same user (typing)
+const revisionCoalesceDelay = 5 * time.Second
+
+var revisionUser string
+
+// currentOSUser returns the login name recorded with revisions
+func currentOSUser() string {
+	if revisionUser != "" {
+		return revisionUser
+	}
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		revisionUser = u.Username
+	} else if n := os.Getenv("USER"); n != "" {
+		revisionUser = n
+	} else if n := os.Getenv("USERNAME"); n != "" {
+		revisionUser = n
+	} else {
+		revisionUser = "unknown"
+	}
+	return revisionUser
+}
+
+// jsonValue encodes a field value for the revisions table; absent values are ""
+func jsonValue(v interface{}, present bool) string {
+	if !present {
+		return ""
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(js)
+}
+
+// recordRowChange stores one revision per field that differs between before and after.
+// before == nil means the row was created. With coalesce set, a change to a field whose
+// latest revision is by the same user and recent updates that revision instead.
+func recordRowChange(db sqlExecer, id int, before, after map[string]interface{}, coalesce bool) error {
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	who := currentOSUser()
+	for _, k := range names {
+		ov, hadOld := before[k]
+		nv, hasNew := after[k]
+		oldJS := jsonValue(ov, hadOld)
+		newJS := jsonValue(nv, hasNew)
+		if oldJS == newJS {
+			continue
+		}
+
+		if coalesce {
+			var revID int
+			var at string
+			var revUser string
+			err := db.QueryRow("SELECT id, changed_at, user FROM revisions WHERE row_id = ? AND field = ? ORDER BY id DESC LIMIT 1", id, k).Scan(&revID, &at, &revUser)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			if err == nil && revUser == who {
+				if t, perr := time.Parse(time.RFC3339Nano, at); perr == nil && now.Sub(t) <= revisionCoalesceDelay {
+					if _, err := db.Exec("UPDATE revisions SET new_value = ?, changed_at = ? WHERE id = ?", newJS, now.Format(time.RFC3339Nano), revID); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+		}
+
+		if _, err := db.Exec("INSERT INTO revisions (row_id, changed_at, field, old_value, new_value, user) VALUES (?, ?, ?, ?, ?, ?)",
+			id, now.Format(time.RFC3339Nano), k, oldJS, newJS, who); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordRowDeleted stores a row deletion; the old value is the full JSON blob
+func recordRowDeleted(db sqlExecer, id int, dataStr string) error {
+	_, err := db.Exec("INSERT INTO revisions (row_id, changed_at, field, old_value, new_value, user) VALUES (?, ?, '', ?, '', ?)",
+		id, time.Now().Format(time.RFC3339Nano), dataStr, currentOSUser())
+	return err
+}
+
+// getRowRevisions returns the revisions of a row, newest first
+func getRowRevisions(db sqlExecer, id int) ([]Revision, error) {
+	rows, err := db.Query("SELECT id, row_id, changed_at, field, old_value, new_value, user FROM revisions WHERE row_id = ? ORDER BY id DESC", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Revision
+	for rows.Next() {
+		var r Revision
+		var at string
+		if err := rows.Scan(&r.ID, &r.RowID, &at, &r.Field, &r.OldValue, &r.NewValue, &r.User); err != nil {
+			return nil, err
+		}
+		r.ChangedAt, _ = time.Parse(time.RFC3339Nano, at)
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// rowStateAtRevision reconstructs the row data right after revision revID by
+// walking back all newer field changes from the current data.
+func rowStateAtRevision(db sqlExecer, id int, revID int) (map[string]interface{}, error) {
+	var dataStr string
+	if err := db.QueryRow("SELECT data FROM entries WHERE id = ?", id).Scan(&dataStr); err != nil {
+		return nil, err
+	}
+	state := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(dataStr), &state); err != nil {
+		state = map[string]interface{}{}
+	}
+
+	revs, err := getRowRevisions(db, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range revs {
+		if r.ID <= revID {
+			break
+		}
+		if r.Field == "" {
+			continue
+		}
+		if r.OldValue == "" {
+			delete(state, r.Field)
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(r.OldValue), &v); err != nil {
+			return nil, err
+		}
+		state[r.Field] = v
+	}
+	return state, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The search box (and ?q= of the API) narrows a view to the rows matching every
+// term of the search text:
+//
+//	word      a word, ignoring case
+//	pre*      a word starting with "pre"
+//	"a b"     the phrase "a b"
+//	Name:foo  a term limited to one field (by name or label); Name:"a b" and Name:pre* work too
+//
+// With the full-text index (see fts.go) terms match whole words and rows are ranked
+// by relevance; without it every term matches as a substring. Lists match per item
+// and relation fields match the labels of the referenced rows. Lookup and rollup
+// values are not stored and can't be searched.
+
+// searchTerm is one term of a search
+type searchTerm struct {
+	Field  string // field name the term is limited to, "" for any visible field
+	Text   string
+	Prefix bool // pre*
+	Phrase bool // "a b"
+}
+
+// parseSearch splits search text into terms. A "Field:" prefix only counts when it
+// names a field of schema, so text like "http://x" stays a plain term.
+func parseSearch(schema []FieldDef, s string) []searchTerm {
+	var terms []searchTerm
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		if unicode.IsSpace(rs[i]) {
+			i++
+			continue
+		}
+		var t searchTerm
+		name := i
+		for name < len(rs) && rs[name] != ':' && rs[name] != '"' && !unicode.IsSpace(rs[name]) {
+			name++
+		}
+		if name > i && name < len(rs) && rs[name] == ':' {
+			if f, ok := searchField(schema, string(rs[i:name])); ok {
+				t.Field = f.Name
+				i = name + 1
+			}
+		}
+		if i < len(rs) && rs[i] == '"' {
+			end := i + 1
+			for end < len(rs) && rs[end] != '"' {
+				end++
+			}
+			t.Text, t.Phrase = string(rs[i+1:end]), true
+			i = end + 1
+		} else {
+			end := i
+			for end < len(rs) && !unicode.IsSpace(rs[end]) {
+				end++
+			}
+			t.Text = string(rs[i:end])
+			i = end
+		}
+		if i < len(rs) && rs[i] == '*' {
+			t.Prefix = true
+			i++
+		} else if !t.Phrase && strings.HasSuffix(t.Text, "*") {
+			t.Text, t.Prefix = strings.TrimSuffix(t.Text, "*"), true
+		}
+		if t.Text = strings.TrimSpace(t.Text); t.Text != "" {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// searchField finds a field by name or label, ignoring case
+func searchField(schema []FieldDef, name string) (FieldDef, bool) {
+	for _, f := range schema {
+		if strings.EqualFold(f.Name, name) || strings.EqualFold(f.Label, name) {
+			return f, true
+		}
+	}
+	return FieldDef{}, false
+}
+
+// searchFields returns the fields of v that the search looks at
+func searchFields(schema []FieldDef, v View) []FieldDef {
+	visible := map[string]bool{}
+	for _, c := range v.Columns {
+		visible[c] = true
+	}
+	var out []FieldDef
+	for _, f := range schema {
+		if len(v.Columns) > 0 && !visible[f.Name] {
+			continue
+		}
+		switch f.Type {
+		case "bool", "lookup", "rollup":
+			continue
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// ftsQuery is the FTS5 query of a term: the text as a quoted string (so punctuation
+// and keywords like OR are plain text), with * for a prefix
+func (t searchTerm) ftsQuery() string {
+	q := `"` + strings.ReplaceAll(t.Text, `"`, `""`) + `"`
+	if t.Prefix {
+		q += "*"
+	}
+	return q
+}
+
+// searchCondition returns the SQL condition matching the rows that contain every
+// term in a search field of v, and its bound args. With the full-text index it also
+// returns the rank expression ordering the rows by relevance, and its args.
+func searchCondition(schema []FieldDef, v View, terms []searchTerm) (string, []interface{}, string, []interface{}) {
+	fields := searchFields(schema, v)
+	var conds, ranked []string
+	var args []interface{}
+	for _, t := range terms {
+		var inFields []FieldDef
+		for _, f := range fields {
+			if t.Field == "" || t.Field == f.Name {
+				inFields = append(inFields, f)
+			}
+		}
+
+		var alts []string
+		var stored []interface{} // names of the fields the full-text index covers
+		for _, f := range inFields {
+			switch {
+			case f.Type == "int" && strings.EqualFold(f.Name, "ID"):
+				alts = append(alts, "instr(CAST(entries.id AS TEXT), ?) > 0")
+				args = append(args, t.Text)
+			case f.Type == "relation":
+				// the label of a referenced row, whatever sheet it is in
+				label, labelArgs := relationDisplayExpr(f, "ref")
+				alts = append(alts, "EXISTS (SELECT 1 FROM entries ref WHERE ref.id IN (SELECT value FROM json_each(entries.data, ?)) AND instr(lower("+label+"), lower(?)) > 0)")
+				args = append(append(append(args, jsonPath(f.Name)), labelArgs...), t.Text)
+			case ftsEnabled:
+				stored = append(stored, f.Name)
+			default:
+				// json_each yields the value itself for scalars and each item for lists
+				alts = append(alts, "EXISTS (SELECT 1 FROM json_each(entries.data, ?) WHERE instr(lower(CAST(json_each.value AS TEXT)), lower(?)) > 0)")
+				args = append(args, jsonPath(f.Name), t.Text)
+			}
+		}
+		if len(stored) > 0 {
+			alts = append(alts, ftsMatchRows(len(stored)))
+			args = append(append(args, t.ftsQuery()), stored...)
+			ranked = append(ranked, t.ftsQuery())
+		}
+		if len(alts) == 0 {
+			alts = []string{"0"} // nothing to search in
+		}
+		conds = append(conds, "("+strings.Join(alts, " OR ")+")")
+	}
+
+	if len(ranked) == 0 {
+		return strings.Join(conds, " AND "), args, "", nil
+	}
+	return strings.Join(conds, " AND "), args, ftsRank(), []interface{}{strings.Join(ranked, " OR ")}
+}
+
+// cellMatches reports whether a cell contains one of the search terms, the way
+// searchCondition matches it. labels are the relation labels of f.
+func cellMatches(f FieldDef, r Row, labels map[int]string, terms []searchTerm) bool {
+	var items []string
+	words := ftsEnabled // ids and relation labels match as substrings, as in the query
+	switch {
+	case f.Type == "bool" || f.Type == "lookup" || f.Type == "rollup":
+		return false
+	case f.Type == "int" && strings.EqualFold(f.Name, "ID"):
+		items, words = []string{intText(r.ID)}, false
+	case f.Type == "relation":
+		for _, id := range relationIDs(r.Data[f.Name]) {
+			items = append(items, labels[id])
+		}
+		words = false
+	case f.Type == "[]string":
+		items = toStringList(r.Data[f.Name])
+	default:
+		items = []string{formatFieldValue(f, r.Data[f.Name], "")}
+	}
+	for _, t := range terms {
+		if t.Field != "" && t.Field != f.Name {
+			continue
+		}
+		for _, it := range items {
+			if t.matches(it, words) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matches reports whether text contains the term: with words set like the
+// full-text index does (whole words, pre* a word prefix, a phrase its words in
+// order), otherwise as a substring ignoring case
+func (t searchTerm) matches(text string, words bool) bool {
+	if !words {
+		return strings.Contains(strings.ToLower(text), strings.ToLower(t.Text))
+	}
+	want, have := searchWords(t.Text), searchWords(text)
+	if len(want) == 0 {
+		return false
+	}
+next:
+	for i := 0; i+len(want) <= len(have); i++ {
+		for j, w := range want {
+			if j == len(want)-1 && t.Prefix {
+				if !strings.HasPrefix(have[i+j], w) {
+					continue next
+				}
+			} else if have[i+j] != w {
+				continue next
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// searchWords splits text into words the way the index tokenizer does: runs of
+// letters and digits, lower-cased and without diacritics
+func searchWords(text string) []string {
+	var words []string
+	var w strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(text)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// a diacritic of the previous letter
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			w.WriteRune(r)
+		case w.Len() > 0:
+			words = append(words, w.String())
+			w.Reset()
+		}
+	}
+	if w.Len() > 0 {
+		words = append(words, w.String())
+	}
+	return words
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+var searchTestSchema = []FieldDef{
+	{Name: "Name", Type: "string", Label: "Full name"},
+	{Name: "Notes", Type: "string"},
+	{Name: "Tags", Type: "[]string"},
+	{Name: "Done", Type: "bool"},
+	{Name: "Boss", Type: "relation", Display: "Name"},
+}
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", "[]"},
+		{"apple", "[{ apple false false}]"},
+		{"  apple   PIE ", "[{ apple false false} { PIE false false}]"},
+		{"app*", "[{ app true false}]"},
+		{`"apple pie"`, "[{ apple pie false true}]"},
+		{`"apple pi"*`, "[{ apple pi true true}]"},
+		{`"apple pie`, "[{ apple pie false true}]"}, // an unclosed quote runs to the end
+		{"Notes:tea", "[{Notes tea false false}]"},
+		{"notes:tea*", "[{Notes tea true false}]"},
+		{"full name:lee", "[{ full false false} {Name lee false false}]"}, // a label with a space doesn't work as a prefix
+		{`Full name:"a b"`, "[{ Full false false} {Name a b false true}]"},
+		{"Name:", "[]"},
+		{"http://x", "[{ http://x false false}]"}, // not a field
+		{`Tags:"x y" z`, "[{Tags x y false true} { z false false}]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(parseSearch(searchTestSchema, tt.in)); got != tt.want {
+			t.Errorf("parseSearch(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCellMatches(t *testing.T) {
+	f := FieldDef{Name: "Notes", Type: "string"}
+	tests := []struct {
+		query, text      string
+		words, substring bool // the result with and without the full-text index
+	}{
+		{"apple", "Red Apple pie", true, true},
+		{"app", "Red Apple pie", false, true},
+		{"app*", "Red Apple pie", true, true},
+		{`"apple pie"`, "Red Apple pie", true, true},
+		{`"pie apple"`, "Red Apple pie", false, false},
+		{`"red apple"`, "red, apple!", true, false}, // punctuation separates words only
+		{"cafe", "Café au lait", true, false},
+		{"Notes:pie", "Red Apple pie", true, true},
+		{"Name:pie", "Red Apple pie", false, false},
+	}
+	defer func(on bool) { ftsEnabled = on }(ftsEnabled)
+	for _, tt := range tests {
+		terms := parseSearch(searchTestSchema, tt.query)
+		r := Row{ID: 1, Data: map[string]interface{}{"Notes": tt.text}}
+		for _, fts := range []bool{true, false} {
+			ftsEnabled = fts
+			want := tt.substring
+			if fts {
+				want = tt.words
+			}
+			if got := cellMatches(f, r, nil, terms); got != want {
+				t.Errorf("%s in %q (full-text %v): %v, want %v", tt.query, tt.text, fts, got, want)
+			}
+		}
+	}
+
+	// relation labels and ids match as substrings either way
+	ftsEnabled = true
+	boss := searchTestSchema[4]
+	r := Row{ID: 12, Data: map[string]interface{}{"Boss": 3}}
+	if !cellMatches(boss, r, map[int]string{3: "Ann Lee"}, parseSearch(searchTestSchema, "nn")) {
+		t.Error("relation label: no match for a substring")
+	}
+	if !cellMatches(FieldDef{Name: "ID", Type: "int"}, r, nil, parseSearch(searchTestSchema, "2")) {
+		t.Error("ID: no match for a substring")
+	}
+}
+
+func TestSearchRows(t *testing.T) {
+	db := openTestDB(t)
+	if !ftsEnabled {
+		t.Skip("built without sqlite_fts5")
+	}
+	defer func(on bool) { ftsEnabled = on }(ftsEnabled)
+	s := newTestSheet(t, db, "People", searchTestSchema)
+	names := map[int]string{}
+	var ann int
+	for _, data := range []map[string]interface{}{
+		{"Name": "Ann Lee", "Notes": "red apple pie", "Tags": []string{"garden", "kitchen"}, "Done": true},
+		{"Name": "Bob Stone", "Notes": "apple tart", "Tags": []string{"kitchen"}},
+		{"Name": "Cid Marsh", "Notes": "pie crust"},
+		{"Name": "Dee", "Notes": "green tea", "Tags": []string{"tea"}},
+	} {
+		if data["Name"] == "Dee" {
+			data["Boss"] = ann
+		}
+		id, err := insertRow(db, s.ID, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names[int(id)] = data["Name"].(string)
+		if ann == 0 {
+			ann = int(id)
+		}
+	}
+
+	// both ways of searching find the same rows for whole words
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"apple", "[Ann Lee Bob Stone]"},
+		{"APPLE", "[Ann Lee Bob Stone]"},
+		{"apple pie", "[Ann Lee]"},
+		{"pie", "[Ann Lee Cid Marsh]"},
+		{`"apple pie"`, "[Ann Lee]"},
+		{`"apple tart"`, "[Bob Stone]"},
+		{`"pie apple"`, "[]"},
+		{"app*", "[Ann Lee Bob Stone]"},
+		{"sto*", "[Bob Stone]"},
+		{"kitchen", "[Ann Lee Bob Stone]"},
+		{"tea", "[Dee]"},
+		{"Notes:tea", "[Dee]"},
+		{"Tags:garden", "[Ann Lee]"},
+		{"Name:pie", "[]"},
+		{"full name:lee", "[]"}, // "full" is a term of its own
+		{`Full name:"ann lee"`, "[]"},
+		{`Name:"ann lee"`, "[Ann Lee]"},
+		{`Notes:"apple pie" kitchen`, "[Ann Lee]"},
+		{"Boss:ann", "[Dee]"},
+		{"Done:true", "[]"}, // bools aren't searched
+		{"true", "[]"},
+		{"http://x", "[]"},
+		{"zzz", "[]"},
+	}
+	for _, tt := range tests {
+		for _, fts := range []bool{true, false} {
+			ftsEnabled = fts
+			rows, total, err := getViewRowsPage(db, s.ID, searchTestSchema, View{Search: tt.query}, 0, 0)
+			if err != nil {
+				t.Errorf("%s (full-text %v): %v", tt.query, fts, err)
+				continue
+			}
+			var got []string
+			for _, r := range rows {
+				got = append(got, names[r.ID])
+			}
+			sort.Strings(got)
+			if fmt.Sprint(got) != tt.want || total != len(rows) {
+				t.Errorf("%s (full-text %v): rows %v of %d, want %s", tt.query, fts, got, total, tt.want)
+			}
+		}
+	}
+}
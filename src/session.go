@@ -0,0 +1,441 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+)
+
+// recentDatabasesKey is the preference holding the recently opened databases
+const recentDatabasesKey = "recentDatabases"
+
+// maxRecentDatabases is how many entries the Open recent menu keeps
+const maxRecentDatabases = 8
+
+// appSession holds the database the window currently shows, so the File menu
+// can switch to another one, and the sheet shown in the tab bar
+type appSession struct {
+	app    fyne.App
+	win    fyne.Window
+	config []FieldDef // schema loaded from config.json
+	source string     // --schema; "" asks when the stored schema differs from config
+	schema []FieldDef // schema of the current sheet
+	db     *sql.DB
+	dbPath string
+
+	sheets  []Sheet            // tabs of the open database
+	sheet   Sheet              // current sheet
+	schemas map[int][]FieldDef // schemas of the sheets shown so far, by sheet id
+
+	flushEdits func() // writes the edits the grid of the shown sheet still queues
+}
+
+func newAppSession(a fyne.App, win fyne.Window, config []FieldDef, source string) *appSession {
+	return &appSession{app: a, win: win, config: config, source: source}
+}
+
+// close closes the current database, if any
+func (s *appSession) close() {
+	if s.db == nil {
+		return
+	}
+	if s.flushEdits != nil {
+		s.flushEdits()
+		s.flushEdits = nil
+	}
+	if err := s.db.Close(); err != nil {
+		log.Printf("warning: failed to close DB: %v", err)
+	}
+	s.db = nil
+}
+
+// start shows the first sheet of the current database. config.json describes
+// that sheet; when its stored schema differs the user picks which one to use
+// (unless --schema did).
+func (s *appSession) start() {
+	s.addRecent(s.dbPath)
+	s.buildMenu()
+	s.schemas = map[int][]FieldDef{}
+	if err := s.loadSheets(); err != nil {
+		dialog.ShowError(err, s.win)
+		return
+	}
+	s.sheet = s.sheets[0]
+
+	version, stored, err := storedSchema(s.db, s.sheet.ID)
+	switch {
+	case err != nil:
+		log.Printf("warning: failed to read the stored schema: %v", err)
+		s.useSchema(schemaFile)
+	case version == 0 || sameFieldDefs(stored, s.config):
+		s.useSchema(schemaFile)
+	case s.source != "":
+		s.useSchema(s.source)
+	default:
+		s.win.SetContent(widget.NewLabel("Checking schema..."))
+		showSchemaChoice(s.win, stored, s.config, s.useSchema)
+	}
+}
+
+// loadSheets reads the tabs of the open database
+func (s *appSession) loadSheets() error {
+	sheets, err := getSheets(s.db)
+	if err != nil {
+		return err
+	}
+	if len(sheets) == 0 {
+		return fmt.Errorf("the database has no sheets")
+	}
+	s.sheets = sheets
+	return nil
+}
+
+// showSheet switches to sheet; sheets not shown before use their stored schema
+func (s *appSession) showSheet(sheet Sheet) {
+	if s.flushEdits != nil {
+		s.flushEdits()
+	}
+	// the column widths may have changed since the tabs were loaded
+	if fresh, err := getSheet(s.db, sheet.ID); err == nil {
+		sheet = fresh
+	}
+	s.sheet = sheet
+	if schema, ok := s.schemas[sheet.ID]; ok {
+		s.schema = schema
+		s.showUI()
+		return
+	}
+	s.useSchema(schemaStored)
+}
+
+// useSchema resolves the schema of the current sheet from source, migrates its
+// rows to it if needed and shows the UI
+func (s *appSession) useSchema(source string) {
+	db, sheet := s.db, s.sheet.ID
+	schema, err := resolveSchema(db, sheet, s.config, source)
+	if err != nil {
+		dialog.ShowError(err, s.win)
+		schema = s.config
+	}
+	startUI := func() {
+		s.schema = schema
+		s.schemas[sheet] = schema
+		// stored formula values may be stale if the schema changed
+		if err := recomputeFormulas(db, sheet, schema); err != nil {
+			log.Printf("warning: failed to recompute formulas: %v", err)
+		}
+		s.showUI()
+	}
+
+	// compare the schema stored in the database with the chosen one
+	migration, err := planSchemaMigration(db, sheet, schema)
+	switch {
+	case err != nil:
+		log.Printf("warning: failed to check the stored schema: %v", err)
+		startUI()
+	case migration == nil:
+		startUI()
+	case !migration.NeedsData():
+		// nothing to rewrite, just record the new version
+		if _, err := applySchemaMigration(db, migration); err != nil {
+			log.Printf("warning: failed to record schema version: %v", err)
+		}
+		startUI()
+	default:
+		s.win.SetContent(widget.NewLabel("Checking schema..."))
+		showSchemaMigration(s.win, db, migration, startUI)
+	}
+}
+
+// showUI shows the tab bar with the grid of the current sheet. Only the
+// selected tab has content; switching tabs builds the UI of that sheet.
+func (s *appSession) showUI() {
+	tabs := container.NewAppTabs()
+	selected := 0
+	for i, sh := range s.sheets {
+		var content fyne.CanvasObject = widget.NewLabel("")
+		if sh.ID == s.sheet.ID {
+			content = createUI(s)
+			selected = i
+		}
+		tabs.Append(container.NewTabItem(sh.Name, content))
+	}
+	tabs.SelectIndex(selected)
+	tabs.OnSelected = func(item *container.TabItem) {
+		for i, t := range tabs.Items {
+			if t == item {
+				s.showSheet(s.sheets[i])
+				return
+			}
+		}
+	}
+	s.win.SetContent(tabs)
+}
+
+// reload rebuilds the UI after an import replaced stored schemas or added sheets
+func (s *appSession) reload() {
+	s.schemas = map[int][]FieldDef{}
+	if err := s.loadSheets(); err != nil {
+		dialog.ShowError(err, s.win)
+		return
+	}
+	s.showSheet(s.findSheet(s.sheet.ID))
+}
+
+// findSheet returns the loaded sheet with id, or the first one if it is gone
+func (s *appSession) findSheet(id int) Sheet {
+	for _, sh := range s.sheets {
+		if sh.ID == id {
+			return sh
+		}
+	}
+	return s.sheets[0]
+}
+
+// title is the window title prefix naming the open database and sheet
+func (s *appSession) title() string {
+	return "Simple Data Management App - " + filepath.Base(s.dbPath) + " - " + s.sheet.Name
+}
+
+// open switches the window to the database at path. The current database stays
+// open if path can't be opened.
+func (s *appSession) open(path string) {
+	db, err := openDB(path)
+	if err != nil {
+		dialog.ShowError(err, s.win)
+		return
+	}
+	s.close()
+	s.db = db
+	s.dbPath = path
+	s.start()
+}
+
+// create replaces the file at path with a blank database holding one empty row
+// and opens it
+func (s *appSession) create(path string) {
+	if samePath(path, s.dbPath) {
+		s.close()
+	}
+	for _, p := range []string{path, path + "-journal", path + "-wal", path + "-shm"} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			dialog.ShowError(fmt.Errorf("failed to remove existing DB: %w", err), s.win)
+			return
+		}
+	}
+	db, err := openDB(path)
+	if err != nil {
+		dialog.ShowError(err, s.win)
+		return
+	}
+	first, err := getSheet(db, 0)
+	if err == nil {
+		_, err = insertRow(db, first.ID, getEmptyRowFromSchema(s.config))
+	}
+	if err != nil {
+		log.Printf("warning: failed to add a blank row: %v", err)
+	}
+	s.close()
+	s.db = db
+	s.dbPath = path
+	s.start()
+}
+
+// showOpenDialog lets the user pick an existing database file
+func (s *appSession) showOpenDialog() {
+	fd := dialog.NewFileOpen(func(r fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, s.win)
+			return
+		}
+		if r == nil {
+			return
+		}
+		path := r.URI().Path()
+		r.Close()
+		s.open(path)
+	}, s.win)
+	fd.SetFilter(storageFilterDB())
+	fd.Show()
+}
+
+// showNewDialog asks where to create a blank database; the save dialog
+// already confirms replacing an existing file
+func (s *appSession) showNewDialog() {
+	fd := dialog.NewFileSave(func(uc fyne.URIWriteCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, s.win)
+			return
+		}
+		if uc == nil {
+			return
+		}
+		path := uc.URI().Path()
+		uc.Close()
+		s.create(path)
+	}, s.win)
+	fd.SetFileName("data.db")
+	fd.SetFilter(storageFilterDB())
+	fd.Show()
+}
+
+// recentDatabases returns the remembered database paths, most recent first
+func (s *appSession) recentDatabases() []string {
+	return s.app.Preferences().StringList(recentDatabasesKey)
+}
+
+// addRecent moves path to the front of the recent list
+func (s *appSession) addRecent(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	list := []string{path}
+	for _, p := range s.recentDatabases() {
+		if p != path && len(list) < maxRecentDatabases {
+			list = append(list, p)
+		}
+	}
+	s.app.Preferences().SetStringList(recentDatabasesKey, list)
+}
+
+// showSheetNameDialog asks for a sheet name and calls onName with it
+func (s *appSession) showSheetNameDialog(title, name string, onName func(name string) error) {
+	entry := widget.NewEntry()
+	entry.SetText(name)
+	dialog.ShowForm(title, "OK", "Cancel", []*widget.FormItem{widget.NewFormItem("Name", entry)}, func(ok bool) {
+		if !ok {
+			return
+		}
+		if err := onName(entry.Text); err != nil {
+			dialog.ShowError(err, s.win)
+		}
+	}, s.win)
+}
+
+// switchTo reloads the tabs and shows the sheet with id
+func (s *appSession) switchTo(id int) error {
+	if err := s.loadSheets(); err != nil {
+		return err
+	}
+	s.showSheet(s.findSheet(id))
+	return nil
+}
+
+// newSheet adds an empty sheet using the schema from config.json
+func (s *appSession) newSheet() {
+	s.showSheetNameDialog("New sheet", fmt.Sprintf("Sheet %d", len(s.sheets)+1), func(name string) error {
+		sh, err := createSheet(s.db, name, s.config)
+		if err != nil {
+			return err
+		}
+		return s.switchTo(sh.ID)
+	})
+}
+
+// renameCurrentSheet renames the current sheet
+func (s *appSession) renameCurrentSheet() {
+	s.showSheetNameDialog("Rename sheet", s.sheet.Name, func(name string) error {
+		if err := renameSheet(s.db, s.sheet.ID, name); err != nil {
+			return err
+		}
+		// relation fields naming the sheet changed with it
+		s.schemas = map[int][]FieldDef{}
+		return s.switchTo(s.sheet.ID)
+	})
+}
+
+// duplicateCurrentSheet copies the current sheet to a new tab
+func (s *appSession) duplicateCurrentSheet() {
+	s.showSheetNameDialog("Duplicate sheet", s.sheet.Name+" copy", func(name string) error {
+		from, err := getSheet(s.db, s.sheet.ID)
+		if err != nil {
+			return err
+		}
+		sh, err := duplicateSheet(s.db, from, s.schema, name)
+		if err != nil {
+			return err
+		}
+		s.schemas[sh.ID] = s.schema
+		return s.switchTo(sh.ID)
+	})
+}
+
+// deleteCurrentSheet deletes the current sheet after confirmation
+func (s *appSession) deleteCurrentSheet() {
+	msg := fmt.Sprintf("Delete sheet %q with all its rows and views? This can't be undone.", s.sheet.Name)
+	dialog.ShowConfirm("Delete sheet", msg, func(ok bool) {
+		if !ok {
+			return
+		}
+		if err := deleteSheet(s.db, s.sheet.ID); err != nil {
+			dialog.ShowError(err, s.win)
+			return
+		}
+		delete(s.schemas, s.sheet.ID)
+		if err := s.switchTo(0); err != nil {
+			dialog.ShowError(err, s.win)
+		}
+	}, s.win)
+}
+
+// buildMenu (re)creates the File and Sheet menus so Open recent reflects the preferences
+func (s *appSession) buildMenu() {
+	var recent []*fyne.MenuItem
+	for _, p := range s.recentDatabases() {
+		path := p
+		recent = append(recent, fyne.NewMenuItem(path, func() {
+			if _, err := os.Stat(path); err != nil {
+				dialog.ShowError(fmt.Errorf("cannot open %s: %w", path, err), s.win)
+				return
+			}
+			s.open(path)
+		}))
+	}
+	if len(recent) > 0 {
+		recent = append(recent, fyne.NewMenuItemSeparator())
+	}
+	recent = append(recent, fyne.NewMenuItem("Clear recent", func() {
+		s.app.Preferences().SetStringList(recentDatabasesKey, nil)
+		s.buildMenu()
+	}))
+
+	openRecent := fyne.NewMenuItem("Open recent", nil)
+	openRecent.ChildMenu = fyne.NewMenu("", recent...)
+
+	file := fyne.NewMenu("File",
+		fyne.NewMenuItem("New database...", s.showNewDialog),
+		fyne.NewMenuItem("Open database...", s.showOpenDialog),
+		openRecent,
+	)
+	sheet := fyne.NewMenu("Sheet",
+		fyne.NewMenuItem("New sheet...", s.newSheet),
+		fyne.NewMenuItem("Rename sheet...", s.renameCurrentSheet),
+		fyne.NewMenuItem("Duplicate sheet...", s.duplicateCurrentSheet),
+		fyne.NewMenuItemSeparator(),
+		fyne.NewMenuItem("Delete sheet", s.deleteCurrentSheet),
+	)
+	s.win.SetMainMenu(fyne.NewMainMenu(file, sheet))
+}
+
+// samePath reports whether two paths name the same file
+func samePath(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// storageFilterDB returns a file dialog filter for SQLite files
+func storageFilterDB() storage.FileFilter {
+	return storage.NewExtensionFileFilter([]string{".db", ".sqlite", ".sqlite3"})
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A database holds several sheets. Every row, view, schema (version) and undo step
+// belongs to one sheet through its sheet_id column; row and view ids stay unique
+// across sheets, so the helpers working on one record by id need no sheet.
+
+// Sheet is a named table of rows with its own schema, views and column widths
+type Sheet struct {
+	ID       int
+	Name     string
+	Position int                // tab order
+	Widths   map[string]float32 // column widths by field name ("" is the actions column)
+}
+
+// ensureSheetTables creates the sheet tables and moves databases from before
+// sheets into a first sheet named "Sheet 1" with id 1
+func ensureSheetTables(db *sql.DB) error {
+	createSheets := `
+	CREATE TABLE IF NOT EXISTS sheets (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		position INTEGER NOT NULL DEFAULT 0,
+		widths TEXT NOT NULL DEFAULT '{}'
+	);
+	INSERT INTO sheets (id, name, position) SELECT 1, 'Sheet 1', 0 WHERE NOT EXISTS (SELECT 1 FROM sheets);
+	`
+	if _, err := db.Exec(createSheets); err != nil {
+		return err
+	}
+
+	for _, table := range []string{"entries", "views", "undo_log"} {
+		ok, err := hasColumn(db, table, "sheet_id")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN sheet_id INTEGER NOT NULL DEFAULT 1"); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS entries_sheet ON entries (sheet_id, id)"); err != nil {
+		return err
+	}
+
+	// schema versions (field list of every schema version, see migrate.go)
+	// were numbered per database before sheets
+	if err := rebuildWithoutSheets(db, "schema_versions", `
+	CREATE TABLE schema_versions (
+		sheet_id INTEGER NOT NULL,
+		version INTEGER NOT NULL,
+		applied_at TEXT NOT NULL,
+		fields TEXT NOT NULL,
+		PRIMARY KEY (sheet_id, version)
+	)`, "INSERT INTO schema_versions SELECT 1, version, applied_at, fields FROM schema_versions_old"); err != nil {
+		return err
+	}
+
+	// the current schema of every sheet, so the file describes itself.
+	// Databases from before it take their latest recorded version.
+	if err := rebuildWithoutSheets(db, "schema", `
+	CREATE TABLE schema (
+		sheet_id INTEGER PRIMARY KEY,
+		version INTEGER NOT NULL,
+		fields TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	)`, "INSERT INTO schema SELECT 1, version, fields, updated_at FROM schema_old"); err != nil {
+		return err
+	}
+	_, err := db.Exec(`INSERT OR IGNORE INTO schema (sheet_id, version, fields, updated_at)
+		SELECT sheet_id, version, fields, applied_at FROM schema_versions v
+		WHERE version = (SELECT MAX(version) FROM schema_versions WHERE sheet_id = v.sheet_id)`)
+	return err
+}
+
+// rebuildWithoutSheets creates table with create, or recreates it when it exists
+// without a sheet_id column, copying the old rows with copySQL (reading <table>_old)
+func rebuildWithoutSheets(db *sql.DB, table, create, copySQL string) error {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := db.Exec(create)
+		return err
+	}
+	ok, err := hasColumn(db, table, "sheet_id")
+	if err != nil || ok {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, q := range []string{"ALTER TABLE " + table + " RENAME TO " + table + "_old", create, copySQL, "DROP TABLE " + table + "_old"} {
+		if _, err := tx.Exec(q); err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// hasColumn reports whether table has the named column
+func hasColumn(db sqlExecer, table, column string) (bool, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// getSheets returns all sheets in tab order
+func getSheets(db sqlExecer) ([]Sheet, error) {
+	rows, err := db.Query("SELECT id, name, position, widths FROM sheets ORDER BY position, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Sheet
+	for rows.Next() {
+		var s Sheet
+		var widths string
+		if err := rows.Scan(&s.ID, &s.Name, &s.Position, &widths); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(widths), &s.Widths); err != nil || s.Widths == nil {
+			s.Widths = map[string]float32{}
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// getSheet returns the sheet with the given id, or the first sheet when id is 0
+func getSheet(db sqlExecer, id int) (Sheet, error) {
+	sheets, err := getSheets(db)
+	if err != nil {
+		return Sheet{}, err
+	}
+	for _, s := range sheets {
+		if s.ID == id || id == 0 {
+			return s, nil
+		}
+	}
+	return Sheet{}, fmt.Errorf("no sheet with id %d", id)
+}
+
+// sheetByName looks a sheet up by name, ignoring case
+func sheetByName(db sqlExecer, name string) (Sheet, error) {
+	sheets, err := getSheets(db)
+	if err != nil {
+		return Sheet{}, err
+	}
+	for _, s := range sheets {
+		if strings.EqualFold(s.Name, name) {
+			return s, nil
+		}
+	}
+	return Sheet{}, fmt.Errorf("no sheet named %q", name)
+}
+
+// isFirstSheet reports whether id is the first tab; config.json describes that sheet
+func isFirstSheet(db sqlExecer, id int) (bool, error) {
+	first, err := getSheet(db, 0)
+	return first.ID == id, err
+}
+
+// checkSheetName rejects empty names and names used by another sheet
+func checkSheetName(db sqlExecer, name string, exceptID int) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("sheet name is empty")
+	}
+	if s, err := sheetByName(db, name); err == nil && s.ID != exceptID {
+		return "", fmt.Errorf("a sheet named %q already exists", s.Name)
+	}
+	return name, nil
+}
+
+// insertSheet adds a sheet as the last tab with schema as its first schema version
+func insertSheet(db sqlExecer, name string, schema []FieldDef) (Sheet, error) {
+	name, err := checkSheetName(db, name, 0)
+	if err != nil {
+		return Sheet{}, err
+	}
+	res, err := db.Exec("INSERT INTO sheets (name, position) VALUES (?, (SELECT COALESCE(MAX(position), -1) + 1 FROM sheets))", name)
+	if err != nil {
+		return Sheet{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Sheet{}, err
+	}
+	if err := recordSchemaVersion(db, int(id), 1, schema); err != nil {
+		return Sheet{}, err
+	}
+	return getSheet(db, int(id))
+}
+
+// createSheet adds an empty sheet with the given schema
+func createSheet(db *sql.DB, name string, schema []FieldDef) (Sheet, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Sheet{}, err
+	}
+	defer tx.Rollback()
+	s, err := insertSheet(tx, name, schema)
+	if err != nil {
+		return Sheet{}, err
+	}
+	return s, tx.Commit()
+}
+
+// renameSheet changes the name of a sheet and of the relation targets naming it
+func renameSheet(db *sql.DB, id int, name string) error {
+	name, err := checkSheetName(db, name, id)
+	if err != nil {
+		return err
+	}
+	old, err := getSheet(db, id)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE sheets SET name = ? WHERE id = ?", name, id); err != nil {
+		return err
+	}
+	if err := renameRelationTargets(tx, old.Name, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// duplicateSheet copies a sheet with its schema, rows, views and column widths
+// to a new last tab named name. Relations within the sheet point to the copied rows.
+func duplicateSheet(db *sql.DB, from Sheet, schema []FieldDef, name string) (Sheet, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Sheet{}, err
+	}
+	defer tx.Rollback()
+
+	s, err := insertSheet(tx, name, schema)
+	if err != nil {
+		return Sheet{}, err
+	}
+	rows, err := getAllRows(tx, from.ID)
+	if err != nil {
+		return Sheet{}, err
+	}
+	// relation fields without a target point into their own sheet, in the copy they
+	// have to point to the copied rows. The ids are allocated first, so the rows can
+	// be written with the new ids and their revisions show the copied values.
+	self := map[string]FieldDef{}
+	for _, f := range schema {
+		if f.Type == "relation" && f.Target == "" {
+			self[f.Name] = f
+		}
+	}
+	newIDs := map[int]int{}
+	for _, r := range rows {
+		res, err := tx.Exec("INSERT INTO entries (data, sheet_id) VALUES ('{}', ?)", s.ID)
+		if err != nil {
+			return Sheet{}, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return Sheet{}, err
+		}
+		newIDs[r.ID] = int(id)
+	}
+	for _, r := range rows {
+		for name, f := range self {
+			if v, ok := r.Data[name]; ok {
+				r.Data[name] = relationValue(f, remapIDs(relationIDs(v), newIDs))
+			}
+		}
+		if err := replaceRow(tx, newIDs[r.ID], r.Data); err != nil {
+			return Sheet{}, err
+		}
+	}
+	views, err := getAllViews(tx, from.ID)
+	if err != nil {
+		return Sheet{}, err
+	}
+	for _, v := range views {
+		for i, flt := range v.Filters {
+			if _, ok := self[flt.Field]; !ok || flt.Op != filterListContains {
+				continue
+			}
+			if id, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(flt.Value), "#")); err == nil {
+				if n, ok := newIDs[id]; ok {
+					v.Filters[i].Value = strconv.Itoa(n)
+				}
+			}
+		}
+		if v.IDs != nil {
+			v.IDs = remapIDs(v.IDs, newIDs)
+		}
+		if _, err := insertView(tx, s.ID, v); err != nil {
+			return Sheet{}, err
+		}
+	}
+	if err := saveSheetWidths(tx, s.ID, from.Widths); err != nil {
+		return Sheet{}, err
+	}
+	s.Widths = from.Widths
+	return s, tx.Commit()
+}
+
+// remapIDs maps ids through m; ids missing from m are kept
+func remapIDs(ids []int, m map[int]int) []int {
+	out := make([]int, len(ids))
+	for i, id := range ids {
+		if n, ok := m[id]; ok {
+			id = n
+		}
+		out[i] = id
+	}
+	return out
+}
+
+// deleteSheet removes a sheet with its rows and their revisions, views, schema and
+// undo steps. The last sheet and sheets that relation fields of other sheets point
+// to can't be deleted.
+func deleteSheet(db *sql.DB, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sheets").Scan(&n); err != nil {
+		return err
+	}
+	if n <= 1 {
+		return fmt.Errorf("the last sheet can't be deleted")
+	}
+	incoming, err := incomingRelations(tx)
+	if err != nil {
+		return err
+	}
+	for _, ref := range incoming[id] {
+		if ref.sheet == id {
+			continue // relations within the sheet go with it
+		}
+		from, err := getSheet(tx, ref.sheet)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("field %q of sheet %q relates to this sheet; remove it or change its target first", ref.field.Name, from.Name)
+	}
+	if _, err := tx.Exec("DELETE FROM revisions WHERE row_id IN (SELECT id FROM entries WHERE sheet_id = ?)", id); err != nil {
+		return err
+	}
+	for _, table := range []string{"entries", "views", "undo_log", "schema", "schema_versions"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE sheet_id = ?", id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM sheets WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// saveSheetWidths stores the column widths of a sheet
+func saveSheetWidths(db sqlExecer, id int, widths map[string]float32) error {
+	js, err := json.Marshal(widths)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE sheets SET widths = ? WHERE id = ?", string(js), id)
+	return err
+}
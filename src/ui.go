@@ -2,24 +2,21 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"image/color"
 	"io"
 	"log"
-	"math"
 	"os"
 	"os/exec"
-	"path/filepath"
-	"strconv"
 	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
-	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
 
@@ -27,11 +24,12 @@ import (
 type colResizer struct {
 	widget.BaseWidget
 	onDrag func(dx float32)
+	onEnd  func()
 	rect   *canvas.Rectangle
 }
 
-func newColResizer(onDrag func(dx float32)) *colResizer {
-	r := &colResizer{onDrag: onDrag}
+func newColResizer(onDrag func(dx float32), onEnd func()) *colResizer {
+	r := &colResizer{onDrag: onDrag, onEnd: onEnd}
 	r.ExtendBaseWidget(r)
 	return r
 }
@@ -51,7 +49,11 @@ func (r *colResizer) Dragged(e *fyne.DragEvent) {
 	}
 }
 
-func (r *colResizer) DragEnd() {}
+func (r *colResizer) DragEnd() {
+	if r.onEnd != nil {
+		r.onEnd()
+	}
+}
 
 type resizerRenderer struct {
 	rect *canvas.Rectangle
@@ -98,36 +100,33 @@ func newClickableOverlay(onLeft func(), onRight func()) *clickableOverlay {
 	return overlay
 }
 
-// mergeWithSchema returns a copy of data overlaid on the defaults from schema.
-// This ensures missing keys (e.g. when importing old JSON) are present for UI/export.
-func mergeWithSchema(schema []FieldDef, data map[string]interface{}) map[string]interface{} {
-	m := getEmptyRowFromSchema(schema)
-	if data == nil {
-		return m
-	}
-	for k, v := range data {
-		m[k] = v
-	}
-	return m
-}
-
-// createUI builds the whole UI based on schema.
-func createUI(win fyne.Window, db *sql.DB, schema []FieldDef) fyne.CanvasObject {
+// createUI builds the whole UI for the database and schema of the session.
+func createUI(s *appSession) fyne.CanvasObject {
+	win, db, sheet, schema, title := s.win, s.db, s.sheet.ID, s.schema, s.title()
 	cols := len(schema) + 1 // +1 for actions column
 
-	// column widths in pixels (float32). Start with a reasonable default.
+	// column widths in pixels (float32), as stored with the sheet or a reasonable default
 	colWidths := make([]float32, cols)
 	for i := range colWidths {
-		colWidths[i] = 160
+		name := ""
+		colWidths[i] = 240 // the row check and action buttons
+		if i < len(schema) {
+			name = schema[i].Name
+			colWidths[i] = 160
+		}
+		if w, ok := s.sheet.Widths[name]; ok && w > 0 {
+			colWidths[i] = w
+		}
 	}
 
-	// rows container: header + many row containers stacked vertically
-	rowsContainer := container.NewVBox()
+	// virtualized grid: only visible cells are created
+	grid := newDataGrid(win, db, sheet, schema, colWidths)
+	s.flushEdits = func() { grid.flush() }
 
 	// views in memory (loaded from DB)
 	var savedViews []View
 	loadViews := func() {
-		v, err := getAllViews(db)
+		v, err := getAllViews(db, sheet)
 		if err != nil {
 			log.Println("warning: failed to load views:", err)
 			savedViews = nil
@@ -139,32 +138,31 @@ func createUI(win fyne.Window, db *sql.DB, schema []FieldDef) fyne.CanvasObject
 
 	// current view state
 	currentViewID := 0               // 0 means "All"
-	currentViewName := "All"         // label shown
-	currentVisibleCols := []string{} // empty means all
-	fmt.Printf(currentViewName)
+	currentView := View{Name: "All"} // empty Columns means all, no filters/sort
+	searchText := ""                 // search box text, applied to every view
 
 	// helper to set view by id (0 => All)
 	setViewByID := func(id int) {
 		currentViewID = id
-		if id == 0 {
-			currentViewName = "All"
-			currentVisibleCols = nil
-			populateTableGrid(win, rowsContainer, db, schema, colWidths, currentVisibleCols)
-			return
-		}
-		for _, v := range savedViews {
-			if v.ID == id {
-				currentViewName = v.Name
-				currentVisibleCols = v.Columns
-				populateTableGrid(win, rowsContainer, db, schema, colWidths, currentVisibleCols)
-				return
+		currentView = View{Name: "All"}
+		if id != 0 {
+			found := false
+			for _, v := range savedViews {
+				if v.ID == id {
+					currentView = v
+					found = true
+					break
+				}
+			}
+			// fallback
+			if !found {
+				currentViewID = 0
 			}
 		}
-		// fallback
-		currentViewID = 0
-		currentViewName = "All"
-		currentVisibleCols = nil
-		populateTableGrid(win, rowsContainer, db, schema, colWidths, currentVisibleCols)
+		shown := currentView
+		shown.Search = searchText
+		grid.populateTableGrid(shown)
+		win.SetTitle(title + " - " + currentView.Name)
 	}
 
 	// helper to repopulate
@@ -211,12 +209,18 @@ func createUI(win fyne.Window, db *sql.DB, schema []FieldDef) fyne.CanvasObject
 		// if editing "All", create a new view instead (pre-filled with all shown)
 		var editing *View
 		if currentViewID == 0 {
-			editing = &View{ID: 0, Name: "New view", Columns: currentVisibleCols}
+			editing = &View{ID: 0, Name: "New view", Columns: currentView.Columns}
 		} else {
 			for _, v := range savedViews {
 				if v.ID == currentViewID {
-					copyCols := append([]string(nil), v.Columns...)
-					editing = &View{ID: v.ID, Name: v.Name, Columns: copyCols}
+					editing = &View{
+						ID:      v.ID,
+						Name:    v.Name,
+						Columns: append([]string(nil), v.Columns...),
+						Filters: append([]ViewFilter(nil), v.Filters...),
+						Sort:    append([]SortKey(nil), v.Sort...),
+						Form:    append([]FormGroup(nil), v.Form...),
+					}
 					break
 				}
 			}
@@ -249,14 +253,27 @@ func createUI(win fyne.Window, db *sql.DB, schema []FieldDef) fyne.CanvasObject
 			colsBox.Add(ch)
 		}
 
+		filterBox, collectFilters := makeFilterEditor(queryableFields(schema), editing.Filters)
+		sortBox, collectSort := makeSortEditor(queryableFields(schema), editing.Sort)
+		formBox, collectForm := makeFormLayoutEditor(schema, editing.Form)
+
 		form := container.NewVBox(
 			widget.NewLabel("View name:"),
 			nameEntry,
 			widget.NewLabel("Visible columns:"),
-			container.NewScroll(colsBox),
+			colsBox,
+			widget.NewSeparator(),
+			widget.NewLabel("Filters (all must match):"),
+			filterBox,
+			widget.NewSeparator(),
+			widget.NewLabel("Sort by:"),
+			sortBox,
+			widget.NewSeparator(),
+			widget.NewLabel("Row form fields and groups:"),
+			formBox,
 		)
 
-		dialog.ShowCustomConfirm("Edit View", "Save", "Cancel", form, func(yes bool) {
+		dlg := dialog.NewCustomConfirm("Edit View", "Save", "Cancel", container.NewVScroll(form), func(yes bool) {
 			if !yes {
 				return
 			}
@@ -267,17 +284,16 @@ func createUI(win fyne.Window, db *sql.DB, schema []FieldDef) fyne.CanvasObject
 					selCols = append(selCols, f.Name)
 				}
 			}
-			// if editing existing view
-			if editing.ID > 0 {
-				if err := updateView(db, editing.ID, nameEntry.Text, selCols); err != nil {
-					dialog.ShowError(err, win)
-					return
-				}
-			} else {
-				if _, err := insertView(db, nameEntry.Text, selCols); err != nil {
-					dialog.ShowError(err, win)
-					return
-				}
+			saved := View{ID: editing.ID, Name: nameEntry.Text, Columns: selCols, Filters: collectFilters(), Sort: collectSort(), Form: collectForm()}
+			// reject rules SQLite cannot evaluate before storing them
+			if _, _, _, err := buildViewQuery(schema, saved); err != nil {
+				dialog.ShowError(err, win)
+				return
+			}
+			// inserts a new view or updates the edited one
+			if _, err := undoableSaveView(db, sheet, saved); err != nil {
+				dialog.ShowError(err, win)
+				return
 			}
 			// reload views and set to the saved/edited view
 			loadViews()
@@ -295,15 +311,17 @@ func createUI(win fyne.Window, db *sql.DB, schema []FieldDef) fyne.CanvasObject
 			viewSelect.SetSelected("All")
 			setViewByID(0)
 		}, win)
+		dlg.Resize(fyne.NewSize(560, 520))
+		dlg.Show()
 	})
 
 	delViewBtn := widget.NewButton("🗑", func() {
 		// cannot delete "All"
-		dialog.ShowConfirm("Delete view", "Delete this view? This cannot be undone.", func(yes bool) {
+		dialog.ShowConfirm("Delete view", "Delete this view?", func(yes bool) {
 			if !yes {
 				return
 			}
-			if err := deleteView(db, currentViewID); err != nil {
+			if err := undoableDeleteView(db, currentViewID); err != nil {
 				dialog.ShowError(err, win)
 				return
 			}
@@ -343,81 +361,36 @@ func createUI(win fyne.Window, db *sql.DB, schema []FieldDef) fyne.CanvasObject
 			}
 
 			// detect if file is an array of entries or an object { "entries": [...], "views":[...] }
-			var j interface{}
-			if err := json.Unmarshal(data, &j); err != nil {
-				dialog.ShowError(err, win)
-				return
-			}
-
-			// clear existing entries
-			if _, err := db.Exec("DELETE FROM entries"); err != nil {
+			in, err := parseJSONImport(data)
+			if err != nil {
 				dialog.ShowError(err, win)
 				return
 			}
 
-			switch t := j.(type) {
-			case []interface{}:
-				// legacy array of objects
-				var entries []map[string]interface{}
-				if err := json.Unmarshal(data, &entries); err != nil {
-					dialog.ShowError(err, win)
+			modeForm, selectedMode := makeImportModeForm(schema)
+			dialog.ShowCustomConfirm("Import", "Preview", "Cancel", modeForm, func(yes bool) {
+				if !yes {
 					return
 				}
-				for _, e := range entries {
-					merged := mergeWithSchema(schema, e)
-					if _, err := insertRow(db, merged); err != nil {
-						dialog.ShowError(err, win)
-						return
-					}
-				}
-			case map[string]interface{}:
-				// expecting keys "entries" and optional "views"
-				// entries
-				if rawEntries, ok := t["entries"]; ok {
-					entriesBytes, _ := json.Marshal(rawEntries)
-					var entries []map[string]interface{}
-					if err := json.Unmarshal(entriesBytes, &entries); err != nil {
-						dialog.ShowError(err, win)
-						return
-					}
-					for _, e := range entries {
-						merged := mergeWithSchema(schema, e)
-						if _, err := insertRow(db, merged); err != nil {
-							dialog.ShowError(err, win)
-							return
-						}
-					}
+				mode, key := selectedMode()
+				plans, err := planWorkbookImport(db, sheet, schema, in, mode, key)
+				if err != nil {
+					dialog.ShowError(err, win)
+					return
 				}
-				// views (optional)
-				if rawViews, ok := t["views"]; ok {
-					viewsBytes, _ := json.Marshal(rawViews)
-					var views []struct {
-						Name    string   `json:"Name"`
-						Columns []string `json:"Columns"`
-					}
-					// tolerate several shapes: try to unmarshal into expected struct
-					_ = json.Unmarshal(viewsBytes, &views)
-
-					// delete all existing views then insert
-					if err := deleteAllViews(db); err != nil {
-						dialog.ShowError(err, win)
-						return
-					}
-					for _, v := range views {
-						if _, err := insertView(db, v.Name, v.Columns); err != nil {
-							dialog.ShowError(err, win)
+				confirmImport(win, db, plans, "", func() {
+					for _, p := range plans {
+						if p.Schema != nil {
+							// new sheets or changed schemas
+							s.reload()
 							return
 						}
 					}
-				}
-			default:
-				dialog.ShowError(fmt.Errorf("unknown import format"), win)
-				return
-			}
-
-			// reload views and data
-			populate()
-			dialog.ShowInformation("Import", "Imported data", win)
+					// reload views and data
+					populate()
+					dialog.ShowInformation("Import", "Imported data", win)
+				})
+			}, win)
 		}, win)
 		fd.SetFilter(storageFilterJSON())
 		fd.Show()
@@ -433,38 +406,8 @@ func createUI(win fyne.Window, db *sql.DB, schema []FieldDef) fyne.CanvasObject
 				return
 			}
 			defer uc.Close()
-			rows, err := getAllRows(db)
-			if err != nil {
-				dialog.ShowError(err, win)
-				return
-			}
-			var entries []map[string]interface{}
-			for _, r := range rows {
-				objData := mergeWithSchema(schema, r.Data)
-				obj := attachIDToDataMap(r.ID, objData)
-				entries = append(entries, obj)
-			}
-
-			// include views
-			views, err := getAllViews(db)
-			if err != nil {
-				// non-fatal: continue with empty views
-				views = nil
-			}
-			var exportedViews []map[string]interface{}
-			for _, v := range views {
-				exportedViews = append(exportedViews, map[string]interface{}{
-					"Name":    v.Name,
-					"Columns": v.Columns,
-				})
-			}
-
-			out := map[string]interface{}{
-				"entries": entries,
-				"views":   exportedViews,
-			}
-
-			data, err := json.MarshalIndent(out, "", "  ")
+			grid.flush()
+			data, err := exportJSON(db, sheet, schema)
 			if err != nil {
 				dialog.ShowError(err, win)
 				return
@@ -478,8 +421,19 @@ func createUI(win fyne.Window, db *sql.DB, schema []FieldDef) fyne.CanvasObject
 		fd.Show()
 	})
 
+	exportCSVBtn := widget.NewButton("Export CSV", func() {
+		grid.flush()
+		showCSVExportDialog(win, db, sheet, schema, currentView, nil)
+	})
+
+	importCSVBtn := widget.NewButton("Import CSV", func() {
+		grid.flush()
+		showCSVImportWizard(win, db, sheet, schema, populate)
+	})
+
 	printBtn := widget.NewButton("Print", func() {
-		rows, err := getAllRows(db)
+		grid.flush()
+		rows, err := getViewRows(db, sheet, schema, View{})
 		if err != nil {
 			dialog.ShowError(err, win)
 			return
@@ -507,56 +461,141 @@ func createUI(win fyne.Window, db *sql.DB, schema []FieldDef) fyne.CanvasObject
 		dialog.ShowInformation("Print", "Sent to printer", win)
 	})
 
+	// undo/redo: reload everything since a step may touch rows and views
+	undoRedo := func(redo bool) {
+		step := undoLast
+		if redo {
+			step = redoNext
+		}
+		grid.flush()
+		if _, err := step(db, sheet); err != nil {
+			dialog.ShowError(err, win)
+			return
+		}
+		populate()
+		updateViewButtons()
+		viewSelect.Selected = currentView.Name
+		viewSelect.Refresh()
+	}
+	undoBtn := widget.NewButton("↶", func() { undoRedo(false) })
+	redoBtn := widget.NewButton("↷", func() { undoRedo(true) })
+	win.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyZ, Modifier: fyne.KeyModifierShortcutDefault}, func(fyne.Shortcut) {
+		undoRedo(false)
+	})
+	win.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyZ, Modifier: fyne.KeyModifierShortcutDefault | fyne.KeyModifierShift}, func(fyne.Shortcut) {
+		undoRedo(true)
+	})
+
+	replaceBtn := widget.NewButton("Replace", func() {
+		grid.flush()
+		showReplaceDialog(win, db, sheet, schema, grid.view, populate)
+	})
+	win.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyH, Modifier: fyne.KeyModifierShortcutDefault}, func(fyne.Shortcut) {
+		grid.flush()
+		showReplaceDialog(win, db, sheet, schema, grid.view, populate)
+	})
+
+	// bulk actions on the rows checked in the actions column
+	var selectedBtn *widget.Button
+	selectedBtn = widget.NewButton("Selected rows", func() {
+		ids := grid.checkedIDs()
+		if len(ids) == 0 {
+			return
+		}
+		grid.flush()
+		menu := fyne.NewMenu("",
+			fyne.NewMenuItem("Set field...", func() {
+				showBulkSetDialog(win, db, schema, ids, populate)
+			}),
+			fyne.NewMenuItem("Add list item...", func() {
+				showBulkListDialog(win, db, schema, ids, false, populate)
+			}),
+			fyne.NewMenuItem("Remove list item...", func() {
+				showBulkListDialog(win, db, schema, ids, true, populate)
+			}),
+			fyne.NewMenuItem("Duplicate", func() {
+				if _, err := bulkDuplicateRows(db, sheet, ids); err != nil {
+					dialog.ShowError(err, win)
+					return
+				}
+				populate()
+			}),
+			fyne.NewMenuItem("Export CSV...", func() {
+				showCSVExportDialog(win, db, sheet, schema, currentView, ids)
+			}),
+			fyne.NewMenuItemSeparator(),
+			fyne.NewMenuItem("Delete...", func() {
+				dialog.ShowConfirm("Delete", fmt.Sprintf("Delete %d rows? You can undo this with Ctrl+Z.", len(ids)), func(yes bool) {
+					if !yes {
+						return
+					}
+					if err := undoableDeleteRows(db, ids); err != nil {
+						dialog.ShowError(err, win)
+						return
+					}
+					populate()
+				}, win)
+			}),
+		)
+		pos := fyne.CurrentApp().Driver().AbsolutePositionForObject(selectedBtn)
+		widget.ShowPopUpMenuAtPosition(menu, win.Canvas(), pos.Add(fyne.NewPos(0, selectedBtn.Size().Height)))
+	})
+	selectedBtn.Disable()
+	grid.onChecked = func() {
+		if n := len(grid.checked); n > 0 {
+			selectedBtn.SetText(fmt.Sprintf("Selected rows (%d)", n))
+			selectedBtn.Enable()
+		} else {
+			selectedBtn.SetText("Selected rows")
+			selectedBtn.Disable()
+		}
+	}
+
 	addRowBtn := widget.NewButton("Add Row", func() {
 		empty := getEmptyRowFromSchema(schema)
-		if _, err := insertRow(db, empty); err != nil {
+		if _, err := undoableInsertRow(db, sheet, empty); err != nil {
 			dialog.ShowError(err, win)
 			return
 		}
 		populate()
 	})
 
-	// New DB button: confirm and create a fresh DB file (will remove existing data.db)
-	newDBBtn := widget.NewButton("New DB", func() {
-		dialog.ShowConfirm("New Database", "This will erase current data and create a new blank database. Continue?", func(yes bool) {
-			if !yes {
-				return
-			}
-			if db != nil {
-				if err := db.Close(); err != nil {
-					log.Printf("warning: failed to close DB: %v", err)
-				}
-			}
-			if err := os.Remove("./data.db"); err != nil && !os.IsNotExist(err) {
-				dialog.ShowError(fmt.Errorf("failed to remove existing DB: %w", err), win)
-				return
-			}
-			db = initializeDB()
-			// create one blank row using schema defaults
-			if _, err := insertRow(db, getEmptyRowFromSchema(schema)); err != nil {
-				dialog.ShowError(err, win)
-				return
-			}
-			// clear views
-			_ = deleteAllViews(db)
-			populate()
-			dialog.ShowInformation("New Database", "Created new blank database", win)
-		}, win)
+	// search: narrows the grid to matching rows, the arrows cycle through matched cells
+	searchStatus := widget.NewLabel("")
+	searchEntry := widget.NewEntry()
+	searchEntry.SetPlaceHolder(`Search (word, "phrase", pre*, Field:word)`)
+	if !ftsEnabled {
+		// without the full-text index terms match anywhere inside the values
+		searchEntry.SetPlaceHolder("Search (substrings, Field:text; no full-text index in this build)")
+	}
+	searchEntry.OnChanged = func(s string) {
+		searchText = s
+		setViewByID(currentViewID)
+		searchStatus.SetText(grid.matchStatus())
+	}
+	searchEntry.OnSubmitted = func(string) {
+		searchStatus.SetText(grid.moveMatch(1))
+	}
+	prevMatchBtn := widget.NewButtonWithIcon("", theme.MoveUpIcon(), func() {
+		searchStatus.SetText(grid.moveMatch(-1))
+	})
+	nextMatchBtn := widget.NewButtonWithIcon("", theme.MoveDownIcon(), func() {
+		searchStatus.SetText(grid.moveMatch(1))
+	})
+	win.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyF, Modifier: fyne.KeyModifierShortcutDefault}, func(fyne.Shortcut) {
+		win.Canvas().Focus(searchEntry)
 	})
 
-	// toolbar: view selector + edit/delete + separators + other buttons
+	// toolbar: view selector + edit/delete + separators + other buttons, search on the right
 	viewToolbar := container.NewHBox(viewSelect, editViewBtn, delViewBtn)
-	toolbar := container.NewHBox(newDBBtn, widget.NewSeparator(), viewToolbar, widget.NewSeparator(), openBtn, saveBtn, printBtn, widget.NewSeparator(), addRowBtn)
-
-	// scrollable area should allow both axes
-	scroll := container.NewScroll(rowsContainer)
-	scroll.SetMinSize(fyne.NewSize(600, 300))
+	buttons := container.NewHBox(viewToolbar, widget.NewSeparator(), openBtn, saveBtn, importCSVBtn, exportCSVBtn, printBtn, widget.NewSeparator(), undoBtn, redoBtn, replaceBtn, addRowBtn, selectedBtn, widget.NewSeparator())
+	toolbar := container.NewBorder(nil, nil, buttons, container.NewHBox(prevMatchBtn, nextMatchBtn, searchStatus), searchEntry)
 
 	// ensure buttons reflect current view state
 	updateViewButtons()
 
 	// Return the UI
-	return container.NewBorder(toolbar, nil, nil, nil, scroll)
+	return container.NewBorder(toolbar, nil, nil, nil, grid.content)
 }
 
 // storageFilterJSON returns a file dialog filter for .json
@@ -564,367 +603,745 @@ func storageFilterJSON() storage.FileFilter {
 	return storage.NewExtensionFileFilter([]string{".json"})
 }
 
-// populateTableGrid rebuilds header + rows in a VBox so header and cells use same widths.
-// visibleCols = nil => show all columns; otherwise restrict to those names (in order of schema)
-func populateTableGrid(win fyne.Window, rowsContainer *fyne.Container, db *sql.DB, schema []FieldDef, colWidths []float32, visibleCols []string) {
-	rows, err := getAllRows(db)
-	if err != nil {
-		log.Println("Error loading data:", err)
-		rowsContainer.Objects = []fyne.CanvasObject{widget.NewLabel("Error loading data")}
-		rowsContainer.Refresh()
-		return
-	}
+// storageFilterCSV returns a file dialog filter for .csv/.tsv
+func storageFilterCSV() storage.FileFilter {
+	return storage.NewExtensionFileFilter([]string{".csv", ".tsv", ".txt"})
+}
 
-	rowsContainer.Objects = nil
+// csvDelimiters are the field separators offered by the CSV dialogs
+var csvDelimiters = map[string]rune{"Comma": ',', "Tab": '\t', "Semicolon": ';'}
 
-	// build a set for visible columns if provided
-	showAll := len(visibleCols) == 0
-	visSet := map[string]bool{}
-	for _, n := range visibleCols {
-		visSet[n] = true
-	}
-
-	// build effective schema in order and remember original indexes so header resizers map to same colWidths
-	effective := []FieldDef{}
-	origIndexes := []int{}
-	for i, f := range schema {
-		if showAll || visSet[f.Name] {
-			effective = append(effective, f)
-			origIndexes = append(origIndexes, i)
+// csvDelimiterName returns the csvDelimiters key for a separator
+func csvDelimiterName(r rune) string {
+	for name, d := range csvDelimiters {
+		if d == r {
+			return name
 		}
 	}
+	return "Comma"
+}
 
-	// column resizing constraints and sizing constants (use float32 for fyne sizes)
-	const minColWidth float32 = 40.0
-	const resizerWidth float32 = 6.0 // width of the header resizer handle; mirror resizer MinSize()
-	// default heights
-	const singleLineHeight float32 = 30.0
-	const listItemHeight float32 = 20.0
-	const listMaxVisible = 3
-	const maxStringLines = 10 // hard cap for string height growth
+// showCSVExportDialog asks for format and scope, then writes rows as CSV/TSV.
+// Scope "Current view" exports only the rows and columns of view v, "Selected rows"
+// the rows with the selected ids in the columns of v.
+func showCSVExportDialog(win fyne.Window, db *sql.DB, sheet int, schema []FieldDef, v View, selected []int) {
+	formatRadio := widget.NewRadioGroup([]string{"CSV", "TSV"}, nil)
+	formatRadio.SetSelected("CSV")
+	scopeAll := "All rows and columns"
+	scopeView := fmt.Sprintf("Current view (%s)", v.Name)
+	scopeSelected := fmt.Sprintf("Selected rows (%d)", len(selected))
+	scopes := []string{scopeAll, scopeView}
+	if len(selected) > 0 {
+		scopes = append(scopes, scopeSelected)
+	}
+	scopeRadio := widget.NewRadioGroup(scopes, nil)
+	scopeRadio.SetSelected(scopeAll)
+	if len(selected) > 0 {
+		scopeRadio.SetSelected(scopeSelected)
+	}
+	listSepEntry := widget.NewEntry()
+	listSepEntry.SetText("; ")
 
-	// Header row
-	headerBg := canvas.NewRectangle(color.NRGBA{R: 240, G: 240, B: 240, A: 20})
-	headerRow := container.NewHBox()
-	for ci, f := range effective {
-		label := widget.NewLabel(f.Label)
-		label.Alignment = fyne.TextAlignLeading
+	form := widget.NewForm(
+		widget.NewFormItem("Format", formatRadio),
+		widget.NewFormItem("Rows", scopeRadio),
+		widget.NewFormItem("List separator", listSepEntry),
+	)
 
-		// ensure label expands to the full header cell width (avoid HBox shrinking)
-		cell := container.NewStack(headerBg, container.NewStack(label))
+	dialog.ShowCustomConfirm("Export CSV", "Export", "Cancel", form, func(yes bool) {
+		if !yes {
+			return
+		}
+		comma, ext := ',', ".csv"
+		if formatRadio.Selected == "TSV" {
+			comma, ext = '\t', ".tsv"
+		}
 
-		// use original schema index for width mapping so header and rows stay aligned
-		widx := origIndexes[ci]
-		if widx >= len(colWidths) {
-			widx = len(colWidths) - 1
+		var rows []Row
+		var cols []string
+		var err error
+		switch scopeRadio.Selected {
+		case scopeView:
+			rows, err = getViewRows(db, sheet, schema, v)
+			cols = v.Columns
+		case scopeSelected:
+			rows, err = getViewRows(db, sheet, schema, View{Columns: v.Columns, Sort: v.Sort, IDs: selected})
+			cols = v.Columns
+		default:
+			rows, err = getViewRows(db, sheet, schema, View{})
+		}
+		if err != nil {
+			dialog.ShowError(err, win)
+			return
+		}
+		if len(cols) == 0 {
+			for _, f := range schema {
+				cols = append(cols, f.Name)
+			}
 		}
 
-		// resizer clamps to minimum width
-		res := newColResizer(func(dx float32) {
-			newW := float32(math.Max(float64(minColWidth), float64(colWidths[widx]+dx)))
-			if newW != colWidths[widx] {
-				colWidths[widx] = newW
-				populateTableGrid(win, rowsContainer, db, schema, colWidths, visibleCols)
+		fd := dialog.NewFileSave(func(uc fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, win)
+				return
 			}
-		})
+			if uc == nil {
+				return
+			}
+			defer uc.Close()
+			if err := writeDelimited(uc, schema, cols, rows, comma, listSepEntry.Text); err != nil {
+				dialog.ShowError(err, win)
+			}
+		}, win)
+		fd.SetFileName("export" + ext)
+		fd.Show()
+	}, win)
+}
 
-		cellWrap := container.New(layout.NewGridWrapLayout(fyne.NewSize(colWidths[widx], singleLineHeight)), cell)
-		headerRow.Add(container.NewHBox(cellWrap, res))
-	}
-	// Actions header (no resizer) uses last colWidths index
-	actionLabel := widget.NewLabel("Actions")
-	actionCell := container.NewStack(headerBg, actionLabel)
-	actWrap := container.New(layout.NewGridWrapLayout(fyne.NewSize(colWidths[len(colWidths)-1], singleLineHeight)), actionCell)
-	headerRow.Add(actWrap)
-
-	rowsContainer.Add(headerRow)
-
-	// Get executable directory for link files
-	exeDir := ""
-	if p, err := os.Executable(); err == nil {
-		exeDir = filepath.Dir(p)
-	}
-
-	// Rows
-	for ri, r := range rows {
-		mergedData := mergeWithSchema(schema, r.Data)
-
-		// compute row height dynamically:
-		// - single-line fields default to singleLineHeight and are vertically centered
-		// - []string fields expand up to listMaxVisible items (rest scroll)
-		// - string fields expand with explicit newlines (multi-line entry)
-		rowH := singleLineHeight
-		for _, f := range effective {
-			switch f.Type {
-			case "[]string":
-				var listLen int
-				if v, ok := mergedData[f.Name]; ok {
-					switch t := v.(type) {
-					case []interface{}:
-						listLen = len(t)
-					case []string:
-						listLen = len(t)
-					default:
-						if s := fmt.Sprintf("%v", v); s != "" {
-							listLen = 1
-						}
-					}
-				}
-				visible := listLen
-				if visible > listMaxVisible {
-					visible = listMaxVisible
-				}
-				h := float32(visible)*listItemHeight + 6 // padding
-				if h > rowH {
-					rowH = h
-				}
-			case "string", "link":
-				// allow explicit newlines to increase height; cap growth
-				val := ""
-				if v, ok := mergedData[f.Name]; ok {
-					val = fmt.Sprintf("%v", v)
-				}
-				lines := strings.Count(val, "\n") + 1
-				if lines > maxStringLines {
-					lines = maxStringLines
+// showCSVImportWizard lets the user pick a CSV/TSV file, preview it and map its
+// headers to schema fields. Converted rows are imported with the chosen mode; onDone refreshes the UI.
+func showCSVImportWizard(win fyne.Window, db *sql.DB, sheet int, schema []FieldDef, onDone func()) {
+	fd := dialog.NewFileOpen(func(r fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, win)
+			return
+		}
+		if r == nil {
+			return
+		}
+		defer r.Close()
+		raw, err := io.ReadAll(r)
+		if err != nil {
+			dialog.ShowError(err, win)
+			return
+		}
+		showCSVMappingDialog(win, db, sheet, schema, raw, delimiterForExt(r.URI().Extension()), onDone)
+	}, win)
+	fd.SetFilter(storageFilterCSV())
+	fd.Show()
+}
+
+// showCSVMappingDialog is the second wizard step: delimiter choice, preview and header mapping.
+func showCSVMappingDialog(win fyne.Window, db *sql.DB, sheet int, schema []FieldDef, raw []byte, comma rune, onDone func()) {
+	const previewRows = 5
+	const skipLabel = "(skip)"
+
+	fieldOpts, byLabel := fieldSelectOptions(schema)
+	fieldOpts = append([]string{skipLabel}, fieldOpts...)
+
+	var header []string
+	var records [][]string
+	var mapSelects []*widget.Select
+
+	previewBox := container.NewStack()
+	mappingBox := container.NewVBox()
+	listSepEntry := widget.NewEntry()
+	listSepEntry.SetText(";")
+	modeForm, selectedMode := makeImportModeForm(schema)
+
+	// parse (re)reads the file with the chosen delimiter and rebuilds preview and mapping
+	parse := func() {
+		var err error
+		header, records, err = readDelimited(strings.NewReader(string(raw)), comma)
+		if err != nil {
+			previewBox.Objects = []fyne.CanvasObject{widget.NewLabel("Cannot parse file: " + err.Error())}
+			previewBox.Refresh()
+			mappingBox.Objects = nil
+			mappingBox.Refresh()
+			header, records = nil, nil
+			return
+		}
+
+		grid := container.NewGridWithColumns(len(header))
+		for _, h := range header {
+			l := widget.NewLabel(h)
+			l.TextStyle.Bold = true
+			grid.Add(l)
+		}
+		for i := 0; i < len(records) && i < previewRows; i++ {
+			for ci := range header {
+				cell := ""
+				if ci < len(records[i]) {
+					cell = records[i][ci]
 				}
-				h := float32(lines) * (listItemHeight + 2) // slightly larger line height for wrapped text
-				if h > rowH {
-					rowH = h
+				l := widget.NewLabel(cell)
+				l.Truncation = fyne.TextTruncateEllipsis
+				grid.Add(l)
+			}
+		}
+		previewBox.Objects = []fyne.CanvasObject{container.NewHScroll(grid)}
+		previewBox.Refresh()
+
+		guess := guessCSVMapping(schema, header)
+		mapSelects = nil
+		form := widget.NewForm()
+		for i, h := range header {
+			sel := widget.NewSelect(fieldOpts, nil)
+			sel.SetSelected(skipLabel)
+			for _, f := range schema {
+				if f.Name == guess[i] {
+					sel.SetSelected(f.Label)
 				}
 			}
+			mapSelects = append(mapSelects, sel)
+			form.AppendItem(widget.NewFormItem(h, sel))
+		}
+		mappingBox.Objects = []fyne.CanvasObject{form}
+		mappingBox.Refresh()
+	}
+
+	delimSelect := widget.NewSelect([]string{"Comma", "Tab", "Semicolon"}, func(sel string) {
+		comma = csvDelimiters[sel]
+		parse()
+	})
+	delimSelect.SetSelected(csvDelimiterName(comma))
+
+	content := container.NewVBox(
+		widget.NewForm(
+			widget.NewFormItem("Delimiter", delimSelect),
+			widget.NewFormItem("List separator", listSepEntry),
+		),
+		widget.NewLabel(fmt.Sprintf("Preview (first %d rows):", previewRows)),
+		previewBox,
+		widget.NewSeparator(),
+		widget.NewLabel("Map columns to fields:"),
+		mappingBox,
+		widget.NewSeparator(),
+		modeForm,
+	)
+
+	dlg := dialog.NewCustomConfirm("Import CSV", "Import", "Cancel", container.NewVScroll(content), func(yes bool) {
+		if !yes || header == nil {
+			return
+		}
+		mapping := make([]string, len(header))
+		for i, sel := range mapSelects {
+			if f, ok := byLabel[sel.Selected]; ok {
+				mapping[i] = f.Name
+			}
+		}
+		rows, rowErrs := convertCSVRecords(schema, mapping, records, listSepEntry.Text)
+		mode, key := selectedMode()
+		plan, err := planImport(db, sheet, schema, &jsonImport{Entries: rows}, mode, key)
+		if err != nil {
+			dialog.ShowError(err, win)
+			return
 		}
+		note := ""
+		if len(rowErrs) > 0 {
+			note = fmt.Sprintf("%d rows could not be converted and will be skipped.", len(rowErrs))
+		}
+		confirmImport(win, db, []*importPlan{plan}, note, func() {
+			onDone()
+			showCSVImportReport(win, len(plan.Inserts)+len(plan.Updates), rowErrs)
+		})
+	}, win)
+	dlg.Resize(fyne.NewSize(720, 560))
+	dlg.Show()
+}
+
+// import mode labels shown by makeImportModeForm
+var importModeLabels = map[string]string{
+	importReplace: "Replace all rows",
+	importAppend:  "Append as new rows",
+	importUpsert:  "Update or insert by key",
+}
 
-		// alternating row color
-		var bg color.NRGBA
-		if ri%2 == 0 {
-			bg = color.NRGBA{R: 250, G: 250, B: 250, A: 20}
+// makeImportModeForm builds the import mode selector used by JSON and CSV imports.
+// The returned func yields the chosen mode and, for upserts, the key field name.
+func makeImportModeForm(schema []FieldDef) (fyne.CanvasObject, func() (string, string)) {
+	fieldOpts, byLabel := fieldSelectOptions(schema)
+	keySelect := widget.NewSelect(fieldOpts, nil)
+	if len(fieldOpts) > 0 {
+		keySelect.SetSelected(fieldOpts[0])
+	}
+	keySelect.Disable()
+
+	modes := []string{importReplace, importAppend, importUpsert}
+	var labels []string
+	for _, m := range modes {
+		labels = append(labels, importModeLabels[m])
+	}
+	modeRadio := widget.NewRadioGroup(labels, func(sel string) {
+		if sel == importModeLabels[importUpsert] {
+			keySelect.Enable()
 		} else {
-			bg = color.NRGBA{R: 245, G: 245, B: 255, A: 40}
-		}
-
-		rowBox := container.NewHBox()
-		for ci, f := range effective {
-			rect := canvas.NewRectangle(bg)
-			var cell fyne.CanvasObject
-
-			// map to original column index for width
-			widx := origIndexes[ci]
-			if widx >= len(colWidths) {
-				widx = len(colWidths) - 1
-			}
-
-			switch f.Type {
-			case "int":
-				if strings.EqualFold(f.Name, "ID") {
-					lbl := widget.NewLabel(fmt.Sprintf("%v", r.ID))
-					inner := container.NewVBox(layout.NewSpacer(), container.NewHBox(lbl), layout.NewSpacer())
-					cell = container.NewStack(inner)
-				} else {
-					val := ""
-					if v, ok := mergedData[f.Name]; ok {
-						switch t := v.(type) {
-						case float64:
-							val = strconv.Itoa(int(t))
-						case int:
-							val = strconv.Itoa(t)
-						case string:
-							val = t
-						}
-					}
-					entry := widget.NewEntry()
-					entry.SetText(val)
-					localID := r.ID
-					fieldName := f.Name
-					entry.OnChanged = func(s string) {
-						if s == "" {
-							s = "0"
-						}
-						v, err := strconv.Atoi(s)
-						if err != nil {
-							return
-						}
-						_ = updateField(db, localID, fieldName, v)
-					}
-					// make entry fill the full cell height so bottoms align
-					cell = container.NewStack(entry)
-				}
-			case "string":
-				val := ""
-				if v, ok := mergedData[f.Name]; ok {
-					if s, ok := v.(string); ok {
-						val = s
-					} else {
-						val = fmt.Sprintf("%v", v)
-					}
-				}
-				// multiline editor
-				entry := widget.NewMultiLineEntry()
-				entry.SetText(val)
-				localID := r.ID
-				fieldName := f.Name
-				entry.OnChanged = func(s string) {
-					_ = updateField(db, localID, fieldName, s)
-				}
-				// fill the cell
-				cell = container.NewStack(entry)
-			case "link":
-				// existing link handling builds `swap` (label/entry) and `overlay`
-				// ensure the visual content fills the cell by wrapping swap into Max
-				curText := ""
-				if v, ok := mergedData[f.Name]; ok {
-					if s, ok := v.(string); ok {
-						curText = s
-					} else {
-						curText = fmt.Sprintf("%v", v)
-					}
-				}
-				txtColor := color.NRGBA{R: 0, G: 0, B: 200, A: 255}
-				if curText != "" {
-					full := filepath.Join(exeDir, curText)
-					if _, err := os.Stat(full); err != nil {
-						txtColor = color.NRGBA{R: 200, G: 0, B: 0, A: 255}
-					}
-				}
-				label := canvas.NewText(curText, txtColor)
-				label.TextStyle = fyne.TextStyle{Italic: false}
-				// center label vertically within a vbox, then make it expand via Max
-				labelBox := container.NewVBox(layout.NewSpacer(), container.NewHBox(label), layout.NewSpacer())
-				swap := container.NewStack(labelBox) // will be swapped to entry when editing
-				var overlay *clickableOverlay
-				onLeft := func() {
-					entry := widget.NewEntry()
-					entry.SetText(label.Text)
-					localID := r.ID
-					fieldName := f.Name
-					entry.OnSubmitted = func(s string) {
-						_ = updateField(db, localID, fieldName, s)
-						cur := s
-						colorNow := color.NRGBA{R: 0, G: 0, B: 200, A: 255}
-						if cur != "" {
-							full := filepath.Join(exeDir, cur)
-							if _, err := os.Stat(full); err != nil {
-								colorNow = color.NRGBA{R: 200, G: 0, B: 0, A: 255}
-							}
-						}
-						label.Text = cur
-						label.Color = colorNow
-						label.Refresh()
-						swap.Objects = []fyne.CanvasObject{labelBox}
-						swap.Refresh()
-						if overlay != nil {
-							overlay.Show()
-						}
-					}
-					entry.OnChanged = func(s string) {
-						_ = updateField(db, localID, fieldName, s)
-					}
-					if overlay != nil {
-						overlay.Hide()
-					}
-					// replace with an entry that will fill the cell
-					swap.Objects = []fyne.CanvasObject{entry}
-					swap.Refresh()
-					// focus the entry so the user can type immediately
-					win.Canvas().Focus(entry)
-				}
-				onRight := func() {
-					target := filepath.Join(exeDir, label.Text)
-					if label.Text == "" {
-						dialog.ShowInformation("Open link", "No file specified", win)
-						return
-					}
-					if err := exec.Command("xdg-open", target).Start(); err != nil {
-						label.Color = color.NRGBA{R: 200, G: 0, B: 0, A: 255}
-						label.Refresh()
-						dialog.ShowError(fmt.Errorf("open failed: %w", err), win)
-					}
-				}
-				overlay = newClickableOverlay(onLeft, onRight)
-				// ensure swap + overlay fill the cell and align with neighbors
-				cell = container.NewStack(container.NewStack(swap, overlay))
-			case "[]string":
-				var list []string
-				if v, ok := mergedData[f.Name]; ok {
-					switch t := v.(type) {
-					case []interface{}:
-						for _, it := range t {
-							list = append(list, fmt.Sprintf("%v", it))
-						}
-					case []string:
-						list = append(list, t...)
-					default:
-						if tstr := fmt.Sprintf("%v", v); tstr != "" {
-							list = append(list, tstr)
-						}
-					}
-				}
-				editor := makeListEditorInline(win, db, r.ID, list, f.Name)
-				if sc, ok := editor.(*container.Scroll); ok {
-					visible := len(list)
-					if visible > listMaxVisible {
-						visible = listMaxVisible
-					}
-					if visible == 0 {
-						visible = 1
-					}
-					h := float32(visible)*listItemHeight + 6
-					sc.SetMinSize(fyne.NewSize(colWidths[widx], h))
-				}
-				// ensure the list editor fills the full cell height
-				cell = container.NewStack(editor)
-			default:
-				val := ""
-				if v, ok := mergedData[f.Name]; ok {
-					val = fmt.Sprintf("%v", v)
-				}
-				entry := widget.NewMultiLineEntry()
-				entry.SetText(val)
-				localID := r.ID
-				fieldName := f.Name
-				entry.OnChanged = func(s string) {
-					_ = updateField(db, localID, fieldName, s)
-				}
-				cell = container.NewStack(entry)
+			keySelect.Disable()
+		}
+	})
+	modeRadio.Required = true
+	modeRadio.SetSelected(importModeLabels[importAppend])
+
+	form := widget.NewForm(
+		widget.NewFormItem("Import mode", modeRadio),
+		widget.NewFormItem("Key field", keySelect),
+	)
+	selected := func() (string, string) {
+		for _, m := range modes {
+			if importModeLabels[m] == modeRadio.Selected {
+				return m, byLabel[keySelect.Selected].Name
 			}
+		}
+		return importAppend, ""
+	}
+	return form, selected
+}
+
+// confirmImport shows the dry-run summary of the plans (one per sheet) and applies
+// them in one transaction when confirmed. onDone runs after a successful commit.
+func confirmImport(win fyne.Window, db *sql.DB, plans []*importPlan, note string, onDone func()) {
+	text := importSummary(plans)
+	if note != "" {
+		text = note + "\n\n" + text
+	}
+	summary := widget.NewLabel(text)
+	summary.Wrapping = fyne.TextWrapWord
+	d := dialog.NewCustomConfirm("Import preview", "Import", "Cancel", container.NewVScroll(summary), func(yes bool) {
+		if !yes {
+			return
+		}
+		if err := applyImport(db, plans...); err != nil {
+			dialog.ShowError(fmt.Errorf("import rolled back: %w", err), win)
+			return
+		}
+		onDone()
+	}, win)
+	d.Resize(fyne.NewSize(480, 380))
+	d.Show()
+}
+
+// showCSVImportReport shows how many rows were imported and lists the rows that were not
+func showCSVImportReport(win fyne.Window, imported int, rowErrs []csvRowError) {
+	msg := fmt.Sprintf("Imported %d rows.", imported)
+	if len(rowErrs) == 0 {
+		dialog.ShowInformation("Import CSV", msg, win)
+		return
+	}
+	var b strings.Builder
+	for _, e := range rowErrs {
+		b.WriteString(e.String())
+		b.WriteString("\n")
+	}
+	details := widget.NewMultiLineEntry()
+	details.SetText(b.String())
+	details.Disable()
+	content := container.NewBorder(
+		widget.NewLabel(fmt.Sprintf("%s %d rows could not be converted:", msg, len(rowErrs))),
+		nil, nil, nil, details)
+	d := dialog.NewCustom("Import CSV", "OK", content, win)
+	d.Resize(fyne.NewSize(560, 400))
+	d.Show()
+}
 
-			cellWrap := container.New(layout.NewGridWrapLayout(fyne.NewSize(colWidths[widx], rowH)), container.NewStack(rect, cell))
-			rowBox.Add(cellWrap)
+// showSchemaChoice asks whether to use the schema stored in the database, the one
+// in config.json or a merge of both, and calls onChoice with schemaStored,
+// schemaFile or schemaMerge.
+func showSchemaChoice(win fyne.Window, stored, file []FieldDef, onChoice func(string)) {
+	intro := widget.NewLabel("This database was saved with a schema that differs from config.json.")
+	intro.Wrapping = fyne.TextWrapWord
+	diff := widget.NewLabel(schemaDiff(stored, file))
+	diff.Wrapping = fyne.TextWrapWord
+	help := widget.NewLabel("Database: show the file as it was saved.\n" +
+		"config.json: migrate the rows to the config's fields.\n" +
+		"Merge: the config's fields plus the fields only the database has.")
+	help.Wrapping = fyne.TextWrapWord
+
+	var d *dialog.CustomDialog
+	choose := func(source string) func() {
+		return func() {
+			d.Hide()
+			onChoice(source)
+		}
+	}
+	buttons := []fyne.CanvasObject{
+		widget.NewButton("Use database schema", choose(schemaStored)),
+		widget.NewButton("Use config.json", choose(schemaFile)),
+		widget.NewButton("Merge", choose(schemaMerge)),
+	}
+	content := container.NewBorder(intro, help, nil, nil, container.NewVScroll(diff))
+	d = dialog.NewCustomWithoutButtons("Schema differs", content, win)
+	d.SetButtons(buttons)
+	d.Resize(fyne.NewSize(560, 420))
+	d.Show()
+}
 
-			// add an invisible spacer matching the resizer width used in the header so columns align
-			// only add spacer for data columns (header had a resizer after each data column)
-			spacer := container.New(layout.NewGridWrapLayout(fyne.NewSize(resizerWidth, rowH)), canvas.NewRectangle(color.Transparent))
-			rowBox.Add(spacer)
+// showRelationPicker lets the user search the rows of the target sheet of relation
+// field f and pick one, or with f.Multiple several, of them. onDone gets the chosen ids.
+func showRelationPicker(win fyne.Window, db *sql.DB, target int, f FieldDef, selected []int, onDone func([]int)) {
+	chosen := append([]int(nil), selected...)
+	isChosen := func(id int) int {
+		for i, c := range chosen {
+			if c == id {
+				return i
+			}
 		}
+		return -1
+	}
 
-		// Actions (trash icon button)
-		trash := widget.NewButton("🗑", func() {
-			/*dialog.ShowConfirm("Delete", "Delete this row?", func(yes bool) {
-			if !yes {
-				return
-			}*/
-			_ = deleteRow(db, r.ID)
-			populateTableGrid(win, rowsContainer, db, schema, colWidths, visibleCols)
-		}) //, win)
-		//}
+	var d *dialog.CustomDialog
+	var choices []relationChoice
+	list := widget.NewList(
+		func() int { return len(choices) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			c := choices[i]
+			text := c.Label
+			if f.Display != "" {
+				text += fmt.Sprintf("  (#%d)", c.ID)
+			}
+			if isChosen(c.ID) >= 0 {
+				text = "✓ " + text
+			}
+			o.(*widget.Label).SetText(text)
+		})
+	list.OnSelected = func(i widget.ListItemID) {
+		list.UnselectAll()
+		id := choices[i].ID
+		if !f.Multiple {
+			d.Hide()
+			onDone([]int{id})
+			return
+		}
+		if at := isChosen(id); at >= 0 {
+			chosen = append(chosen[:at], chosen[at+1:]...)
+		} else {
+			chosen = append(chosen, id)
+		}
+		list.RefreshItem(i)
+	}
+
+	status := widget.NewLabel("")
+	search := widget.NewEntry()
+	search.SetPlaceHolder("Search")
+	search.OnChanged = func(q string) {
+		var err error
+		choices, err = relationChoices(db, target, f, q)
+		switch {
+		case err != nil:
+			status.SetText(err.Error())
+		case len(choices) == relationChoiceLimit:
+			status.SetText(fmt.Sprintf("Showing the first %d matches", relationChoiceLimit))
+		default:
+			status.SetText(fmt.Sprintf("%d matches", len(choices)))
+		}
+		list.Refresh()
+	}
+	search.OnChanged("")
+
+	buttons := []fyne.CanvasObject{
+		widget.NewButton("Cancel", func() { d.Hide() }),
+		widget.NewButton("Clear", func() {
+			d.Hide()
+			onDone(nil)
+		}),
+	}
+	if f.Multiple {
+		ok := widget.NewButton("OK", func() {
+			d.Hide()
+			onDone(chosen)
+		})
+		ok.Importance = widget.HighImportance
+		buttons = append(buttons, ok)
+	}
+	d = dialog.NewCustomWithoutButtons("Choose "+f.Label, container.NewBorder(search, status, nil, nil, list), win)
+	d.SetButtons(buttons)
+	d.Resize(fyne.NewSize(420, 480))
+	d.Show()
+	win.Canvas().Focus(search)
+}
+
+// showBulkSetDialog asks for a field and a value and sets it in the rows of ids
+func showBulkSetDialog(win fyne.Window, db *sql.DB, schema []FieldDef, ids []int, onDone func()) {
+	var editable []FieldDef
+	for _, f := range schema {
+		if isEditableField(f) {
+			editable = append(editable, f)
+		}
+	}
+	opts, byLabel := fieldSelectOptions(editable)
+	fieldSelect := widget.NewSelect(opts, nil)
+	value := widget.NewEntry()
+	value.SetPlaceHolder("Lists and relation ids are separated by ;")
+	form := widget.NewForm(
+		widget.NewFormItem("Field", fieldSelect),
+		widget.NewFormItem("Value", value),
+	)
+	d := dialog.NewCustomConfirm(fmt.Sprintf("Set field in %d rows", len(ids)), "Set", "Cancel", form, func(yes bool) {
+		if !yes {
+			return
+		}
+		f, ok := byLabel[fieldSelect.Selected]
+		if !ok {
+			dialog.ShowError(fmt.Errorf("choose a field"), win)
+			return
+		}
+		v, err := parseFieldValue(f, value.Text, ";")
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("%s: %w", f.Label, err), win)
+			return
+		}
+		if err := bulkSetField(db, schema, ids, f.Name, v); err != nil {
+			dialog.ShowError(err, win)
+			return
+		}
+		onDone()
+	}, win)
+	d.Resize(fyne.NewSize(420, 220))
+	d.Show()
+}
+
+// showBulkListDialog asks for a list field and an item to add to (or remove from)
+// the rows of ids
+func showBulkListDialog(win fyne.Window, db *sql.DB, schema []FieldDef, ids []int, remove bool, onDone func()) {
+	var lists []FieldDef
+	for _, f := range schema {
+		if f.Type == "[]string" {
+			lists = append(lists, f)
+		}
+	}
+	if len(lists) == 0 {
+		dialog.ShowInformation("Lists", "This sheet has no list fields.", win)
+		return
+	}
+	opts, byLabel := fieldSelectOptions(lists)
+	fieldSelect := widget.NewSelect(opts, nil)
+	fieldSelect.SetSelected(opts[0])
+	item := widget.NewEntry()
+	title, action := fmt.Sprintf("Add item to %d rows", len(ids)), "Add"
+	if remove {
+		title, action = fmt.Sprintf("Remove item from %d rows", len(ids)), "Remove"
+	}
+	form := widget.NewForm(
+		widget.NewFormItem("Field", fieldSelect),
+		widget.NewFormItem("Item", item),
+	)
+	d := dialog.NewCustomConfirm(title, action, "Cancel", form, func(yes bool) {
+		if !yes {
+			return
+		}
+		f := byLabel[fieldSelect.Selected]
+		if err := bulkListItem(db, schema, ids, f.Name, item.Text, remove); err != nil {
+			dialog.ShowError(err, win)
+			return
+		}
+		onDone()
+	}, win)
+	d.Resize(fyne.NewSize(420, 220))
+	d.Show()
+}
+
+// showReplaceDialog asks for a find and replace over the text cells of sheet, previews
+// every changed cell and applies them as one undo step. v is the view on screen.
+func showReplaceDialog(win fyne.Window, db *sql.DB, sheet int, schema []FieldDef, v View, onDone func()) {
+	find := widget.NewEntry()
+	replace := widget.NewEntry()
+	replace.SetPlaceHolder("With a regular expression, $1 inserts the first group")
+	caseCheck := widget.NewCheck("Match case", nil)
+	wordCheck := widget.NewCheck("Whole words", nil)
+	regexCheck := widget.NewCheck("Regular expression", nil)
+
+	// columns for the "Selected columns" scope
+	checks := map[string]*widget.Check{}
+	colsBox := container.NewVBox()
+	for _, f := range schema {
+		if isReplaceable(f) {
+			checks[f.Name] = widget.NewCheck(f.Label, nil)
+			colsBox.Add(checks[f.Name])
+		}
+	}
+	colsBox.Hide()
+
+	scopes := map[string]string{"Current view": replaceScopeView, "Selected columns": replaceScopeColumns, "All fields": replaceScopeAll}
+	scope := widget.NewRadioGroup([]string{"Current view", "Selected columns", "All fields"}, func(sel string) {
+		if scopes[sel] == replaceScopeColumns {
+			colsBox.Show()
+		} else {
+			colsBox.Hide()
+		}
+	})
+	scope.Horizontal = true
+	scope.Required = true
+	scope.SetSelected("Current view")
+
+	options := func() replaceOptions {
+		o := replaceOptions{
+			Find: find.Text, Replace: replace.Text, Scope: scopes[scope.Selected],
+			CaseSensitive: caseCheck.Checked, WholeWord: wordCheck.Checked, Regex: regexCheck.Checked,
+		}
+		for _, f := range schema {
+			if ch, ok := checks[f.Name]; ok && ch.Checked {
+				o.Fields = append(o.Fields, f.Name)
+			}
+		}
+		return o
+	}
+
+	form := container.NewVBox(
+		widget.NewLabel("Find:"), find,
+		widget.NewLabel("Replace with:"), replace,
+		container.NewHBox(caseCheck, wordCheck, regexCheck),
+		widget.NewLabel("Scope:"), scope, colsBox,
+	)
+	d := dialog.NewCustomConfirm("Find and replace", "Preview", "Cancel", container.NewVScroll(form), func(yes bool) {
+		if !yes {
+			return
+		}
+		o := options()
+		changes, err := planReplace(db, sheet, schema, v, o)
+		if err != nil {
+			dialog.ShowError(err, win)
+			return
+		}
+		if len(changes) == 0 {
+			dialog.ShowInformation("Find and replace", fmt.Sprintf("No cells contain %q.", o.Find), win)
+			return
+		}
+		showReplacePreview(win, db, schema, o.Find, changes, onDone)
+	}, win)
+	d.Resize(fyne.NewSize(560, 420))
+	d.Show()
+	win.Canvas().Focus(find)
+}
+
+// showReplacePreview lists the changed cells of a find and replace and applies them on confirm
+func showReplacePreview(win fyne.Window, db *sql.DB, schema []FieldDef, find string, changes []replaceChange, onDone func()) {
+	byName := map[string]FieldDef{}
+	for _, f := range schema {
+		byName[f.Name] = f
+	}
+	rows := map[int]bool{}
+	for _, c := range changes {
+		rows[c.ID] = true
+	}
+	list := widget.NewList(
+		func() int { return len(changes) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			c := changes[i]
+			f := byName[c.Field]
+			o.(*widget.Label).SetText(fmt.Sprintf("#%d %s: %s → %s", c.ID, f.Label,
+				formatFieldValue(f, c.Before, ", "), formatFieldValue(f, c.After, ", ")))
+		})
+	header := widget.NewLabel(fmt.Sprintf("%d cells in %d rows will change:", len(changes), len(rows)))
+	d := dialog.NewCustomConfirm("Replace preview", "Replace", "Cancel", container.NewBorder(header, nil, nil, nil, list), func(yes bool) {
+		if !yes {
+			return
+		}
+		if err := applyReplace(db, schema, find, changes); err != nil {
+			dialog.ShowError(fmt.Errorf("replace rolled back: %w", err), win)
+			return
+		}
+		onDone()
+	}, win)
+	d.Resize(fyne.NewSize(640, 460))
+	d.Show()
+}
 
-		actWrap := container.New(layout.NewGridWrapLayout(fyne.NewSize(colWidths[len(colWidths)-1], float32(rowH))), container.NewStack(canvas.NewRectangle(bg), trash))
-		rowBox.Add(actWrap)
+// showSchemaMigration asks what happens to stored fields that config.json no longer has,
+// previews the conversions and applies them. onDone runs afterwards, also when skipped.
+func showSchemaMigration(win fyne.Window, db *sql.DB, m *schemaMigration, onDone func()) {
+	const (
+		removeLabel = "Remove key"
+		keepLabel   = "Keep key as is"
+		renamePfx   = "Rename to "
+	)
+	newByName := map[string]FieldDef{}
+	for _, f := range m.New {
+		newByName[f.Name] = f
+	}
+	added := m.AddedFields()
+
+	form := widget.NewForm()
+	for i := range m.Changes {
+		c := &m.Changes[i]
+		switch {
+		case c.Action == migrateConvert:
+			form.Append(c.Field.Name, widget.NewLabel(fmt.Sprintf("convert %s -> %s", c.Field.Type, newByName[c.Target].Type)))
+			continue
+		case c.Action == migrateKeep && c.Target != "":
+			continue // unchanged field
+		}
 
-		rowsContainer.Add(rowBox)
+		opts := []string{removeLabel, keepLabel}
+		for _, f := range added {
+			opts = append(opts, renamePfx+f.Name)
+		}
+		sel := widget.NewSelect(opts, func(s string) {
+			switch {
+			case s == removeLabel:
+				c.Action, c.Target = migrateRemove, ""
+			case s == keepLabel:
+				c.Action, c.Target = migrateKeep, ""
+			default:
+				c.Action, c.Target = migrateRename, strings.TrimPrefix(s, renamePfx)
+			}
+		})
+		switch c.Action {
+		case migrateRename:
+			sel.SetSelected(renamePfx + c.Target)
+		case migrateKeep:
+			sel.SetSelected(keepLabel)
+		default:
+			sel.SetSelected(removeLabel)
+		}
+		form.Append(fmt.Sprintf("%s (%s)", c.Field.Name, c.Field.Type), sel)
 	}
 
-	rowsContainer.Refresh()
+	sepEntry := widget.NewEntry()
+	sepEntry.SetText(m.ListSep)
+	sepEntry.OnChanged = func(s string) { m.ListSep = s }
+	form.Append("List separator", sepEntry)
+
+	report := widget.NewLabel(m.Summary(nil))
+	report.Wrapping = fyne.TextWrapWord
+	previewBtn := widget.NewButton("Preview", func() {
+		rep, err := previewSchemaMigration(db, m)
+		if err != nil {
+			dialog.ShowError(err, win)
+			return
+		}
+		report.SetText(m.Summary(rep))
+	})
+
+	intro := widget.NewLabel("The chosen fields differ from the fields stored in the database.")
+	intro.Wrapping = fyne.TextWrapWord
+	content := container.NewBorder(
+		container.NewVBox(intro, form, previewBtn), nil, nil, nil,
+		container.NewVScroll(report))
+	d := dialog.NewCustomConfirm("Schema changed", "Apply", "Skip", content, func(yes bool) {
+		if !yes {
+			onDone()
+			return
+		}
+		rep, err := applySchemaMigration(db, m)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("schema migration rolled back: %w", err), win)
+			onDone()
+			return
+		}
+		onDone()
+		if len(rep.Failures) > 0 {
+			details := widget.NewMultiLineEntry()
+			details.SetText(m.Summary(rep))
+			details.Disable()
+			fd := dialog.NewCustom("Schema migration", "OK", details, win)
+			fd.Resize(fyne.NewSize(560, 400))
+			fd.Show()
+		}
+	}, win)
+	d.Resize(fyne.NewSize(560, 520))
+	d.Show()
 }
 
-// makeListEditorInline is an inline vertical editor for []string that persists on change.
+// makeListEditorInline is an inline vertical editor for []string that calls onSave on change.
+// newEntry creates the item entries.
 // behavior:
 // - trailing blank entry always present for quick add
-// - pressing Enter on last entry appends new blank
+// - pressing Enter moves to the next entry, on the last one it appends a new blank
 // - empty non-last entries are removed
-func makeListEditorInline(win fyne.Window, db *sql.DB, entryID int, initial []string, fieldName string) fyne.CanvasObject {
+func makeListEditorInline(win fyne.Window, initial []string, newEntry func() *cellEntry, onSave func([]string)) fyne.CanvasObject {
 	listContainer := container.NewVBox()
-	entries := make([]*widget.Entry, 0, len(initial)+1)
+	entries := make([]*cellEntry, 0, len(initial)+1)
 
 	save := func() {
 		var out []string
@@ -933,12 +1350,12 @@ func makeListEditorInline(win fyne.Window, db *sql.DB, entryID int, initial []st
 				out = append(out, s)
 			}
 		}
-		_ = updateField(db, entryID, fieldName, out)
+		onSave(out)
 	}
 
-	var createEntry func(string) *widget.Entry
-	createEntry = func(text string) *widget.Entry {
-		e := widget.NewEntry()
+	var createEntry func(string) *cellEntry
+	createEntry = func(text string) *cellEntry {
+		e := newEntry()
 		e.SetText(text)
 
 		e.OnSubmitted = func(sub string) {
@@ -952,6 +1369,12 @@ func makeListEditorInline(win fyne.Window, db *sql.DB, entryID int, initial []st
 				listContainer.Add(entries[len(entries)-1])
 			}
 			save()
+			for i, en := range entries[:len(entries)-1] {
+				if en == e {
+					win.Canvas().Focus(entries[i+1])
+					break
+				}
+			}
 		}
 
 		e.OnChanged = func(_ string) {
@@ -999,3 +1422,371 @@ func makeListEditorInline(win fyne.Window, db *sql.DB, entryID int, initial []st
 	scroll.SetMinSize(fyne.NewSize(140, 30))
 	return scroll
 }
+
+// filterOpLabels are the labels shown for the filter operators in the Edit View dialog
+var filterOpLabels = map[string]string{
+	filterEquals:       "equals",
+	filterContains:     "contains",
+	filterGreater:      "greater than",
+	filterLess:         "less than",
+	filterListContains: "list contains",
+	filterEmpty:        "is empty",
+	filterNotEmpty:     "is not empty",
+}
+
+// filterOpsForType returns the filter operators offered for a field type
+func filterOpsForType(typ string) []string {
+	switch typ {
+	case "int", "float", "date", "datetime":
+		return []string{filterEquals, filterGreater, filterLess}
+	case "formula":
+		return []string{filterEquals, filterGreater, filterLess, filterContains, filterEmpty, filterNotEmpty}
+	case "bool":
+		return []string{filterEquals}
+	case "[]string", "relation":
+		return []string{filterListContains, filterEmpty, filterNotEmpty}
+	case "link":
+		return []string{filterEquals, filterContains, filterEmpty, filterNotEmpty}
+	default:
+		return []string{filterEquals, filterContains, filterEmpty, filterNotEmpty}
+	}
+}
+
+// fieldSelectOptions returns the schema labels for a Select plus a label -> field lookup
+func fieldSelectOptions(schema []FieldDef) ([]string, map[string]FieldDef) {
+	opts := make([]string, 0, len(schema))
+	byLabel := map[string]FieldDef{}
+	for _, f := range schema {
+		opts = append(opts, f.Label)
+		byLabel[f.Label] = f
+	}
+	return opts, byLabel
+}
+
+// makeFilterEditor builds the filter rules section of the Edit View dialog.
+// The returned func collects the rules as currently entered (incomplete rows are skipped).
+func makeFilterEditor(schema []FieldDef, initial []ViewFilter) (fyne.CanvasObject, func() []ViewFilter) {
+	fieldOpts, byLabel := fieldSelectOptions(schema)
+	rowsBox := container.NewVBox()
+
+	type filterRow struct {
+		field *widget.Select
+		op    *widget.Select
+		value *widget.Entry
+		box   *fyne.Container
+	}
+	var rows []*filterRow
+
+	addRow := func(flt ViewFilter) {
+		fr := &filterRow{value: widget.NewEntry()}
+		fr.value.SetPlaceHolder("value")
+		fr.value.SetText(flt.Value)
+
+		fr.op = widget.NewSelect(nil, func(label string) {
+			// empty checks take no value
+			if label == filterOpLabels[filterEmpty] || label == filterOpLabels[filterNotEmpty] {
+				fr.value.Hide()
+			} else {
+				fr.value.Show()
+			}
+		})
+		setOps := func(f FieldDef, sel string) {
+			var labels []string
+			for _, op := range filterOpsForType(f.Type) {
+				labels = append(labels, filterOpLabels[op])
+			}
+			fr.op.Options = labels
+			if sel == "" {
+				sel = labels[0]
+			}
+			fr.op.SetSelected(sel)
+		}
+		fr.field = widget.NewSelect(fieldOpts, func(label string) {
+			setOps(byLabel[label], "")
+		})
+
+		for _, f := range schema {
+			if f.Name == flt.Field {
+				fr.field.Selected = f.Label
+				setOps(f, filterOpLabels[flt.Op])
+				break
+			}
+		}
+
+		removeBtn := widget.NewButton("✕", nil)
+		fr.box = container.NewBorder(nil, nil, container.NewHBox(fr.field, fr.op), removeBtn, fr.value)
+		removeBtn.OnTapped = func() {
+			for i, r := range rows {
+				if r == fr {
+					rows = append(rows[:i], rows[i+1:]...)
+					break
+				}
+			}
+			rowsBox.Remove(fr.box)
+		}
+		rows = append(rows, fr)
+		rowsBox.Add(fr.box)
+	}
+
+	for _, flt := range initial {
+		addRow(flt)
+	}
+	addBtn := widget.NewButton("Add filter", func() { addRow(ViewFilter{}) })
+
+	collect := func() []ViewFilter {
+		var out []ViewFilter
+		for _, r := range rows {
+			f, ok := byLabel[r.field.Selected]
+			if !ok {
+				continue
+			}
+			op := ""
+			for code, label := range filterOpLabels {
+				if label == r.op.Selected {
+					op = code
+				}
+			}
+			if op == "" {
+				continue
+			}
+			val := r.value.Text
+			if op == filterEmpty || op == filterNotEmpty {
+				val = ""
+			}
+			out = append(out, ViewFilter{Field: f.Name, Op: op, Value: val})
+		}
+		return out
+	}
+	return container.NewVBox(rowsBox, container.NewHBox(addBtn)), collect
+}
+
+// makeSortEditor builds the ordered sort keys section of the Edit View dialog.
+// The first key is the primary sort; ▲ moves a key one position up.
+func makeSortEditor(schema []FieldDef, initial []SortKey) (fyne.CanvasObject, func() []SortKey) {
+	fieldOpts, byLabel := fieldSelectOptions(schema)
+	rowsBox := container.NewVBox()
+
+	type sortRow struct {
+		field *widget.Select
+		dir   *widget.Select
+		box   *fyne.Container
+	}
+	var rows []*sortRow
+
+	relayout := func() {
+		rowsBox.Objects = nil
+		for _, r := range rows {
+			rowsBox.Add(r.box)
+		}
+		rowsBox.Refresh()
+	}
+
+	addRow := func(k SortKey) {
+		sr := &sortRow{
+			field: widget.NewSelect(fieldOpts, nil),
+			dir:   widget.NewSelect([]string{"ascending", "descending"}, nil),
+		}
+		for _, f := range schema {
+			if f.Name == k.Field {
+				sr.field.Selected = f.Label
+				break
+			}
+		}
+		if k.Desc {
+			sr.dir.Selected = "descending"
+		} else {
+			sr.dir.Selected = "ascending"
+		}
+
+		upBtn := widget.NewButton("▲", func() {
+			for i, r := range rows {
+				if r == sr && i > 0 {
+					rows[i-1], rows[i] = rows[i], rows[i-1]
+					relayout()
+					return
+				}
+			}
+		})
+		removeBtn := widget.NewButton("✕", func() {
+			for i, r := range rows {
+				if r == sr {
+					rows = append(rows[:i], rows[i+1:]...)
+					relayout()
+					return
+				}
+			}
+		})
+		sr.box = container.NewHBox(sr.field, sr.dir, upBtn, removeBtn)
+		rows = append(rows, sr)
+		relayout()
+	}
+
+	for _, k := range initial {
+		addRow(k)
+	}
+	addBtn := widget.NewButton("Add sort key", func() { addRow(SortKey{}) })
+
+	collect := func() []SortKey {
+		var out []SortKey
+		for _, r := range rows {
+			f, ok := byLabel[r.field.Selected]
+			if !ok {
+				continue
+			}
+			out = append(out, SortKey{Field: f.Name, Desc: r.dir.Selected == "descending"})
+		}
+		return out
+	}
+	return container.NewVBox(rowsBox, container.NewHBox(addBtn)), collect
+}
+
+// makeFormLayoutEditor builds the row form section of the Edit View dialog: one
+// line per field with a check to show it, the title of its group and ▲ to move it
+// up. Fields with the same group title are shown together, groups in the order of
+// their first field. The default layout (every field in schema order, no titles)
+// is stored as no layout.
+func makeFormLayoutEditor(schema []FieldDef, initial []FormGroup) (fyne.CanvasObject, func() []FormGroup) {
+	rowsBox := container.NewVBox()
+
+	type formRow struct {
+		field FieldDef
+		show  *widget.Check
+		group *widget.Entry
+		box   *fyne.Container
+	}
+	var rows []*formRow
+
+	relayout := func() {
+		rowsBox.Objects = nil
+		for _, r := range rows {
+			rowsBox.Add(r.box)
+		}
+		rowsBox.Refresh()
+	}
+
+	addRow := func(f FieldDef, shown bool, title string) {
+		fr := &formRow{field: f, show: widget.NewCheck(f.Label, nil), group: widget.NewEntry()}
+		fr.show.SetChecked(shown)
+		fr.group.SetPlaceHolder("Group")
+		fr.group.SetText(title)
+		upBtn := widget.NewButton("▲", func() {
+			for i, r := range rows {
+				if r == fr && i > 0 {
+					rows[i-1], rows[i] = rows[i], rows[i-1]
+					relayout()
+					return
+				}
+			}
+		})
+		fr.box = container.NewBorder(nil, nil, fr.show, upBtn, fr.group)
+		rows = append(rows, fr)
+	}
+
+	// fields of the stored layout first, then the ones it leaves out
+	byName := map[string]FieldDef{}
+	for _, f := range schema {
+		byName[f.Name] = f
+	}
+	placed := map[string]bool{}
+	for _, g := range initial {
+		for _, name := range g.Fields {
+			if f, ok := byName[name]; ok && !placed[name] {
+				placed[name] = true
+				addRow(f, true, g.Title)
+			}
+		}
+	}
+	for _, f := range schema {
+		if !placed[f.Name] {
+			addRow(f, len(initial) == 0, "")
+		}
+	}
+	relayout()
+
+	collect := func() []FormGroup {
+		var out []FormGroup
+		index := map[string]int{}
+		isDefault := len(rows) == len(schema)
+		for i, r := range rows {
+			if !r.show.Checked {
+				isDefault = false
+				continue
+			}
+			title := strings.TrimSpace(r.group.Text)
+			if title != "" || r.field.Name != schema[i].Name {
+				isDefault = false
+			}
+			gi, ok := index[title]
+			if !ok {
+				gi = len(out)
+				index[title] = gi
+				out = append(out, FormGroup{Title: title})
+			}
+			out[gi].Fields = append(out[gi].Fields, r.field.Name)
+		}
+		if isDefault {
+			return nil
+		}
+		return out
+	}
+	return rowsBox, collect
+}
+
+// showRowHistory lists the recorded changes of a row, newest first. Restoring a
+// revision brings the whole row back to its state right after that change;
+// onRestored refreshes the grid.
+func showRowHistory(win fyne.Window, db *sql.DB, id int, onRestored func()) {
+	revs, err := getRowRevisions(db, id)
+	if err != nil {
+		dialog.ShowError(err, win)
+		return
+	}
+
+	list := container.NewVBox()
+	var d dialog.Dialog
+	for _, rev := range revs {
+		when := rev.ChangedAt.Local().Format("2006-01-02 15:04:05")
+		var text string
+		switch {
+		case rev.Field == "":
+			text = fmt.Sprintf("%s · %s · row deleted", when, rev.User)
+		case rev.OldValue == "":
+			text = fmt.Sprintf("%s · %s · %s set to %s", when, rev.User, rev.Field, rev.NewValue)
+		default:
+			text = fmt.Sprintf("%s · %s · %s: %s → %s", when, rev.User, rev.Field, rev.OldValue, rev.NewValue)
+		}
+		label := widget.NewLabel(text)
+		label.Wrapping = fyne.TextWrapWord
+
+		revID := rev.ID
+		restoreBtn := widget.NewButton("Restore", func() {
+			dialog.ShowConfirm("Restore revision", "Restore the row to its state after this change?", func(yes bool) {
+				if !yes {
+					return
+				}
+				state, err := rowStateAtRevision(db, id, revID)
+				if err != nil {
+					dialog.ShowError(err, win)
+					return
+				}
+				if err := undoableReplaceRow(db, id, state); err != nil {
+					dialog.ShowError(err, win)
+					return
+				}
+				d.Hide()
+				onRestored()
+			}, win)
+		})
+		if rev.Field == "" {
+			restoreBtn.Disable()
+		}
+		list.Add(container.NewBorder(nil, nil, nil, restoreBtn, label))
+	}
+	if len(revs) == 0 {
+		list.Add(widget.NewLabel("No changes recorded for this row."))
+	}
+
+	d = dialog.NewCustom(fmt.Sprintf("History of row %d", id), "Close", container.NewVScroll(list), win)
+	d.Resize(fyne.NewSize(640, 460))
+	d.Show()
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// undo history is stored in the undo_log table so it survives restarts.
+// Every step holds the before/after state of each record it touched; undo writes
+// the before states back, redo the after states. Steps with undone = 1 form the
+// redo stack and are discarded as soon as a new step is recorded.
+// Each sheet has its own stack: a step belongs to the sheet of its first record.
+
+const (
+	undoMaxSteps      = 500             // per sheet, older steps are pruned
+	undoCoalesceDelay = 5 * time.Second // consecutive edits of one cell within this delay form one step
+)
+
+// undoOp is the state of one record before and after a step; nil means the record did not exist
+type undoOp struct {
+	Table  string // "entries" or "views"
+	ID     int
+	Sheet  int `json:",omitempty"` // sheet of the record; 0 in steps from before sheets
+	Before *string
+	After  *string
+}
+
+// undoRecorder collects the records touched by one user action inside a transaction
+type undoRecorder struct {
+	tx    *sql.Tx
+	ops   []undoOp
+	index map[string]int
+}
+
+func opKey(table string, id int) string {
+	return fmt.Sprintf("%s/%d", table, id)
+}
+
+// snapshotRecord returns the stored state of a record, or nil if it does not exist.
+// Views are stored as {"Name":..., "Data":...} so both columns can be restored.
+func snapshotRecord(db sqlExecer, table string, id int) (*string, error) {
+	switch table {
+	case "entries":
+		var data string
+		err := db.QueryRow("SELECT data FROM entries WHERE id = ?", id).Scan(&data)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &data, nil
+	case "views":
+		var name, data string
+		err := db.QueryRow("SELECT name, data FROM views WHERE id = ?", id).Scan(&name, &data)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		js, err := json.Marshal(map[string]string{"Name": name, "Data": data})
+		if err != nil {
+			return nil, err
+		}
+		s := string(js)
+		return &s, nil
+	}
+	return nil, fmt.Errorf("undo: unknown table %q", table)
+}
+
+// recordSheet returns the sheet of a record, 0 if it does not exist
+func recordSheet(db sqlExecer, table string, id int) (int, error) {
+	var sheet int
+	err := db.QueryRow("SELECT sheet_id FROM "+table+" WHERE id = ?", id).Scan(&sheet)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return sheet, err
+}
+
+// restoreRecord writes a snapshot back into sheet; nil deletes the record.
+// Row changes are recorded in the revisions table like any other edit.
+func restoreRecord(db sqlExecer, table string, id, sheet int, state *string) error {
+	if table == "entries" {
+		if state == nil {
+			return deleteRow(db, id)
+		}
+		before, err := loadRowData(db, id)
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec("INSERT OR REPLACE INTO entries (id, data, sheet_id) VALUES (?, ?, ?)", id, *state, sheet); err != nil {
+			return err
+		}
+		after := map[string]interface{}{}
+		_ = json.Unmarshal([]byte(*state), &after)
+		return recordRowChange(db, id, before, after, false)
+	}
+	if state == nil {
+		_, err := db.Exec("DELETE FROM "+table+" WHERE id = ?", id)
+		return err
+	}
+	switch table {
+	case "views":
+		var v map[string]string
+		if err := json.Unmarshal([]byte(*state), &v); err != nil {
+			return err
+		}
+		_, err := db.Exec("INSERT OR REPLACE INTO views (id, name, data, sheet_id) VALUES (?, ?, ?, ?)", id, v["Name"], v["Data"], sheet)
+		return err
+	}
+	return fmt.Errorf("undo: unknown table %q", table)
+}
+
+// touch records the current state of a record before it is modified.
+// Only the first call per record counts, so a step always reverts to the original state.
+// A nil recorder records nothing, for writes that are not undoable.
+func (r *undoRecorder) touch(table string, id int) error {
+	if r == nil {
+		return nil
+	}
+	k := opKey(table, id)
+	if _, ok := r.index[k]; ok {
+		return nil
+	}
+	before, err := snapshotRecord(r.tx, table, id)
+	if err != nil {
+		return err
+	}
+	sheet, err := recordSheet(r.tx, table, id)
+	if err != nil {
+		return err
+	}
+	r.index[k] = len(r.ops)
+	r.ops = append(r.ops, undoOp{Table: table, ID: id, Sheet: sheet, Before: before})
+	return nil
+}
+
+// created records a record that did not exist before this step
+func (r *undoRecorder) created(table string, id int) {
+	if r == nil {
+		return
+	}
+	k := opKey(table, id)
+	if _, ok := r.index[k]; ok {
+		return
+	}
+	// finish fails on the same query if the transaction broke
+	sheet, _ := recordSheet(r.tx, table, id)
+	r.index[k] = len(r.ops)
+	r.ops = append(r.ops, undoOp{Table: table, ID: id, Sheet: sheet})
+}
+
+// touchAll records every record of a table in a sheet, used before bulk deletes
+func (r *undoRecorder) touchAll(table string, sheet int) error {
+	if r == nil {
+		return nil
+	}
+	rows, err := r.tx.Query("SELECT id FROM "+table+" WHERE sheet_id = ?", sheet)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := r.touch(table, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish captures the after states and stores the step. Records that ended up
+// unchanged are dropped. If coalesce is set and the latest step has the same key
+// and is recent, the ops are merged into it instead of creating a new step.
+func (r *undoRecorder) finish(label, coalesce string) error {
+	var ops []undoOp
+	for _, op := range r.ops {
+		after, err := snapshotRecord(r.tx, op.Table, op.ID)
+		if err != nil {
+			return err
+		}
+		op.After = after
+		if sameState(op.Before, op.After) {
+			continue
+		}
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	sheet := 0
+	for _, op := range ops {
+		if op.Sheet != 0 {
+			sheet = op.Sheet
+			break
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	if coalesce != "" {
+		var lastID int
+		var lastKey string
+		var lastAt int64
+		var lastOps string
+		err := r.tx.QueryRow("SELECT id, coalesce_key, updated_at, ops FROM undo_log WHERE undone = 0 AND sheet_id = ? ORDER BY id DESC LIMIT 1", sheet).Scan(&lastID, &lastKey, &lastAt, &lastOps)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil && lastKey == coalesce && now-lastAt <= undoCoalesceDelay.Milliseconds() {
+			var prev []undoOp
+			if err := json.Unmarshal([]byte(lastOps), &prev); err != nil {
+				return err
+			}
+			ops = mergeUndoOps(prev, ops)
+			js, err := json.Marshal(ops)
+			if err != nil {
+				return err
+			}
+			if _, err := r.tx.Exec("DELETE FROM undo_log WHERE undone = 1 AND sheet_id = ?", sheet); err != nil {
+				return err
+			}
+			_, err = r.tx.Exec("UPDATE undo_log SET ops = ?, updated_at = ? WHERE id = ?", string(js), now, lastID)
+			return err
+		}
+	}
+
+	js, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	// a new step invalidates the redo stack
+	if _, err := r.tx.Exec("DELETE FROM undo_log WHERE undone = 1 AND sheet_id = ?", sheet); err != nil {
+		return err
+	}
+	if _, err := r.tx.Exec("INSERT INTO undo_log (label, coalesce_key, ops, undone, updated_at, sheet_id) VALUES (?, ?, ?, 0, ?, ?)", label, coalesce, string(js), now, sheet); err != nil {
+		return err
+	}
+	// keep the newest undoMaxSteps steps of the sheet; other sheets keep theirs
+	_, err = r.tx.Exec(`DELETE FROM undo_log WHERE sheet_id = ? AND id <= (
+		SELECT id FROM undo_log WHERE sheet_id = ? ORDER BY id DESC LIMIT 1 OFFSET ?)`, sheet, sheet, undoMaxSteps)
+	return err
+}
+
+// mergeUndoOps keeps the earliest before and the latest after per record
+func mergeUndoOps(prev, next []undoOp) []undoOp {
+	out := append([]undoOp(nil), prev...)
+	for _, op := range next {
+		merged := false
+		for i := range out {
+			if out[i].Table == op.Table && out[i].ID == op.ID {
+				out[i].After = op.After
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			out = append(out, op)
+		}
+	}
+	return out
+}
+
+func sameState(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// withUndo runs fn in a transaction and records everything it touched as one undo step.
+// coalesce may be "" (always a new step) or a key merging consecutive steps (e.g. typing in a cell).
+func withUndo(db *sql.DB, label, coalesce string, fn func(tx *sql.Tx, rec *undoRecorder) error) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	rec := &undoRecorder{tx: tx, index: map[string]int{}}
+	if err = fn(tx, rec); err != nil {
+		return err
+	}
+	if err = rec.finish(label, coalesce); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// applyUndoStep moves one step of a sheet between the undo and redo stacks.
+// It returns the label of the step, or "" when there was nothing to do.
+func applyUndoStep(db *sql.DB, sheet int, redo bool) (label string, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	q := "SELECT id, label, ops FROM undo_log WHERE undone = 0 AND sheet_id = ? ORDER BY id DESC LIMIT 1"
+	if redo {
+		q = "SELECT id, label, ops FROM undo_log WHERE undone = 1 AND sheet_id = ? ORDER BY id ASC LIMIT 1"
+	}
+	var id int
+	var opsStr string
+	err = tx.QueryRow(q, sheet).Scan(&id, &label, &opsStr)
+	if err == sql.ErrNoRows {
+		return "", tx.Rollback()
+	}
+	if err != nil {
+		return "", err
+	}
+	var ops []undoOp
+	if err = json.Unmarshal([]byte(opsStr), &ops); err != nil {
+		return "", err
+	}
+	for i := range ops {
+		if ops[i].Sheet == 0 {
+			ops[i].Sheet = sheet
+		}
+	}
+
+	if redo {
+		for _, op := range ops {
+			if err = restoreRecord(tx, op.Table, op.ID, op.Sheet, op.After); err != nil {
+				return "", err
+			}
+		}
+		_, err = tx.Exec("UPDATE undo_log SET undone = 0 WHERE id = ?", id)
+	} else {
+		for i := len(ops) - 1; i >= 0; i-- {
+			if err = restoreRecord(tx, ops[i].Table, ops[i].ID, ops[i].Sheet, ops[i].Before); err != nil {
+				return "", err
+			}
+		}
+		_, err = tx.Exec("UPDATE undo_log SET undone = 1 WHERE id = ?", id)
+	}
+	if err != nil {
+		return "", err
+	}
+	return label, tx.Commit()
+}
+
+// undoLast reverts the latest step of a sheet
+func undoLast(db *sql.DB, sheet int) (string, error) {
+	return applyUndoStep(db, sheet, false)
+}
+
+// redoNext re-applies the latest undone step of a sheet
+func redoNext(db *sql.DB, sheet int) (string, error) {
+	return applyUndoStep(db, sheet, true)
+}
+
+// --- undoable variants of the row and view helpers --- //
+
+// undoableUpdateField is updateField recorded as an undo step; consecutive edits
+// of the same cell are merged into one step.
+func undoableUpdateField(db *sql.DB, schema []FieldDef, id int, field string, value interface{}) error {
+	return undoableSetCells(db, schema, "Edit "+field, cellCoalesceKey(id, field), []cellChange{{ID: id, Field: field, Value: value}})
+}
+
+// cellCoalesceKey merges consecutive undo steps editing the same cell
+func cellCoalesceKey(id int, field string) string {
+	return fmt.Sprintf("cell/%d/%s", id, field)
+}
+
+// undoableSetCells writes the values of cells in several rows in one transaction
+// recorded as one undo step (merged with the previous one when coalesce matches,
+// see withUndo); formula fields of the changed rows are recomputed
+func undoableSetCells(db *sql.DB, schema []FieldDef, label, coalesce string, changes []cellChange) error {
+	return withUndo(db, label, coalesce, func(tx *sql.Tx, rec *undoRecorder) error {
+		seen := map[int]bool{}
+		for _, c := range changes {
+			if seen[c.ID] {
+				continue
+			}
+			seen[c.ID] = true
+			if err := rec.touch("entries", c.ID); err != nil {
+				return err
+			}
+		}
+		return updateCells(tx, schema, changes)
+	})
+}
+
+// undoableInsertRow is insertRow recorded as an undo step
+func undoableInsertRow(db *sql.DB, sheet int, data map[string]interface{}) (id int64, err error) {
+	err = withUndo(db, "Add row", "", func(tx *sql.Tx, rec *undoRecorder) error {
+		id, err = insertRow(tx, sheet, data)
+		if err != nil {
+			return err
+		}
+		rec.created("entries", int(id))
+		return nil
+	})
+	return id, err
+}
+
+// undoableDeleteRow deletes a row (and the rows cascading from it, see deleteRows)
+// as an undo step
+func undoableDeleteRow(db *sql.DB, id int) error {
+	return withUndo(db, "Delete row", "", func(tx *sql.Tx, rec *undoRecorder) error {
+		return deleteRows(tx, rec, []int{id})
+	})
+}
+
+// undoableReplaceRow is replaceRow recorded as an undo step
+func undoableReplaceRow(db *sql.DB, id int, data map[string]interface{}) error {
+	return withUndo(db, "Edit row", "", func(tx *sql.Tx, rec *undoRecorder) error {
+		if err := rec.touch("entries", id); err != nil {
+			return err
+		}
+		return replaceRow(tx, id, data)
+	})
+}
+
+// undoableSaveView inserts (v.ID == 0) into sheet or updates a view as an undo step and returns its id
+func undoableSaveView(db *sql.DB, sheet int, v View) (id int64, err error) {
+	err = withUndo(db, "Save view "+v.Name, "", func(tx *sql.Tx, rec *undoRecorder) error {
+		if v.ID > 0 {
+			id = int64(v.ID)
+			if err := rec.touch("views", v.ID); err != nil {
+				return err
+			}
+			return updateView(tx, v)
+		}
+		id, err = insertView(tx, sheet, v)
+		if err != nil {
+			return err
+		}
+		rec.created("views", int(id))
+		return nil
+	})
+	return id, err
+}
+
+// undoableDeleteView is deleteView recorded as an undo step
+func undoableDeleteView(db *sql.DB, id int) error {
+	return withUndo(db, "Delete view", "", func(tx *sql.Tx, rec *undoRecorder) error {
+		if err := rec.touch("views", id); err != nil {
+			return err
+		}
+		return deleteView(tx, id)
+	})
+}
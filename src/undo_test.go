@@ -0,0 +1,322 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var undoTestSchema = []FieldDef{
+	{Name: "Name", Type: "string", Unique: true},
+	{Name: "Qty", Type: "int"},
+}
+
+// undoTestSheet adds a sheet with the rows a (Qty 1) and b (Qty 2) and the view
+// "Small" (Qty < 2), and returns the ids of the rows and the view
+func undoTestSheet(t *testing.T, db *sql.DB, name string) (Sheet, []int, int) {
+	t.Helper()
+	s := newTestSheet(t, db, name, undoTestSchema)
+	var ids []int
+	for i, n := range []string{"a", "b"} {
+		id, err := insertRow(db, s.ID, map[string]interface{}{"Name": n, "Qty": i + 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, int(id))
+	}
+	view, err := insertView(db, s.ID, View{Name: "Small", Filters: []ViewFilter{{Field: "Qty", Op: "lt", Value: "2"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, ids, int(view)
+}
+
+// undoTestState describes the rows and views of a sheet
+func undoTestState(t *testing.T, db *sql.DB, sheet int) string {
+	t.Helper()
+	rows, err := getAllRows(db, sheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []string
+	for _, r := range rows {
+		parts = append(parts, fmt.Sprintf("%d:%s=%v", r.ID, r.Data["Name"], r.Data["Qty"]))
+	}
+	views, err := getAllViews(db, sheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range views {
+		parts = append(parts, fmt.Sprintf("view %d:%s%v", v.ID, v.Name, v.Filters))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// undoSteps returns the number of undo steps (undone = 0) and redo steps of a sheet
+func undoSteps(t *testing.T, db *sql.DB, sheet int) (undo, redo int) {
+	t.Helper()
+	err := db.QueryRow("SELECT COUNT(*) FILTER (WHERE undone = 0), COUNT(*) FILTER (WHERE undone = 1) FROM undo_log WHERE sheet_id = ?", sheet).Scan(&undo, &redo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return undo, redo
+}
+
+func TestUndoRedo(t *testing.T) {
+	tests := []struct {
+		label  string
+		action func(db *sql.DB, sheet int, rows []int, view int) error
+	}{
+		{"Edit Qty", func(db *sql.DB, _ int, rows []int, _ int) error {
+			return undoableUpdateField(db, undoTestSchema, rows[0], "Qty", 5)
+		}},
+		{"Edit cells", func(db *sql.DB, _ int, rows []int, _ int) error {
+			return undoableSetCells(db, undoTestSchema, "Edit cells", "", []cellChange{
+				{ID: rows[0], Field: "Qty", Value: 7}, {ID: rows[1], Field: "Name", Value: "c"}, {ID: rows[0], Field: "Name", Value: "d"},
+			})
+		}},
+		{"Edit row", func(db *sql.DB, _ int, rows []int, _ int) error {
+			return undoableReplaceRow(db, rows[1], map[string]interface{}{"Name": "z"})
+		}},
+		{"Add row", func(db *sql.DB, sheet int, _ []int, _ int) error {
+			_, err := undoableInsertRow(db, sheet, map[string]interface{}{"Name": "new", "Qty": 9})
+			return err
+		}},
+		{"Delete row", func(db *sql.DB, _ int, rows []int, _ int) error {
+			return undoableDeleteRow(db, rows[0])
+		}},
+		{"Save view Big", func(db *sql.DB, sheet int, _ []int, _ int) error {
+			_, err := undoableSaveView(db, sheet, View{Name: "Big", Filters: []ViewFilter{{Field: "Qty", Op: "gt", Value: "1"}}})
+			return err
+		}},
+		{"Save view Tiny", func(db *sql.DB, sheet int, _ []int, view int) error {
+			_, err := undoableSaveView(db, sheet, View{ID: view, Name: "Tiny", Filters: []ViewFilter{{Field: "Qty", Op: "lt", Value: "1"}}})
+			return err
+		}},
+		{"Delete view", func(db *sql.DB, _ int, _ []int, view int) error {
+			return undoableDeleteView(db, view)
+		}},
+	}
+	for _, tt := range tests {
+		db := openTestDB(t)
+		s, rows, view := undoTestSheet(t, db, "Stock")
+		before := undoTestState(t, db, s.ID)
+		if err := tt.action(db, s.ID, rows, view); err != nil {
+			t.Errorf("%s: %v", tt.label, err)
+			continue
+		}
+		after := undoTestState(t, db, s.ID)
+		if after == before {
+			t.Errorf("%s: changed nothing", tt.label)
+		}
+
+		for _, step := range []struct {
+			redo bool
+			want string
+		}{{false, before}, {true, after}, {false, before}} {
+			label, err := applyUndoStep(db, s.ID, step.redo)
+			if err != nil || label != tt.label {
+				t.Errorf("%s: redo %v: label %q, %v", tt.label, step.redo, label, err)
+			}
+			if got := undoTestState(t, db, s.ID); got != step.want {
+				t.Errorf("%s: after redo %v:\n%s\nwant\n%s", tt.label, step.redo, got, step.want)
+			}
+		}
+		// only the undone step is left, to redo
+		if label, err := undoLast(db, s.ID); err != nil || label != "" {
+			t.Errorf("%s: undo with an empty stack: %q, %v", tt.label, label, err)
+		}
+		if undo, redo := undoSteps(t, db, s.ID); undo != 0 || redo != 1 {
+			t.Errorf("%s: %d undo and %d redo steps, want 0 and 1", tt.label, undo, redo)
+		}
+	}
+}
+
+func TestUndoCoalesce(t *testing.T) {
+	db := openTestDB(t)
+	s, rows, _ := undoTestSheet(t, db, "Stock")
+	before := undoTestState(t, db, s.ID)
+
+	// typing in a cell is one step
+	for _, v := range []int{3, 4, 5} {
+		if err := undoableUpdateField(db, undoTestSchema, rows[0], "Qty", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if undo, _ := undoSteps(t, db, s.ID); undo != 1 {
+		t.Errorf("%d steps for three edits of one cell, want 1", undo)
+	}
+	// another cell starts a new step, and so does the first cell after it
+	if err := undoableUpdateField(db, undoTestSchema, rows[1], "Qty", 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := undoableUpdateField(db, undoTestSchema, rows[0], "Qty", 7); err != nil {
+		t.Fatal(err)
+	}
+	if undo, _ := undoSteps(t, db, s.ID); undo != 3 {
+		t.Errorf("%d steps after editing two more cells, want 3", undo)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := undoLast(db, s.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := undoTestState(t, db, s.ID); got != before {
+		t.Errorf("after undoing everything:\n%s\nwant\n%s", got, before)
+	}
+
+	// a coalesced edit drops the redo stack like any new step
+	if _, err := redoNext(db, s.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := undoableUpdateField(db, undoTestSchema, rows[0], "Qty", 8); err != nil {
+		t.Fatal(err)
+	}
+	if undo, redo := undoSteps(t, db, s.ID); undo != 1 || redo != 0 {
+		t.Errorf("%d undo and %d redo steps after a coalesced edit, want 1 and 0", undo, redo)
+	}
+	if _, err := undoLast(db, s.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := undoTestState(t, db, s.ID); got != before {
+		t.Errorf("after undoing the coalesced edits:\n%s\nwant\n%s", got, before)
+	}
+}
+
+func TestUndoImport(t *testing.T) {
+	db := openTestDB(t)
+	s, _, _ := undoTestSheet(t, db, "Stock")
+	before := undoTestState(t, db, s.ID)
+
+	in := &jsonImport{
+		Entries:  []map[string]interface{}{{"Name": "x", "Qty": 1.0}, {"Name": "y", "Qty": 2.0}},
+		Views:    []View{{Name: "Imported"}},
+		HasViews: true,
+	}
+	p, err := planImport(db, s.ID, undoTestSchema, in, importReplace, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyImport(db, p); err != nil {
+		t.Fatal(err)
+	}
+	after := undoTestState(t, db, s.ID)
+	if !strings.Contains(after, ":x=") || !strings.Contains(after, "Imported") || strings.Contains(after, "Small") {
+		t.Fatalf("import: %s", after)
+	}
+
+	if label, err := undoLast(db, s.ID); err != nil || label != "Import" {
+		t.Fatalf("undo: %q, %v", label, err)
+	}
+	if got := undoTestState(t, db, s.ID); got != before {
+		t.Errorf("after undoing the import:\n%s\nwant\n%s", got, before)
+	}
+	if _, err := redoNext(db, s.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := undoTestState(t, db, s.ID); got != after {
+		t.Errorf("after redoing the import:\n%s\nwant\n%s", got, after)
+	}
+}
+
+func TestUndoRevisions(t *testing.T) {
+	db := openTestDB(t)
+	s, rows, _ := undoTestSheet(t, db, "Stock")
+	if err := undoableUpdateField(db, undoTestSchema, rows[0], "Qty", 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := undoLast(db, s.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := undoableDeleteRow(db, rows[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := undoLast(db, s.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := redoNext(db, s.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		row  int
+		want []string // field old>new, newest first
+	}{
+		// the edit merges into the recent creation, the undo is a revision of its own
+		{rows[0], []string{"Qty 5>1", "Qty >5", "Name >\"a\""}},
+		// deleted, restored by undo and deleted again by redo
+		{rows[1], []string{" deleted", "Qty >2", "Name >\"b\"", " deleted", "Qty >2", "Name >\"b\""}},
+	}
+	for _, tt := range tests {
+		revs, err := getRowRevisions(db, tt.row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range revs {
+			if r.Field == "" {
+				got = append(got, " deleted")
+				continue
+			}
+			got = append(got, fmt.Sprintf("%s %s>%s", r.Field, r.OldValue, r.NewValue))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("revisions of row %d: %q, want %q", tt.row, got, tt.want)
+		}
+	}
+}
+
+func TestUndoPerSheet(t *testing.T) {
+	db := openTestDB(t)
+	a, rowsA, _ := undoTestSheet(t, db, "A")
+	b, rowsB, _ := undoTestSheet(t, db, "B")
+	if err := undoableUpdateField(db, undoTestSchema, rowsB[0], "Qty", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := undoableUpdateField(db, undoTestSchema, rowsB[1], "Qty", 20); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := undoLast(db, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	stateB := undoTestState(t, db, b.ID)
+
+	// more steps than a sheet keeps; the oldest are pruned and B keeps its own
+	for i := 0; i <= undoMaxSteps; i++ {
+		if err := undoableSetCells(db, undoTestSchema, "Edit Qty", "", []cellChange{{ID: rowsA[0], Field: "Qty", Value: i + 100}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if undo, redo := undoSteps(t, db, a.ID); undo != undoMaxSteps || redo != 0 {
+		t.Errorf("sheet A: %d undo and %d redo steps, want %d and 0", undo, redo, undoMaxSteps)
+	}
+	if undo, redo := undoSteps(t, db, b.ID); undo != 1 || redo != 1 {
+		t.Errorf("sheet B: %d undo and %d redo steps, want 1 and 1", undo, redo)
+	}
+
+	// undoing everything in A stops at the oldest kept step
+	for {
+		label, err := undoLast(db, a.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if label == "" {
+			break
+		}
+	}
+	if data, err := loadRowData(db, rowsA[0]); err != nil || data["Qty"] != 100.0 {
+		t.Errorf("sheet A after undoing all kept steps: %v, %v; want Qty 100", data, err)
+	}
+	if got := undoTestState(t, db, b.ID); got != stateB {
+		t.Errorf("sheet B changed by the steps of A:\n%s\nwant\n%s", got, stateB)
+	}
+
+	if label, err := redoNext(db, b.ID); err != nil || label != "Edit Qty" {
+		t.Errorf("redo in B: %q, %v", label, err)
+	}
+	if data, err := loadRowData(db, rowsB[1]); err != nil || data["Qty"] != 20.0 {
+		t.Errorf("sheet B after redo: %v, %v; want Qty 20", data, err)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// compileFieldRules compiles the Pattern of every field and checks the relation,
+// lookup and rollup settings; an invalid pattern or setting is a config error
+func compileFieldRules(schema []FieldDef) error {
+	for i := range schema {
+		schema[i].pattern = nil
+		switch schema[i].Type {
+		case "relation":
+			if err := checkRelationField(schema[i]); err != nil {
+				return err
+			}
+		case "lookup", "rollup":
+			if err := checkLookupField(schema, schema[i]); err != nil {
+				return err
+			}
+		}
+		if schema[i].Pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(schema[i].Pattern)
+		if err != nil {
+			return fmt.Errorf("field %s: invalid pattern: %v", schema[i].Name, err)
+		}
+		schema[i].pattern = re
+	}
+	return nil
+}
+
+// isEmptyValue reports whether a value counts as missing for the Required rule
+func isEmptyValue(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(t) == ""
+	case []string:
+		return len(t) == 0
+	case []int:
+		return len(t) == 0
+	case []interface{}:
+		return len(t) == 0
+	}
+	return false
+}
+
+// numericValue returns v as a float64 if it is a number
+func numericValue(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case int:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	}
+	return 0, false
+}
+
+// validateFieldValue checks v against the rules of f, except Unique which needs
+// the other rows (see uniqueViolations). It returns nil for a valid value.
+func validateFieldValue(f FieldDef, v interface{}) error {
+	if isEmptyValue(v) {
+		if f.Required {
+			return fmt.Errorf("required")
+		}
+		if f.Type != "[]string" {
+			return nil
+		}
+	}
+
+	switch f.Type {
+	case "int", "float":
+		n, ok := numericValue(v)
+		if !ok {
+			return nil
+		}
+		if f.Min != nil && n < *f.Min {
+			return fmt.Errorf("must be at least %s", floatText(*f.Min))
+		}
+		if f.Max != nil && n > *f.Max {
+			return fmt.Errorf("must be at most %s", floatText(*f.Max))
+		}
+	case "bool":
+	case "relation":
+		ids := relationIDs(v)
+		if f.MinItems != nil && len(ids) < *f.MinItems {
+			return fmt.Errorf("needs at least %d rows", *f.MinItems)
+		}
+		if f.MaxItems != nil && len(ids) > *f.MaxItems {
+			return fmt.Errorf("allows at most %d rows", *f.MaxItems)
+		}
+	case "[]string":
+		list := toStringList(v)
+		if f.MinItems != nil && len(list) < *f.MinItems {
+			return fmt.Errorf("needs at least %d items", *f.MinItems)
+		}
+		if f.MaxItems != nil && len(list) > *f.MaxItems {
+			return fmt.Errorf("allows at most %d items", *f.MaxItems)
+		}
+		for _, it := range list {
+			if err := validateText(f, it); err != nil {
+				return fmt.Errorf("item %q %v", it, err)
+			}
+		}
+	default:
+		return validateText(f, formatFieldValue(f, v, ""))
+	}
+	return nil
+}
+
+// validateText applies the MaxLength and Pattern rules to a text value
+func validateText(f FieldDef, s string) error {
+	if f.MaxLength > 0 && utf8.RuneCountInString(s) > f.MaxLength {
+		return fmt.Errorf("is longer than %d characters", f.MaxLength)
+	}
+	if f.pattern != nil && !f.pattern.MatchString(s) {
+		return fmt.Errorf("does not match %s", f.Pattern)
+	}
+	return nil
+}
+
+// validateRow checks every schema field of data and returns the problems by field name
+func validateRow(schema []FieldDef, data map[string]interface{}) map[string]string {
+	var out map[string]string
+	for _, f := range schema {
+		if isComputedField(f) || (f.Type == "int" && strings.EqualFold(f.Name, "ID")) {
+			continue
+		}
+		if err := validateFieldValue(f, data[f.Name]); err != nil {
+			if out == nil {
+				out = map[string]string{}
+			}
+			out[f.Name] = err.Error()
+		}
+	}
+	return out
+}
+
+// problemText joins the problems of a row into one line, ordered by field name
+func problemText(probs map[string]string) string {
+	names := make([]string, 0, len(probs))
+	for n := range probs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = n + ": " + probs[n]
+	}
+	return strings.Join(parts, "; ")
+}
+
+// uniqueViolations returns, for each Unique field, the ids of rows whose
+// non-empty value is shared with another row of the sheet.
+func uniqueViolations(db sqlExecer, sheet int, schema []FieldDef) (map[string]map[int]bool, error) {
+	out := map[string]map[int]bool{}
+	for _, f := range schema {
+		if !f.Unique || (f.Type == "int" && strings.EqualFold(f.Name, "ID")) {
+			continue
+		}
+		ids, err := duplicateRowIDs(db, sheet, f)
+		if err != nil {
+			return nil, err
+		}
+		out[f.Name] = ids
+	}
+	return out, nil
+}
+
+// duplicateRowIDs returns the ids of rows of a sheet sharing a non-empty value of field f
+func duplicateRowIDs(db sqlExecer, sheet int, f FieldDef) (map[int]bool, error) {
+	path := jsonPath(f.Name)
+	rows, err := db.Query(`SELECT id FROM entries WHERE sheet_id = ? AND json_extract(data, ?) IN (
+		SELECT json_extract(data, ?) AS v FROM entries
+		WHERE sheet_id = ? AND v IS NOT NULL AND v NOT IN ('', '[]')
+		GROUP BY v HAVING COUNT(*) > 1)`, sheet, path, path, sheet)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// valueTaken reports whether another row of the sheet than exceptID holds value in field f
+func valueTaken(db sqlExecer, sheet int, f FieldDef, value interface{}, exceptID int) (bool, error) {
+	if isEmptyValue(value) {
+		return false, nil
+	}
+	arg := value
+	switch value.(type) {
+	case []string, []interface{}:
+		js, err := json.Marshal(toStringList(value))
+		if err != nil {
+			return false, err
+		}
+		arg = string(js)
+	case bool:
+		// json_extract returns 1/0 for JSON booleans
+		if value.(bool) {
+			arg = 1
+		} else {
+			arg = 0
+		}
+	}
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM entries WHERE sheet_id = ? AND json_extract(data, ?) = ? AND id != ?", sheet, jsonPath(f.Name), arg, exceptID).Scan(&n)
+	return n > 0, err
+}